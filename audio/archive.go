@@ -0,0 +1,164 @@
+package audio
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Encoder compresses a finished WAV file into another format
+type Encoder interface {
+	// Extension returns the file extension of the encoded output, without the dot
+	Extension() string
+	// Encode writes the compressed version of inputPath to outputPath
+	Encode(inputPath, outputPath string) error
+}
+
+// CommandEncoder encodes by running an external program. Args may contain
+// {input} and {output}, which are replaced by the file paths.
+type CommandEncoder struct {
+	Format  string // Output file extension, e.g. "flac"
+	Command string
+	Args    []string
+}
+
+// NewFFmpegEncoder returns an encoder that converts to the given format with ffmpeg,
+// which picks the codec from the extension (flac, mp3, opus, ...)
+func NewFFmpegEncoder(format string) *CommandEncoder {
+	return &CommandEncoder{
+		Format:  format,
+		Command: "ffmpeg",
+		Args:    []string{"-hide_banner", "-loglevel", "error", "-y", "-i", "{input}", "{output}"},
+	}
+}
+
+// Extension returns the output format's file extension
+func (e *CommandEncoder) Extension() string {
+	return e.Format
+}
+
+// Encode runs the command on one file
+func (e *CommandEncoder) Encode(inputPath, outputPath string) error {
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		arg = strings.ReplaceAll(arg, "{input}", inputPath)
+		args[i] = strings.ReplaceAll(arg, "{output}", outputPath)
+	}
+
+	output, err := exec.Command(e.Command, args...).CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("%s: %v: %s", e.Command, err, message)
+		}
+		return fmt.Errorf("%s: %v", e.Command, err)
+	}
+	return nil
+}
+
+// Archiver encodes finished WAV files in the background and deletes each WAV once
+// its compressed copy has been written. A file that fails to encode is kept as WAV.
+// Submit it from the recorder's file-complete handler to keep a rolling archive.
+type Archiver struct {
+	encoder     Encoder
+	jobs        chan string
+	waitGroup   sync.WaitGroup
+	outputMutex sync.Mutex
+	outputs     []string // Where each submitted file ended up
+}
+
+// archiveQueueSize is how many finished files can wait for encoding before Submit
+// keeps further ones as WAV
+const archiveQueueSize = 16
+
+// NewArchiver starts a background archiver using the given encoder
+func NewArchiver(encoder Encoder) *Archiver {
+	a := &Archiver{
+		encoder: encoder,
+		jobs:    make(chan string, archiveQueueSize),
+	}
+
+	a.waitGroup.Add(1)
+	go a.archiveRoutine()
+
+	return a
+}
+
+// Submit queues a finished WAV file for encoding. It runs on the recorder's writer, so
+// it never waits: when encoding falls so far behind that the queue is full, the file
+// is kept as WAV with a warning.
+func (a *Archiver) Submit(path string) {
+	select {
+	case a.jobs <- path:
+	default:
+		fmt.Fprintf(os.Stderr, "\nWarning: archive queue full, keeping %s as WAV\n", path)
+		a.addOutput(path)
+	}
+}
+
+// Close waits for every queued file to be encoded and stops the archiver
+func (a *Archiver) Close() {
+	close(a.jobs)
+	a.waitGroup.Wait()
+}
+
+// Outputs returns the path each submitted file ended up at: its compressed copy, or
+// the WAV file itself when it was kept. Call it after Close for the complete list.
+func (a *Archiver) Outputs() []string {
+	a.outputMutex.Lock()
+	defer a.outputMutex.Unlock()
+
+	return slices.Clone(a.outputs)
+}
+
+// addOutput records where a submitted file ended up
+func (a *Archiver) addOutput(path string) {
+	a.outputMutex.Lock()
+	defer a.outputMutex.Unlock()
+
+	a.outputs = append(a.outputs, path)
+}
+
+// archiveRoutine encodes queued files one at a time
+func (a *Archiver) archiveRoutine() {
+	defer a.waitGroup.Done()
+
+	for path := range a.jobs {
+		if err := a.archive(path); err != nil {
+			fmt.Fprintf(os.Stderr, "\nKeeping %s, archiving failed: %v\n", path, err)
+			a.addOutput(path)
+		} else {
+			a.addOutput(encodedPath(path, a.encoder))
+		}
+	}
+}
+
+// encodedPath returns the path of the compressed copy of a WAV file
+func encodedPath(path string, encoder Encoder) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + encoder.Extension()
+}
+
+// archive encodes one file and removes the WAV only after the encode succeeded
+func (a *Archiver) archive(path string) error {
+	outputPath := encodedPath(path, a.encoder)
+
+	if err := a.encoder.Encode(path, outputPath); err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+
+	// Never trust an encoder that reported success without producing output
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		os.Remove(outputPath)
+		return fmt.Errorf("encoder produced an empty file")
+	}
+
+	return os.Remove(path)
+}
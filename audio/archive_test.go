@@ -0,0 +1,185 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeEncoder writes output to the encoded file and returns err. With started set it
+// announces each encode, and with release set it waits for it before finishing.
+type fakeEncoder struct {
+	output  []byte
+	err     error
+	started chan string
+	release chan struct{}
+}
+
+func (e *fakeEncoder) Extension() string {
+	return "fake"
+}
+
+func (e *fakeEncoder) Encode(inputPath, outputPath string) error {
+	if e.started != nil {
+		e.started <- inputPath
+	}
+	if e.release != nil {
+		<-e.release
+	}
+	if e.output != nil {
+		if err := os.WriteFile(outputPath, e.output, 0644); err != nil {
+			return err
+		}
+	}
+	return e.err
+}
+
+// writeWAVFiles creates count small files to archive and returns their paths
+func writeWAVFiles(t *testing.T, count int) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i := range count {
+		path := filepath.Join(dir, fmt.Sprintf("part_%03d.wav", i+1))
+		if err := os.WriteFile(path, []byte("RIFF"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// exists reports whether a file is present
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestArchiverKeepsWAVUnlessEncoded(t *testing.T) {
+	tests := []struct {
+		name       string
+		encoder    *fakeEncoder
+		keepWAV    bool
+		keepOutput bool
+	}{
+		{"Encoded", &fakeEncoder{output: []byte("compressed")}, false, true},
+		{"EmptyOutput", &fakeEncoder{output: []byte{}}, true, false},
+		{"NoOutput", &fakeEncoder{}, true, false},
+		{"Failed", &fakeEncoder{output: []byte("partial"), err: errors.New("encoder crashed")}, true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeWAVFiles(t, 1)[0]
+			outputPath := strings.TrimSuffix(path, ".wav") + ".fake"
+
+			archiver := NewArchiver(tc.encoder)
+			archiver.Submit(path)
+			archiver.Close()
+
+			if exists(path) != tc.keepWAV {
+				t.Errorf("WAV kept = %v, want %v", exists(path), tc.keepWAV)
+			}
+			if exists(outputPath) != tc.keepOutput {
+				t.Errorf("encoded file kept = %v, want %v", exists(outputPath), tc.keepOutput)
+			}
+
+			// The file is reported where it ended up
+			want := path
+			if tc.keepOutput {
+				want = outputPath
+			}
+			if outputs := archiver.Outputs(); !slices.Equal(outputs, []string{want}) {
+				t.Errorf("Outputs = %v, want %v", outputs, []string{want})
+			}
+		})
+	}
+}
+
+func TestArchiverSubmitWhenQueueFull(t *testing.T) {
+	encoder := &fakeEncoder{
+		output:  []byte("compressed"),
+		started: make(chan string, archiveQueueSize+2),
+		release: make(chan struct{}),
+	}
+	archiver := NewArchiver(encoder)
+	paths := writeWAVFiles(t, archiveQueueSize+2)
+
+	// The first file is being encoded and the next fill the queue, so the last one
+	// finds no room; Submit must return rather than stall the recorder's writer
+	archiver.Submit(paths[0])
+	<-encoder.started
+	withinDeadline(t, "Submit with a full queue", func() {
+		for _, path := range paths[1:] {
+			archiver.Submit(path)
+		}
+	})
+	close(encoder.release)
+	archiver.Close()
+
+	last := len(paths) - 1
+	for i, path := range paths {
+		if kept := exists(path); kept != (i == last) {
+			t.Errorf("%s kept as WAV = %v, want %v", filepath.Base(path), kept, i == last)
+		}
+	}
+
+	// The file kept as WAV is reported as soon as it is turned away, ahead of the rest
+	outputs := archiver.Outputs()
+	if len(outputs) != len(paths) || outputs[0] != paths[last] {
+		t.Errorf("Outputs = %v, want %d files starting with %s", outputs, len(paths), filepath.Base(paths[last]))
+	}
+}
+
+func TestCommandEncoder(t *testing.T) {
+	for _, command := range []string{"cp", "sh"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s not available: %v", command, err)
+		}
+	}
+
+	// Paths with spaces reach the command as single arguments
+	dir := filepath.Join(t.TempDir(), "with space")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "in put.wav")
+	if err := os.WriteFile(input, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Substitution", func(t *testing.T) {
+		output := filepath.Join(dir, "out put.copy")
+		encoder := &CommandEncoder{Format: "copy", Command: "cp", Args: []string{"{input}", "{output}"}}
+		if err := encoder.Encode(input, output); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if data, err := os.ReadFile(output); err != nil || string(data) != "audio" {
+			t.Errorf("output = %q, %v; want a copy of the input", data, err)
+		}
+	})
+
+	t.Run("SubstitutionWithinArgument", func(t *testing.T) {
+		output := filepath.Join(dir, "args.txt")
+		encoder := &CommandEncoder{Command: "sh",
+			Args: []string{"-c", `printf %s "$1" > "$2"`, "sh", "from={input}", "{output}"}}
+		if err := encoder.Encode(input, output); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if data, err := os.ReadFile(output); err != nil || string(data) != "from="+input {
+			t.Errorf("argument = %q, %v; want %q", data, err, "from="+input)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		encoder := &CommandEncoder{Command: "sh", Args: []string{"-c", "echo unsupported codec >&2; exit 1"}}
+		err := encoder.Encode(input, filepath.Join(dir, "failed.out"))
+		if err == nil || !strings.Contains(err.Error(), "unsupported codec") {
+			t.Errorf("Encode = %v, want the command's message", err)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package audio
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/gen2brain/malgo"
+)
+
+// backendNames maps each audio backend to its command line name
+var backendNames = map[malgo.Backend]string{
+	malgo.BackendWasapi:     "wasapi",
+	malgo.BackendDsound:     "dsound",
+	malgo.BackendWinmm:      "winmm",
+	malgo.BackendCoreaudio:  "coreaudio",
+	malgo.BackendSndio:      "sndio",
+	malgo.BackendAudio4:     "audio4",
+	malgo.BackendOss:        "oss",
+	malgo.BackendPulseaudio: "pulseaudio",
+	malgo.BackendAlsa:       "alsa",
+	malgo.BackendJack:       "jack",
+	malgo.BackendAaudio:     "aaudio",
+	malgo.BackendOpensl:     "opensl",
+	malgo.BackendWebaudio:   "webaudio",
+	malgo.BackendNull:       "null",
+}
+
+// BackendName returns the command line name of an audio backend
+func BackendName(backend malgo.Backend) string {
+	if name, ok := backendNames[backend]; ok {
+		return name
+	}
+	return fmt.Sprintf("Backend(%d)", int(backend))
+}
+
+// ParseBackend converts a command line name into an audio backend
+func ParseBackend(name string) (malgo.Backend, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for backend, backendName := range backendNames {
+		if backendName == name {
+			return backend, nil
+		}
+	}
+	return malgo.BackendNull, fmt.Errorf("unknown audio backend %q", name)
+}
+
+// PlatformBackends returns the backends built in on this platform, in the order the
+// audio library tries them by default:
+//
+//   - Windows: wasapi, dsound, winmm
+//   - macOS: coreaudio
+//   - Linux: pulseaudio, alsa, jack
+//   - BSD: sndio, audio4, oss
+//   - Android: aaudio, opensl
+//
+// The null backend, which has no real devices, is available everywhere.
+func PlatformBackends() []malgo.Backend {
+	var backends []malgo.Backend
+	switch runtime.GOOS {
+	case "windows":
+		backends = []malgo.Backend{malgo.BackendWasapi, malgo.BackendDsound, malgo.BackendWinmm}
+	case "darwin", "ios":
+		backends = []malgo.Backend{malgo.BackendCoreaudio}
+	case "linux":
+		backends = []malgo.Backend{malgo.BackendPulseaudio, malgo.BackendAlsa, malgo.BackendJack}
+	case "freebsd", "openbsd", "netbsd", "dragonfly":
+		backends = []malgo.Backend{malgo.BackendSndio, malgo.BackendAudio4, malgo.BackendOss}
+	case "android":
+		backends = []malgo.Backend{malgo.BackendAaudio, malgo.BackendOpensl}
+	}
+	return append(backends, malgo.BackendNull)
+}
+
+// BackendAvailable checks that a backend can be initialized on this system, e.g. that
+// its audio server is running. It returns the reason when it can't.
+func BackendAvailable(backend malgo.Backend) error {
+	ctx, err := malgo.InitContext([]malgo.Backend{backend}, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return err
+	}
+	ctx.Uninit()
+	ctx.Free()
+	return nil
+}
@@ -0,0 +1,199 @@
+package audio
+
+import (
+	"sync"
+	"time"
+)
+
+// BroadcastBuffer is a bounded audio buffer read by any number of independent readers.
+// Each reader tracks its own cursor, and samples are kept until the slowest reader has
+// consumed them. When the buffer grows past its capacity the oldest samples are dropped
+// and readers that had not reached them skip ahead. The most recent write is always
+// kept whole, so a reader that consumes after every write never loses samples.
+type BroadcastBuffer struct {
+	samples    []float32
+	startPos   int64 // Absolute position of samples[0]
+	marks      []timeMark
+	readers    map[*BroadcastReader]struct{}
+	sampleRate int
+	channels   int
+	maxSamples int
+	mutex      sync.Mutex
+}
+
+// timeMark records the capture time of the sample at an absolute position
+type timeMark struct {
+	position  int64
+	timestamp time.Time
+}
+
+// BroadcastReader is one consumer's cursor into a BroadcastBuffer
+type BroadcastReader struct {
+	buffer   *BroadcastBuffer
+	position int64
+	dropped  int64
+}
+
+// NewBroadcastBuffer creates a broadcast buffer holding up to maxSeconds of audio
+func NewBroadcastBuffer(sampleRate, channels int, maxSeconds float64) *BroadcastBuffer {
+	return &BroadcastBuffer{
+		samples:    make([]float32, 0),
+		readers:    make(map[*BroadcastReader]struct{}),
+		sampleRate: sampleRate,
+		channels:   channels,
+		maxSamples: int(maxSeconds * float64(sampleRate*channels)),
+	}
+}
+
+// Write appends samples captured starting at timestamp
+func (b *BroadcastBuffer) Write(samples []float32, timestamp time.Time) {
+	if len(samples) == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.marks = append(b.marks, timeMark{position: b.endPos(), timestamp: timestamp})
+	b.samples = append(b.samples, samples...)
+
+	// Enforce the capacity, but never drop any of the samples just written
+	limit := b.maxSamples
+	if len(samples) > limit {
+		limit = len(samples)
+	}
+	if excess := len(b.samples) - limit; excess > 0 {
+		b.discard(b.startPos + int64(excess))
+	}
+
+	b.trim()
+}
+
+// NewReader registers a reader that starts at the newest sample, so it only sees
+// audio written after this call
+func (b *BroadcastBuffer) NewReader() *BroadcastReader {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	reader := &BroadcastReader{buffer: b, position: b.endPos()}
+	b.readers[reader] = struct{}{}
+	return reader
+}
+
+// SampleRate returns the sample rate of the buffered audio
+func (b *BroadcastBuffer) SampleRate() int {
+	return b.sampleRate
+}
+
+// Channels returns the number of interleaved channels in the buffered audio
+func (b *BroadcastBuffer) Channels() int {
+	return b.channels
+}
+
+// Read returns every sample the reader has not seen yet and the capture time of the
+// first of them. Samples returned are a copy the caller may keep.
+func (r *BroadcastReader) Read() ([]float32, time.Time) {
+	b := r.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	start := int(r.position - b.startPos)
+	if start >= len(b.samples) {
+		return nil, time.Time{}
+	}
+
+	samplesCopy := make([]float32, len(b.samples)-start)
+	copy(samplesCopy, b.samples[start:])
+	timestamp := b.timeAt(r.position)
+
+	r.position = b.endPos()
+	b.trim()
+
+	return samplesCopy, timestamp
+}
+
+// Available returns how many samples the reader has not consumed yet
+func (r *BroadcastReader) Available() int {
+	r.buffer.mutex.Lock()
+	defer r.buffer.mutex.Unlock()
+
+	return int(r.buffer.endPos() - r.position)
+}
+
+// Dropped returns how many samples this reader missed because it fell too far behind
+func (r *BroadcastReader) Dropped() int64 {
+	r.buffer.mutex.Lock()
+	defer r.buffer.mutex.Unlock()
+
+	return r.dropped
+}
+
+// Close unregisters the reader so it no longer holds samples in the buffer
+func (r *BroadcastReader) Close() {
+	b := r.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.readers, r)
+	b.trim()
+}
+
+// endPos returns the absolute position just past the newest sample
+func (b *BroadcastBuffer) endPos() int64 {
+	return b.startPos + int64(len(b.samples))
+}
+
+// timeAt returns the capture time of the sample at an absolute position
+func (b *BroadcastBuffer) timeAt(position int64) time.Time {
+	for i := len(b.marks) - 1; i >= 0; i-- {
+		mark := b.marks[i]
+		if mark.position <= position {
+			frames := (position - mark.position) / int64(b.channels)
+			return mark.timestamp.Add(time.Duration(frames) * time.Second / time.Duration(b.sampleRate))
+		}
+	}
+	return time.Time{}
+}
+
+// trim drops samples that every reader has already consumed
+func (b *BroadcastBuffer) trim() {
+	oldest := b.endPos()
+	for reader := range b.readers {
+		if reader.position < oldest {
+			oldest = reader.position
+		}
+	}
+	b.discard(oldest)
+}
+
+// discard drops samples before an absolute position, moving lagging readers forward
+func (b *BroadcastBuffer) discard(position int64) {
+	count := int(position - b.startPos)
+	if count <= 0 {
+		return
+	}
+
+	// Keep the timestamp of the new first sample before dropping its mark
+	firstTime := b.timeAt(position)
+
+	b.samples = append(b.samples[:0:0], b.samples[count:]...)
+	b.startPos = position
+
+	for reader := range b.readers {
+		if reader.position < position {
+			reader.dropped += position - reader.position
+			reader.position = position
+		}
+	}
+
+	marks := make([]timeMark, 0, len(b.marks)+1)
+	if len(b.samples) > 0 && !firstTime.IsZero() {
+		marks = append(marks, timeMark{position: position, timestamp: firstTime})
+	}
+	for _, mark := range b.marks {
+		if mark.position > position {
+			marks = append(marks, mark)
+		}
+	}
+	b.marks = marks
+}
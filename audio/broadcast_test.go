@@ -0,0 +1,150 @@
+package audio
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ramp returns count samples counting up from first
+func ramp(first, count int) []float32 {
+	samples := make([]float32, count)
+	for i := range samples {
+		samples[i] = float32(first + i)
+	}
+	return samples
+}
+
+func TestBroadcastMarks(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	buffer := NewBroadcastBuffer(100, 2, 10)
+	early := buffer.NewReader()
+	late := buffer.NewReader()
+
+	// Two writes with a jump between them, e.g. after a gap in capture
+	buffer.Write(ramp(0, 20), start)
+	if samples, timestamp := early.Read(); len(samples) != 20 || !timestamp.Equal(start) {
+		t.Errorf("first Read = %d samples at %v, want 20 at %v", len(samples), timestamp, start)
+	}
+	resumed := start.Add(time.Minute)
+	buffer.Write(ramp(20, 20), resumed)
+
+	// Each read is stamped with the capture time of its own first sample
+	if samples, timestamp := early.Read(); samples[0] != 20 || !timestamp.Equal(resumed) {
+		t.Errorf("second Read starts with %v at %v, want 20 at %v", samples[0], timestamp, resumed)
+	}
+	if samples, timestamp := late.Read(); len(samples) != 40 || !timestamp.Equal(start) {
+		t.Errorf("Read across both writes = %d samples at %v, want 40 at %v", len(samples), timestamp, start)
+	}
+
+	// A reader created later starts after everything written so far
+	if samples, timestamp := buffer.NewReader().Read(); samples != nil || !timestamp.IsZero() {
+		t.Errorf("new reader read %d samples at %v, want nothing", len(samples), timestamp)
+	}
+}
+
+func TestBroadcastTrim(t *testing.T) {
+	buffer := NewBroadcastBuffer(100, 1, 10)
+	fast := buffer.NewReader()
+	slow := buffer.NewReader()
+
+	buffer.Write(ramp(0, 50), time.Now())
+	fast.Read()
+	buffer.Write(ramp(50, 50), time.Now())
+	fast.Read()
+
+	// Audio stays until the slowest reader has it, and goes once every reader has
+	if len(buffer.samples) != 100 {
+		t.Errorf("kept %d samples for the slow reader, want 100", len(buffer.samples))
+	}
+	if samples, _ := slow.Read(); !slices.Equal(samples, ramp(0, 100)) {
+		t.Errorf("slow reader got %d samples, want all 100 in order", len(samples))
+	}
+	if len(buffer.samples) != 0 {
+		t.Errorf("kept %d samples every reader has read", len(buffer.samples))
+	}
+
+	// A closed reader no longer holds audio back
+	buffer.Write(ramp(100, 50), time.Now())
+	slow.Close()
+	fast.Read()
+	if len(buffer.samples) != 0 {
+		t.Errorf("kept %d samples for a closed reader", len(buffer.samples))
+	}
+}
+
+func TestBroadcastSlowReaderFallsBehind(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	buffer := NewBroadcastBuffer(100, 1, 1) // Holds 100 samples
+	fast := buffer.NewReader()
+	slow := buffer.NewReader()
+
+	// The fast reader keeps up with 40-sample writes; the slow one never reads
+	for i := range 5 {
+		buffer.Write(ramp(i*40, 40), start.Add(time.Duration(i)*400*time.Millisecond))
+		if samples, _ := fast.Read(); len(samples) != 40 {
+			t.Fatalf("fast reader got %d samples of write %d, want 40", len(samples), i)
+		}
+	}
+	if fast.Dropped() != 0 {
+		t.Errorf("fast reader dropped %d samples", fast.Dropped())
+	}
+
+	// The slow reader skipped the oldest 100 of 200 samples and resumes with the newest
+	// 100, stamped with their own capture time
+	if available, dropped := slow.Available(), slow.Dropped(); available != 100 || dropped != 100 {
+		t.Errorf("slow reader has %d available and %d dropped, want 100 and 100", available, dropped)
+	}
+	samples, timestamp := slow.Read()
+	if !slices.Equal(samples, ramp(100, 100)) {
+		t.Errorf("slow reader got %v..., want the newest 100 samples", samples[:min(3, len(samples))])
+	}
+	if want := start.Add(time.Second); !timestamp.Equal(want) {
+		t.Errorf("slow reader resumed at %v, want %v", timestamp, want)
+	}
+
+	// A write larger than the capacity is kept whole
+	buffer.Write(ramp(0, 150), start.Add(2*time.Second))
+	if samples, _ := slow.Read(); len(samples) != 150 {
+		t.Errorf("read %d samples of a 150-sample write, want all of them", len(samples))
+	}
+}
+
+func TestBroadcastConcurrentReaders(t *testing.T) {
+	const writes, chunk = 200, 64
+	buffer := NewBroadcastBuffer(1000, 1, 60)
+	readers := []*BroadcastReader{buffer.NewReader(), buffer.NewReader()}
+
+	// Both readers consume while the writer writes, each at its own pace, and each gets
+	// the whole stream in order
+	var waitGroup sync.WaitGroup
+	got := make([][]float32, len(readers))
+	for i, reader := range readers {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for len(got[i]) < writes*chunk {
+				samples, _ := reader.Read()
+				got[i] = append(got[i], samples...)
+				if len(samples) == 0 {
+					time.Sleep(time.Duration(i+1) * 100 * time.Microsecond)
+				}
+			}
+		}()
+	}
+	for i := range writes {
+		buffer.Write(ramp(i*chunk, chunk), time.Now())
+	}
+	withinDeadline(t, "readers", waitGroup.Wait)
+
+	want := ramp(0, writes*chunk)
+	for i, reader := range readers {
+		if !slices.Equal(got[i], want) {
+			t.Errorf("reader %d got %d samples, not the stream in order", i, len(got[i]))
+		}
+		if reader.Dropped() != 0 {
+			t.Errorf("reader %d dropped %d samples", i, reader.Dropped())
+		}
+	}
+}
@@ -13,63 +13,178 @@ type AudioChunk struct {
 	Channels   int
 }
 
-// Buffer is a thread-safe audio buffer
+// Buffer is a thread-safe audio buffer.
+//
+// Its timestamp is always the capture time of the first buffered sample, and zero
+// while the buffer is empty. The Add or AddSilence that fills an empty buffer sets
+// it, later ones append directly after the buffered audio, Discard and GetUntil move
+// it past the removed frames and Get clears it with the samples. Only append and
+// advance change it, so every way of adding or removing samples agrees on the time
+// of the first one.
+//
+// Audio added to a buffer that was emptied continues the timeline of the audio taken
+// out when its timestamp is within bufferJoinTolerance of where that audio ended.
+// Capture timestamps jitter by a few milliseconds from callback to callback, and
+// re-seeding from each one would shift the source against the others every time the
+// mix empties its buffer. Audio further away, after a real gap, re-seeds it.
 type Buffer struct {
 	samples    []float32
 	sampleRate int
 	channels   int
-	timestamp  time.Time
+	timestamp  time.Time     // Capture time of samples[0]; zero while empty
+	next       time.Time     // While empty, the capture time just after the audio taken out
+	maxPeek    time.Duration // Most audio one Peek returns
 	mutex      sync.Mutex
 }
 
+// bufferJoinTolerance is how far audio added to an emptied buffer may start from the
+// end of the audio taken out and still be treated as continuing it
+const bufferJoinTolerance = 20 * time.Millisecond
+
+// DefaultMaxPeek is the most audio Peek returns unless SetMaxPeek changes it. It keeps
+// a consumer that fell far behind, or asked for far too much, from copying the whole
+// backlog in one allocation.
+const DefaultMaxPeek = 60 * time.Second
+
 // NewBuffer creates a new audio buffer
 func NewBuffer(sampleRate, channels int) *Buffer {
 	return &Buffer{
 		samples:    make([]float32, 0),
 		sampleRate: sampleRate,
 		channels:   channels,
+		maxPeek:    DefaultMaxPeek,
 		mutex:      sync.Mutex{},
 	}
 }
 
-// Add adds samples to the buffer
+// Add copies samples into the buffer; the caller may reuse the slice afterwards
 func (b *Buffer) Add(samples []float32, timestamp time.Time) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
+	b.append(samples, timestamp)
+}
+
+// AddSilence adds frames of silence, e.g. for a gap in a source's capture. The
+// timestamp is when the silence starts, which only counts when the buffer is empty.
+func (b *Buffer) AddSilence(frames int, timestamp time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if frames > 0 {
+		b.append(make([]float32, frames*b.channels), timestamp)
+	}
+}
+
+// Discard drops up to frames of the oldest audio, e.g. to bound a backlog, and
+// returns how many frames were dropped
+func (b *Buffer) Discard(frames int) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	dropped := min(max(frames, 0)*b.channels, len(b.samples)/b.channels*b.channels)
+	b.samples = append(b.samples[:0], b.samples[dropped:]...)
+	b.advance(dropped)
+	return dropped / b.channels
+}
+
+// append adds samples captured starting at timestamp after the buffered ones
+func (b *Buffer) append(samples []float32, timestamp time.Time) {
 	if len(b.samples) == 0 {
 		b.timestamp = timestamp
+		if offset := timestamp.Sub(b.next); !b.next.IsZero() && offset.Abs() <= bufferJoinTolerance {
+			b.timestamp = b.next
+		}
 	}
-
 	b.samples = append(b.samples, samples...)
 }
 
-// Get returns a copy of the samples and clears the buffer
+// advance moves the timestamp past samples removed from the front. Once the buffer is
+// empty it is cleared, and the end of the removed audio is kept for the next append.
+func (b *Buffer) advance(removed int) {
+	if removed == 0 {
+		return
+	}
+	timestamp := b.timestamp.Add(b.duration(removed))
+	if len(b.samples) == 0 {
+		b.timestamp = time.Time{}
+		b.next = timestamp
+		return
+	}
+	b.timestamp = timestamp
+}
+
+// duration returns how long count interleaved samples play
+func (b *Buffer) duration(count int) time.Duration {
+	frames := int64(count / b.channels)
+	return time.Duration(frames * int64(time.Second) / int64(b.sampleRate))
+}
+
+// End returns the capture time just after the last buffered sample. For an emptied
+// buffer it is where the audio taken out ended, and zero before anything was added.
+func (b *Buffer) End() time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.samples) == 0 {
+		return b.next
+	}
+	return b.timestamp.Add(b.duration(len(b.samples)))
+}
+
+// Get returns the samples and the capture time of the first, and clears the buffer.
+// The timestamp is zero when the buffer was empty. The caller owns the returned slice.
 func (b *Buffer) Get() ([]float32, time.Time, int, int) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	// Make a copy of the samples
-	samplesCopy := make([]float32, len(b.samples))
-	copy(samplesCopy, b.samples)
+	// Hand over the samples themselves instead of copying them
+	samples := b.samples
 
 	// Get timestamp and specs
 	timestamp := b.timestamp
 	sampleRate := b.sampleRate
 	channels := b.channels
 
-	// Clear the buffer
-	b.samples = make([]float32, 0)
+	// Clear the buffer, keeping its capacity for the next batch
+	b.samples = make([]float32, 0, cap(samples))
+	b.advance(len(samples))
 
-	return samplesCopy, timestamp, sampleRate, channels
+	return samples, timestamp, sampleRate, channels
 }
 
-// Get samples without clearing the buffer
+// GetUntil is Get for only the audio captured before end, leaving the rest buffered.
+// The caller owns the returned slice.
+func (b *Buffer) GetUntil(end time.Time) ([]float32, time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.samples) == 0 || !end.After(b.timestamp) {
+		return nil, time.Time{}
+	}
+
+	// Round to the nearest frame, so an end taken from End gets every frame before it
+	nanos := int64(end.Sub(b.timestamp))
+	frames := (nanos*int64(b.sampleRate) + int64(time.Second)/2) / int64(time.Second)
+	count := min(int(frames)*b.channels, len(b.samples))
+
+	samples := make([]float32, count)
+	copy(samples, b.samples[:count])
+	timestamp := b.timestamp
+	b.samples = append(b.samples[:0], b.samples[count:]...)
+	b.advance(count)
+
+	return samples, timestamp
+}
+
+// Get samples without clearing the buffer. At most maxDuration seconds are returned,
+// and never more than the buffer's peek limit (DefaultMaxPeek unless SetMaxPeek changed it).
 func (b *Buffer) Peek(maxDuration float64, sampleRate int) []float32 {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	maxSamples := int(maxDuration * float64(sampleRate) * float64(b.channels))
+	maxDuration = min(max(maxDuration, 0), b.maxPeek.Seconds())
+	maxSamples := int(maxDuration*float64(sampleRate)) * b.channels
 	if maxSamples > len(b.samples) {
 		maxSamples = len(b.samples)
 	}
@@ -81,6 +196,17 @@ func (b *Buffer) Peek(maxDuration float64, sampleRate int) []float32 {
 	return samplesCopy
 }
 
+// SetMaxPeek sets the most audio a single Peek returns; values of 0 or less restore DefaultMaxPeek
+func (b *Buffer) SetMaxPeek(limit time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if limit <= 0 {
+		limit = DefaultMaxPeek
+	}
+	b.maxPeek = limit
+}
+
 // IsEmpty checks if the buffer is empty
 func (b *Buffer) IsEmpty() bool {
 	b.mutex.Lock()
@@ -0,0 +1,303 @@
+package audio
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStartStopEmptyRecording(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepEmpty bool
+		folders   bool
+		frames    int // Captured before the stop
+		wantFile  bool
+	}{
+		{"Removed", false, false, 0, false},
+		{"Kept", true, false, 0, true},
+		{"SessionFolderRemoved", false, true, 0, false},
+		// Less than minRecordingDuration still counts as nothing captured
+		{"UnderMinimumRemoved", false, false, 400, false},
+		{"MinimumKept", false, false, 800, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.KeepEmpty = tc.keepEmpty
+				config.SessionFolders = tc.folders
+				config.TranscriptionOutput = true
+			})
+			recorder.DisableSpeaker()
+			var completed []string
+			recorder.SetFileCompleteHandler(func(path string) {
+				completed = append(completed, path)
+			})
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+			if tc.frames > 0 {
+				recorder.AddMicSamples(slices.Repeat([]float32{0.25}, tc.frames), time.Now())
+			}
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			path := recorder.GetOutputFilePath()
+			for _, file := range []string{path, recorder.GetTranscriptionFilePath()} {
+				if _, err := os.Stat(file); (err == nil) != tc.wantFile {
+					t.Errorf("%s exists = %v, want %v", filepath.Base(file), err == nil, tc.wantFile)
+				}
+			}
+			if tc.folders {
+				if _, err := os.Stat(recorder.SessionFolder()); !os.IsNotExist(err) {
+					t.Errorf("session folder left behind: %v", err)
+				}
+			}
+
+			// A kept empty file is still a valid WAV, and only real audio is handed on
+			if tc.wantFile {
+				samples, _, err := ReadWAV(path)
+				if err != nil {
+					t.Fatalf("ReadWAV: %v", err)
+				}
+				if len(samples) != tc.frames {
+					t.Errorf("file holds %d frames, want %d", len(samples), tc.frames)
+				}
+			}
+			if wantCompleted := tc.frames > 0 && tc.wantFile; (len(completed) > 0) != wantCompleted {
+				t.Errorf("completed files = %v, want a completion %v", completed, wantCompleted)
+			}
+		})
+	}
+}
+
+func TestBufferAddGet(t *testing.T) {
+	buffer := NewBuffer(8000, 2)
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	// The buffer copies what it is given, so the caller may reuse its slice
+	samples := []float32{1, 2, 3, 4}
+	buffer.Add(samples, start)
+	samples[0] = 9
+	buffer.Add([]float32{5, 6}, start.Add(time.Hour))
+	if size := buffer.Size(); size != 6 || buffer.IsEmpty() {
+		t.Fatalf("Size = %d, want 6", size)
+	}
+
+	got, timestamp, sampleRate, channels := buffer.Get()
+	if !slices.Equal(got, []float32{1, 2, 3, 4, 5, 6}) || !timestamp.Equal(start) {
+		t.Errorf("Get = %v at %v, want the samples in order at %v", got, timestamp, start)
+	}
+	if sampleRate != 8000 || channels != 2 {
+		t.Errorf("Get format = %d Hz, %d channels; want 8000 Hz, 2 channels", sampleRate, channels)
+	}
+
+	// Get empties the buffer; an empty Get returns nothing and no time
+	if !buffer.IsEmpty() {
+		t.Error("buffer not empty after Get")
+	}
+	if got, timestamp, _, _ := buffer.Get(); len(got) != 0 || !timestamp.IsZero() {
+		t.Errorf("Get of an empty buffer = %v at %v", got, timestamp)
+	}
+}
+
+func TestBufferPeek(t *testing.T) {
+	buffer := NewBuffer(100, 1)
+	buffer.Add(make([]float32, 300), time.Now())
+
+	tests := []struct {
+		name     string
+		maxPeek  time.Duration
+		duration float64
+		want     int
+	}{
+		{"Part", 0, 1, 100},
+		{"All", 0, 10, 300},
+		{"Negative", 0, -1, 0},
+		{"Limited", 1500 * time.Millisecond, 10, 150},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer.SetMaxPeek(tc.maxPeek)
+			if got := buffer.Peek(tc.duration, 100); len(got) != tc.want {
+				t.Errorf("Peek(%v) = %d samples, want %d", tc.duration, len(got), tc.want)
+			}
+			// Peeking never takes samples out
+			if size := buffer.Size(); size != 300 {
+				t.Errorf("Size after Peek = %d, want 300", size)
+			}
+		})
+	}
+}
+
+func TestBufferGetUntil(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	buffer := NewBuffer(1000, 2)
+	samples := make([]float32, 200) // 100 frames, 100ms
+	for i := range samples {
+		samples[i] = float32(i)
+	}
+	buffer.Add(samples, start)
+
+	// Nothing before the first frame, then the frames captured before the end, rounded
+	// to the nearest frame
+	if got, _ := buffer.GetUntil(start); got != nil {
+		t.Errorf("GetUntil(start) = %d samples, want none", len(got))
+	}
+	got, timestamp := buffer.GetUntil(start.Add(30*time.Millisecond + 400*time.Microsecond))
+	if len(got) != 60 || got[0] != 0 || !timestamp.Equal(start) {
+		t.Errorf("GetUntil(30.4ms) = %d samples from %v, want 60 from the start", len(got), timestamp)
+	}
+	got, timestamp = buffer.GetUntil(start.Add(time.Second))
+	if len(got) != 140 || got[0] != 60 || !timestamp.Equal(start.Add(30*time.Millisecond)) {
+		t.Errorf("GetUntil(1s) = %d samples from %v, want the other 140 from 30ms", len(got), timestamp)
+	}
+	if !buffer.IsEmpty() {
+		t.Error("buffer not empty after taking everything")
+	}
+}
+
+func TestBufferDiscard(t *testing.T) {
+	buffer := NewBuffer(1000, 2)
+	buffer.Add([]float32{1, 2, 3, 4, 5, 6}, time.Now())
+
+	tests := []struct {
+		frames, dropped int
+		left            []float32
+	}{
+		{-1, 0, []float32{1, 2, 3, 4, 5, 6}},
+		{1, 1, []float32{3, 4, 5, 6}},
+		// Only whole frames are held, so asking for more drops what there is
+		{5, 2, []float32{}},
+		{1, 0, []float32{}},
+	}
+	for _, tc := range tests {
+		if dropped := buffer.Discard(tc.frames); dropped != tc.dropped {
+			t.Errorf("Discard(%d) = %d, want %d", tc.frames, dropped, tc.dropped)
+		}
+		if left := buffer.Peek(1, 1000); !slices.Equal(left, tc.left) {
+			t.Errorf("after Discard(%d) left %v, want %v", tc.frames, left, tc.left)
+		}
+	}
+}
+
+func TestBufferTimestamp(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	frames := func(n int) []float32 { return make([]float32, 2*n) } // 1 ms each at 1000 Hz
+
+	tests := []struct {
+		name      string
+		run       func(buffer *Buffer)
+		wantStart time.Time // Capture time of the first buffered frame, zero when empty
+		wantEnd   time.Time
+	}{
+		{"Add", func(b *Buffer) { b.Add(frames(100), at(0)) }, at(0), at(100)},
+		// Later audio follows the buffered audio, whatever its own timestamp
+		{"AddAppends", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Add(frames(50), at(500))
+		}, at(0), at(150)},
+
+		// Dropping the oldest frames moves the first frame's time with them
+		{"Discard", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Discard(30)
+		}, at(30), at(100)},
+		{"DiscardAll", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Discard(200)
+		}, time.Time{}, at(100)},
+		{"GetUntil", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.GetUntil(at(40))
+		}, at(40), at(100)},
+
+		// Inserted silence takes up time like captured audio
+		{"SilenceSeedsEmptyBuffer", func(b *Buffer) {
+			b.AddSilence(20, at(0))
+			b.Add(frames(100), at(20))
+		}, at(0), at(120)},
+		{"SilenceAfterAudio", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.AddSilence(50, at(5000))
+		}, at(0), at(150)},
+		{"NoSilence", func(b *Buffer) { b.AddSilence(0, at(0)) }, time.Time{}, time.Time{}},
+		{"DiscardSilence", func(b *Buffer) {
+			b.AddSilence(50, at(0))
+			b.Add(frames(50), at(50))
+			b.Discard(60)
+		}, at(60), at(100)},
+
+		// After the buffer is emptied, audio within bufferJoinTolerance of where the
+		// taken audio ended continues it; anything further re-seeds the time
+		{"ClearThenAddLate", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Get()
+			b.Add(frames(10), at(110))
+		}, at(100), at(110)},
+		{"ClearThenAddEarly", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Get()
+			b.Add(frames(10), at(85))
+		}, at(100), at(110)},
+		{"ClearThenAddAtTolerance", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Get()
+			b.Add(frames(10), at(100).Add(bufferJoinTolerance))
+		}, at(100), at(110)},
+		{"ClearThenAddPastTolerance", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Get()
+			b.Add(frames(10), at(100).Add(bufferJoinTolerance+time.Millisecond))
+		}, at(121), at(131)},
+		{"ClearThenAddAfterGap", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Get()
+			b.Add(frames(10), at(1000))
+		}, at(1000), at(1010)},
+		{"DiscardAllThenAdd", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Discard(100)
+			b.Add(frames(10), at(105))
+		}, at(100), at(110)},
+		{"ClearThenAddSilence", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Get()
+			b.AddSilence(10, at(95))
+		}, at(100), at(110)},
+		// An empty Get takes nothing, so the join point stays where the audio ended
+		{"EmptyGetKeepsJoin", func(b *Buffer) {
+			b.Add(frames(100), at(0))
+			b.Get()
+			b.Get()
+			b.Add(frames(10), at(110))
+		}, at(100), at(110)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buffer := NewBuffer(1000, 2)
+			tc.run(buffer)
+			if !buffer.timestamp.Equal(tc.wantStart) {
+				t.Errorf("first frame at %v, want %v", buffer.timestamp, tc.wantStart)
+			}
+			if end := buffer.End(); !end.Equal(tc.wantEnd) {
+				t.Errorf("End = %v, want %v", end, tc.wantEnd)
+			}
+
+			// Get hands over the same time and clears it
+			if buffer.IsEmpty() {
+				return
+			}
+			if _, timestamp, _, _ := buffer.Get(); !timestamp.Equal(tc.wantStart) {
+				t.Errorf("Get timestamp = %v, want %v", timestamp, tc.wantStart)
+			}
+			if !buffer.timestamp.IsZero() {
+				t.Errorf("timestamp after Get = %v, want zero", buffer.timestamp)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// SampleHandler receives decoded samples from a capture device.
+// The samples slice is only valid until the handler returns: it is recycled for the
+// next callback, so handlers must copy anything they keep (Buffer.Add does).
+type SampleHandler func(samples []float32, timestamp time.Time)
+
+// samplePool recycles decode buffers between capture callbacks to avoid GC pressure
+var samplePool = sync.Pool{
+	New: func() any { return new([]float32) },
+}
+
+// DeviceLostHandler is notified when a device stops without Stop being called,
+// e.g. because it was unplugged
+type DeviceLostHandler func()
+
+// Capturer wraps a malgo capture or loopback device and delivers float32 samples
+// at the requested sample rate; miniaudio converts from the hardware rate, so a
+// device that switches rate mid-capture still delivers at the requested one.
+// Requesting rate 0 opens the device at its native rate instead, reported by
+// SampleRate, for callers that convert it themselves (see AddMicSamplesAtRate).
+// The device is opened in its native sample format, which float32 holds without
+// loss up to 24 bits, so high-resolution devices keep their precision.
+type Capturer struct {
+	device        *malgo.Device
+	format        malgo.FormatType
+	decoder       *FrameDecoder
+	channels      int
+	handler       SampleHandler
+	onDeviceLost  DeviceLostHandler
+	callbackMutex sync.Mutex
+	drained       bool
+	stopping      bool // Stop or Uninit was called, so a device stop is expected
+}
+
+// NewCapturer initializes a capture device that feeds decoded samples to handler.
+// A nil deviceID selects the default device for the given device type, and a
+// sampleRate of 0 the device's native rate.
+func NewCapturer(ctx malgo.Context, deviceType malgo.DeviceType, deviceID *malgo.DeviceID,
+	sampleRate, channels int, handler SampleHandler) (*Capturer, error) {
+	c := &Capturer{
+		channels: channels,
+		handler:  handler,
+	}
+
+	deviceConfig := malgo.DeviceConfig{
+		DeviceType: deviceType,
+		SampleRate: uint32(sampleRate),
+		Capture: malgo.SubConfig{
+			Format:   malgo.FormatUnknown, // Use the device's native format
+			Channels: uint32(channels),
+		},
+	}
+	if deviceID != nil {
+		deviceConfig.Capture.DeviceID = deviceID.Pointer()
+	}
+
+	device, err := malgo.InitDevice(ctx, deviceConfig, malgo.DeviceCallbacks{
+		Data: c.dataCallback,
+		Stop: c.stopCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.device = device
+	c.format = device.CaptureFormat()
+	if FormatBits(c.format) == 0 {
+		device.Uninit()
+		return nil, fmt.Errorf("unsupported capture format %d", c.format)
+	}
+	c.decoder = NewFrameDecoder(c.format, channels)
+
+	return c, nil
+}
+
+// Format returns the sample format negotiated with the device
+func (c *Capturer) Format() malgo.FormatType {
+	return c.format
+}
+
+// BitsPerSample returns the bit depth of the format negotiated with the device
+func (c *Capturer) BitsPerSample() int {
+	return FormatBits(c.format)
+}
+
+// SampleRate returns the sample rate negotiated with the device
+func (c *Capturer) SampleRate() int {
+	return int(c.device.SampleRate())
+}
+
+// Channels returns the channel count negotiated with the device
+func (c *Capturer) Channels() int {
+	return int(c.device.CaptureChannels())
+}
+
+// SetDeviceLostHandler registers a function called when the device stops on its own
+func (c *Capturer) SetDeviceLostHandler(handler DeviceLostHandler) {
+	c.callbackMutex.Lock()
+	defer c.callbackMutex.Unlock()
+
+	c.onDeviceLost = handler
+}
+
+// Start starts the device and resumes delivering samples
+func (c *Capturer) Start() error {
+	c.callbackMutex.Lock()
+	c.drained = false
+	c.stopping = false
+	c.decoder.Reset()
+	c.callbackMutex.Unlock()
+
+	return c.device.Start()
+}
+
+// Stop stops the device and waits for any callback still in flight to complete.
+// Once Stop returns the capturer is drained and delivers no further samples,
+// so everything it captured is already in the handler's hands.
+func (c *Capturer) Stop() error {
+	c.callbackMutex.Lock()
+	c.stopping = true
+	c.callbackMutex.Unlock()
+
+	err := c.device.Stop()
+
+	// Taking the callback lock waits out a callback that is mid-delivery
+	c.callbackMutex.Lock()
+	c.drained = true
+	c.callbackMutex.Unlock()
+
+	return err
+}
+
+// IsDrained returns whether the capturer has been stopped and drained
+func (c *Capturer) IsDrained() bool {
+	c.callbackMutex.Lock()
+	defer c.callbackMutex.Unlock()
+
+	return c.drained
+}
+
+// Uninit releases the underlying device
+func (c *Capturer) Uninit() {
+	c.callbackMutex.Lock()
+	c.stopping = true
+	c.callbackMutex.Unlock()
+
+	c.device.Uninit()
+}
+
+// stopCallback reports a device that stopped without being asked to
+func (c *Capturer) stopCallback() {
+	c.callbackMutex.Lock()
+	lost := !c.stopping
+	handler := c.onDeviceLost
+	c.callbackMutex.Unlock()
+
+	if lost {
+		fmt.Fprintln(os.Stderr, "\nCapture device stopped unexpectedly")
+		if handler != nil {
+			handler()
+		}
+	}
+}
+
+// dataCallback decodes the device input and hands it to the sample handler
+func (c *Capturer) dataCallback(output, input []byte, frameCount uint32) {
+	c.callbackMutex.Lock()
+	defer c.callbackMutex.Unlock()
+
+	if c.drained {
+		return
+	}
+
+	// Get the current time for this chunk
+	chunkTime := time.Now()
+
+	// Decode into a pooled buffer that is handed back once the handler is done
+	pooled := samplePool.Get().(*[]float32)
+	defer samplePool.Put(pooled)
+	samples := c.decoder.Decode(*pooled, input)
+	*pooled = samples
+	if len(samples) == 0 {
+		return
+	}
+
+	c.handler(samples, chunkTime)
+}
+
+// FrameDecoder decodes device bytes into whole frames of float samples. Bytes that
+// don't complete a frame are kept and prepended to the next input, so a callback
+// boundary that splits a frame neither drops samples nor shifts channel alignment.
+type FrameDecoder struct {
+	format     malgo.FormatType
+	channels   int
+	frameBytes int
+	remainder  []byte
+	joined     []byte
+}
+
+// NewFrameDecoder creates a decoder for interleaved input in the given device format
+func NewFrameDecoder(format malgo.FormatType, channels int) *FrameDecoder {
+	return &FrameDecoder{
+		format:     format,
+		channels:   channels,
+		frameBytes: FormatBits(format) / 8 * channels,
+	}
+}
+
+// Decode returns the samples of every whole frame available after joining the input
+// to the bytes left over from the previous call, reusing dst when it is large enough
+func (d *FrameDecoder) Decode(dst []float32, input []byte) []float32 {
+	data := input
+	if len(d.remainder) > 0 {
+		d.joined = append(append(d.joined[:0], d.remainder...), input...)
+		data = d.joined
+	}
+
+	frames := len(data) / d.frameBytes
+	d.remainder = append(d.remainder[:0], data[frames*d.frameBytes:]...)
+
+	return DecodeSamples(dst, data, d.format, frames*d.channels)
+}
+
+// Reset discards any partial frame left over from earlier input
+func (d *FrameDecoder) Reset() {
+	d.remainder = d.remainder[:0]
+}
+
+// BytesToFloat32 decodes little-endian float32 samples from raw device bytes
+func BytesToFloat32(input []byte, sampleCount int) []float32 {
+	return decodeFloat32(nil, input, sampleCount)
+}
+
+// decodeFloat32 decodes samples into dst, growing it only when it is too small
+func decodeFloat32(dst []float32, input []byte, sampleCount int) []float32 {
+	if cap(dst) < sampleCount {
+		dst = make([]float32, sampleCount)
+	}
+	samples := dst[:sampleCount]
+
+	for i := 0; i < sampleCount; i++ {
+		if i*4+3 < len(input) {
+			samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(input[i*4 : i*4+4]))
+		} else {
+			samples[i] = 0
+		}
+	}
+
+	return samples
+}
@@ -0,0 +1,215 @@
+package audio
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// CaptureDevice is a started or stopped capture device feeding one of the recorder's
+// sources. *Capturer is the one used for recording.
+type CaptureDevice interface {
+	Start() error
+	Stop() error
+	Uninit()
+	SampleRate() int
+	SetDeviceLostHandler(handler DeviceLostHandler)
+}
+
+// deviceOpener initializes a capture device that delivers to handler at sampleRate, or
+// at its native rate for 0, without starting it
+type deviceOpener func(sampleRate int, handler SampleHandler) (CaptureDevice, error)
+
+// captureOpener returns an opener for the device with deviceID, or the default device
+// of deviceType when it is nil, at the recording's channel count
+func (r *Recorder) captureOpener(ctx malgo.Context, deviceType malgo.DeviceType, deviceID *malgo.DeviceID) deviceOpener {
+	return func(sampleRate int, handler SampleHandler) (CaptureDevice, error) {
+		capturer, err := NewCapturer(ctx, deviceType, deviceID, sampleRate, r.config.Channels, handler)
+		if err != nil {
+			return nil, err
+		}
+		return capturer, nil
+	}
+}
+
+// OpenMicDevice opens and starts a capture device feeding microphone index; a nil
+// device selects the default microphone. The device runs at its native sample rate,
+// which the recorder converts to the recording's (see AddMicSamplesAtRate). The
+// recorder keeps the device until CloseDevices, so SwitchMicDevice can replace it.
+func (r *Recorder) OpenMicDevice(ctx malgo.Context, index int, device *malgo.DeviceInfo) (*Capturer, error) {
+	var deviceID *malgo.DeviceID
+	if device != nil {
+		deviceID = &device.ID
+	}
+	opened, err := r.openMicDevice(index, r.captureOpener(ctx, malgo.Capture, deviceID))
+	if err != nil {
+		return nil, err
+	}
+	return opened.(*Capturer), nil
+}
+
+// openMicDevice opens and starts the device feeding microphone index
+func (r *Recorder) openMicDevice(index int, open deviceOpener) (CaptureDevice, error) {
+	r.deviceMutex.Lock()
+	defer r.deviceMutex.Unlock()
+
+	if index < 0 || index >= len(r.micDevices) {
+		return nil, fmt.Errorf("no microphone %d", index+1)
+	}
+	if r.micDevices[index] != nil {
+		return nil, fmt.Errorf("microphone %d already has a device", index+1)
+	}
+	device, err := r.startMicDevice(index, open)
+	if err != nil {
+		return nil, err
+	}
+	r.micDevices[index] = device
+	return device, nil
+}
+
+// OpenSpeakerDevice is OpenMicDevice for the speaker, captured by loopback from the
+// default output
+func (r *Recorder) OpenSpeakerDevice(ctx malgo.Context) (*Capturer, error) {
+	r.deviceMutex.Lock()
+	defer r.deviceMutex.Unlock()
+
+	if r.speakerDevice != nil {
+		return nil, fmt.Errorf("the speaker already has a device")
+	}
+	device, err := r.startDevice(r.captureOpener(ctx, malgo.Loopback, nil), "speaker", r.addSpeakerSamples)
+	if err != nil {
+		return nil, err
+	}
+	r.speakerDevice = device
+	return device.(*Capturer), nil
+}
+
+// SwitchMicDevice moves microphone index to another capture device without stopping
+// the recording. The new device feeds the same microphone buffer, and the moment
+// between the old device stopping and the new one delivering is recorded as silence,
+// so the microphone stays in time with the other sources. The old device keeps
+// recording if the new one fails to open.
+func (r *Recorder) SwitchMicDevice(ctx malgo.Context, index int, device malgo.DeviceInfo) error {
+	return r.switchMicDevice(index, r.captureOpener(ctx, malgo.Capture, &device.ID), device.Name())
+}
+
+// switchMicDevice replaces the device of microphone index with one open returns
+func (r *Recorder) switchMicDevice(index int, open deviceOpener, name string) error {
+	r.deviceMutex.Lock()
+	defer r.deviceMutex.Unlock()
+
+	if !r.IsRecording() || r.devicesStopped {
+		return fmt.Errorf("not recording")
+	}
+	if index < 0 || index >= len(r.micDevices) || r.micDevices[index] == nil {
+		return fmt.Errorf("no microphone %d", index+1)
+	}
+
+	// Stop the old device first; some drivers can't open a device twice
+	old := r.micDevices[index]
+	old.Stop()
+	r.MarkMicGap(index, r.config.Clock.Now())
+
+	device, err := r.startMicDevice(index, open)
+	if err != nil {
+		if restartErr := old.Start(); restartErr != nil {
+			fmt.Fprintln(os.Stderr, "Failed to restart previous microphone:", restartErr)
+		}
+		return err
+	}
+	old.Uninit()
+	r.micDevices[index] = device
+
+	r.publish(EventDevice, fmt.Sprintf("microphone %d switched to %s", index+1, name))
+	return nil
+}
+
+// startMicDevice opens and starts a device feeding microphone index
+func (r *Recorder) startMicDevice(index int, open deviceOpener) (CaptureDevice, error) {
+	return r.startDevice(open, fmt.Sprintf("microphone %d", index+1),
+		func(samples []float32, sampleRate int, timestamp time.Time) {
+			r.addMicSamples(index, samples, sampleRate, timestamp)
+		})
+}
+
+// startDevice opens a device at its native sample rate, feeding add with the rate of
+// each callback, and starts it. The recorder converts each source with a resampler of
+// its own, which keeps its position from one callback to the next. A device whose
+// native rate can't be converted from is opened at the recording's rate, which
+// miniaudio converts to instead. The device's losses are published as events.
+func (r *Recorder) startDevice(open deviceOpener, source string,
+	add func(samples []float32, sampleRate int, timestamp time.Time)) (CaptureDevice, error) {
+	var sampleRate int // Set before the device starts delivering
+	handler := func(samples []float32, timestamp time.Time) {
+		add(samples, sampleRate, timestamp)
+	}
+
+	device, err := open(0, handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s: %w", source, err)
+	}
+	sampleRate = device.SampleRate()
+	if sampleRate != r.config.SampleRate {
+		if err := ValidateResampleRates(sampleRate, r.config.SampleRate); err != nil {
+			device.Uninit()
+			device, err = open(r.config.SampleRate, handler)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize %s: %w", source, err)
+			}
+			sampleRate = r.config.SampleRate
+		} else {
+			fmt.Fprintf(os.Stderr, "Converting %s from %d Hz to %d Hz\n", source, sampleRate, r.config.SampleRate)
+		}
+	}
+
+	device.SetDeviceLostHandler(func() {
+		r.publish(EventDevice, source+" stopped unexpectedly")
+	})
+	if err := device.Start(); err != nil {
+		device.Uninit()
+		return nil, fmt.Errorf("failed to start %s: %w", source, err)
+	}
+	return device, nil
+}
+
+// StopDevices stops every capture device, waiting for callbacks still in flight, so
+// everything they captured is buffered before StopRecording. Devices can't be
+// switched afterwards.
+func (r *Recorder) StopDevices() {
+	r.deviceMutex.Lock()
+	defer r.deviceMutex.Unlock()
+
+	r.devicesStopped = true
+	for _, device := range r.devices() {
+		device.Stop()
+	}
+}
+
+// CloseDevices releases every capture device
+func (r *Recorder) CloseDevices() {
+	r.deviceMutex.Lock()
+	defer r.deviceMutex.Unlock()
+
+	r.devicesStopped = true
+	for _, device := range r.devices() {
+		device.Uninit()
+	}
+	clear(r.micDevices)
+	r.speakerDevice = nil
+}
+
+// devices returns the open capture devices
+func (r *Recorder) devices() []CaptureDevice {
+	var devices []CaptureDevice
+	for _, device := range r.micDevices {
+		if device != nil {
+			devices = append(devices, device)
+		}
+	}
+	if r.speakerDevice != nil {
+		devices = append(devices, r.speakerDevice)
+	}
+	return devices
+}
@@ -0,0 +1,211 @@
+package audio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DownmixToMono averages interleaved channels into a single channel
+func DownmixToMono(samples []float32, channels int) []float32 {
+	if channels <= 1 {
+		return samples
+	}
+
+	frames := len(samples) / channels
+	mono := make([]float32, frames)
+	for i := 0; i < frames; i++ {
+		sum := float32(0)
+		for ch := 0; ch < channels; ch++ {
+			sum += samples[i*channels+ch]
+		}
+		mono[i] = sum / float32(channels)
+	}
+
+	return mono
+}
+
+// DownmixWeighted collapses interleaved channels into one, scaling each channel by its
+// weight: [1, 0] keeps only the left side of stereo, [0.5, 0.5] averages it. There is
+// one weight per channel. The result is clamped to [-1, 1].
+func DownmixWeighted(samples []float32, weights []float32) []float32 {
+	channels := len(weights)
+	frames := len(samples) / channels
+	mono := make([]float32, frames)
+	for i := 0; i < frames; i++ {
+		sum := float32(0)
+		for ch, weight := range weights {
+			sum += samples[i*channels+ch] * weight
+		}
+		mono[i] = clampSample(sum)
+	}
+
+	return mono
+}
+
+// ValidateDownmixWeights checks that there is one downmix weight per channel
+func ValidateDownmixWeights(weights []float32, channels int) error {
+	if len(weights) != channels {
+		return fmt.Errorf("downmix needs %d weights, one per channel, got %d", channels, len(weights))
+	}
+	return nil
+}
+
+// downmix collapses channels with the given weights, or averages them when there are none
+func downmix(samples []float32, channels int, weights []float32) []float32 {
+	if len(weights) == 0 {
+		return DownmixToMono(samples, channels)
+	}
+	return DownmixWeighted(samples, weights)
+}
+
+// DeinterleaveChannels separates interleaved samples into one slice per channel.
+// A trailing partial frame is dropped.
+func DeinterleaveChannels(samples []float32, channels int) [][]float32 {
+	frames := len(samples) / channels
+	separated := make([][]float32, channels)
+	for ch := range separated {
+		separated[ch] = make([]float32, frames)
+		for i := 0; i < frames; i++ {
+			separated[ch][i] = samples[i*channels+ch]
+		}
+	}
+
+	return separated
+}
+
+// InterleaveStereo combines two mono streams into one interleaved stereo stream.
+// The shorter stream is padded with silence.
+func InterleaveStereo(left, right []float32) []float32 {
+	frames := len(left)
+	if len(right) > frames {
+		frames = len(right)
+	}
+
+	stereo := make([]float32, frames*2)
+	for i := 0; i < frames; i++ {
+		if i < len(left) {
+			stereo[i*2] = left[i]
+		}
+		if i < len(right) {
+			stereo[i*2+1] = right[i]
+		}
+	}
+
+	return stereo
+}
+
+// InterleaveTracks combines two interleaved streams of the same channel count into
+// one stream with the channels of both: each frame holds a frame of the first
+// followed by a frame of the second. The shorter stream is padded with silence.
+func InterleaveTracks(first, second []float32, channels int) []float32 {
+	frames := max(len(first), len(second)) / channels
+
+	tracks := make([]float32, frames*channels*2)
+	for i := 0; i < frames; i++ {
+		frame := tracks[i*channels*2 : (i+1)*channels*2]
+		if i*channels < len(first) {
+			copy(frame[:channels], first[i*channels:])
+		}
+		if i*channels < len(second) {
+			copy(frame[channels:], second[i*channels:])
+		}
+	}
+
+	return tracks
+}
+
+// ChannelSource selects what feeds one channel of a channel-mapped output
+type ChannelSource int
+
+const (
+	ChannelMic     ChannelSource = iota // The microphones, downmixed to mono
+	ChannelSpeaker                      // The speaker, downmixed to mono
+	ChannelMix                          // The average of microphone and speaker
+)
+
+// channelSourceNames maps each channel source to its command line name
+var channelSourceNames = map[ChannelSource]string{
+	ChannelMic:     "mic",
+	ChannelSpeaker: "speaker",
+	ChannelMix:     "mix",
+}
+
+// String returns the command line name of the channel source
+func (c ChannelSource) String() string {
+	if name, ok := channelSourceNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ChannelSource(%d)", int(c))
+}
+
+// MarshalText encodes the channel source by its command line name, e.g. in JSON
+func (c ChannelSource) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// ParseChannelMap converts a comma separated list of channel sources, one per output
+// channel, into a channel map, e.g. "speaker,mic" swaps the default stereo split
+func ParseChannelMap(value string) ([]ChannelSource, error) {
+	var channelMap []ChannelSource
+	for _, field := range strings.Split(value, ",") {
+		name := strings.ToLower(strings.TrimSpace(field))
+		found := false
+		for source, sourceName := range channelSourceNames {
+			if sourceName == name {
+				channelMap = append(channelMap, source)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown channel source %q (use mic, speaker or mix)", name)
+		}
+	}
+	return channelMap, nil
+}
+
+// ValidateChannelMap checks that a channel map has a known source for every output
+// channel and no more channels than a WAV file can hold
+func ValidateChannelMap(channelMap []ChannelSource) error {
+	if err := ValidateChannels(len(channelMap)); err != nil {
+		return fmt.Errorf("channel map: %w", err)
+	}
+	for i, source := range channelMap {
+		if _, ok := channelSourceNames[source]; !ok {
+			return fmt.Errorf("channel map: output channel %d has no source (%d)", i+1, int(source))
+		}
+	}
+	return nil
+}
+
+// InterleaveMapped builds one interleaved stream with a channel for each entry of the
+// channel map, fed from mono microphone and speaker streams. InterleaveStereo is the
+// map mic, speaker. The shorter stream is padded with silence.
+func InterleaveMapped(mic, speaker []float32, channelMap []ChannelSource) []float32 {
+	frames := max(len(mic), len(speaker))
+	channels := len(channelMap)
+
+	interleaved := make([]float32, frames*channels)
+	for i := 0; i < frames; i++ {
+		var micSample, speakerSample float32
+		if i < len(mic) {
+			micSample = mic[i]
+		}
+		if i < len(speaker) {
+			speakerSample = speaker[i]
+		}
+
+		for ch, source := range channelMap {
+			switch source {
+			case ChannelMic:
+				interleaved[i*channels+ch] = micSample
+			case ChannelSpeaker:
+				interleaved[i*channels+ch] = speakerSample
+			case ChannelMix:
+				interleaved[i*channels+ch] = (micSample + speakerSample) / 2
+			}
+		}
+	}
+
+	return interleaved
+}
@@ -0,0 +1,118 @@
+package audio
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseChannelMap(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []ChannelSource
+		wantErr bool
+	}{
+		{"mic,speaker", []ChannelSource{ChannelMic, ChannelSpeaker}, false},
+		{"speaker, mic", []ChannelSource{ChannelSpeaker, ChannelMic}, false},
+		{"MIC,Speaker,mix,mix", []ChannelSource{ChannelMic, ChannelSpeaker, ChannelMix, ChannelMix}, false},
+		{"mic,left", nil, true},
+		{"", nil, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseChannelMap(tc.value)
+		if (err != nil) != tc.wantErr || !slices.Equal(got, tc.want) {
+			t.Errorf("ParseChannelMap(%q) = %v, %v; want %v, error %v", tc.value, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestTimeSyncChannelMap(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	mic := []float32{0.1, 0.2, 0.3, 0.4}
+	speaker := []float32{-0.1, -0.2, -0.3, -0.4}
+
+	tests := []struct {
+		name       string
+		channelMap []ChannelSource
+		want       []float32
+	}{
+		{"Default", []ChannelSource{ChannelMic, ChannelSpeaker},
+			[]float32{0.1, -0.1, 0.2, -0.2, 0.3, -0.3, 0.4, -0.4}},
+		{"Swapped", []ChannelSource{ChannelSpeaker, ChannelMic},
+			[]float32{-0.1, 0.1, -0.2, 0.2, -0.3, 0.3, -0.4, 0.4}},
+		{"Multichannel", []ChannelSource{ChannelMic, ChannelSpeaker, ChannelMix, ChannelMic},
+			[]float32{0.1, -0.1, 0, 0.1, 0.2, -0.2, 0, 0.2, 0.3, -0.3, 0, 0.3, 0.4, -0.4, 0, 0.4}},
+		{"MicOnly", []ChannelSource{ChannelMic},
+			[]float32{0.1, 0.2, 0.3, 0.4}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, timestamp := TimeSyncChannelMap(mic, start, speaker, start, testRate, 1, nil, tc.channelMap)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("mapped = %v, want %v", got, tc.want)
+			}
+			if !timestamp.Equal(start) {
+				t.Errorf("timestamp = %v, want %v", timestamp, start)
+			}
+		})
+	}
+
+	// A later speaker is shifted into place, and the silence before it fills its channel
+	later := start.Add(2 * time.Second / testRate)
+	got, _ := TimeSyncChannelMap(mic, start, speaker[:2], later, testRate, 1, nil,
+		[]ChannelSource{ChannelSpeaker, ChannelMic})
+	want := []float32{0, 0.1, 0, 0.2, -0.1, 0.3, -0.2, 0.4}
+	if !slices.Equal(got, want) {
+		t.Errorf("offset speaker mapped = %v, want %v", got, want)
+	}
+
+	// Stereo sources are downmixed to one channel each before they are mapped
+	got, _ = TimeSyncChannelMap([]float32{0.2, 0.4}, start, []float32{-0.2, 0}, start, testRate, 2, nil,
+		[]ChannelSource{ChannelSpeaker, ChannelMic, ChannelMix})
+	if want := []float32{-0.1, 0.3, 0.1}; !nearSlice(got, want) {
+		t.Errorf("stereo sources mapped = %v, want %v", got, want)
+	}
+}
+
+func TestRecorderChannelMap(t *testing.T) {
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.MixMode = MixStereoSplit
+		config.ChannelMap = []ChannelSource{ChannelSpeaker, ChannelMic, ChannelMix}
+	})
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	recorder.AddMicSamples(slices.Repeat([]float32{0.25}, 8000), start)
+	recorder.AddSpeakerSamples(slices.Repeat([]float32{-0.5}, 8000), start)
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	samples, header, err := ReadWAV(recorder.GetOutputFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Channels != 3 {
+		t.Fatalf("file has %d channels, want one per channel map entry", header.Channels)
+	}
+	channels := DeinterleaveChannels(samples, 3)
+	for ch, want := range []float32{-0.5, 0.25, -0.125} {
+		if len(channels[ch]) != 8000 || !nearSlice(channels[ch], slices.Repeat([]float32{want}, 8000)) {
+			t.Errorf("channel %d = %v..., want %v throughout", ch+1, channels[ch][:min(3, len(channels[ch]))], want)
+		}
+	}
+}
+
+// nearSlice reports whether two sample slices match to within 16-bit PCM precision
+func nearSlice(got, want []float32) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if d := got[i] - want[i]; d > 1e-4 || d < -1e-4 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package audio
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the recorder. The system clock is used by default;
+// FakeClock lets timing-dependent behaviour be driven step by step.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single-shot timer created by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker delivers ticks at a fixed interval until stopped
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the real wall clock
+type SystemClock struct{}
+
+// Now returns the current time
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// Since returns the time elapsed since t
+func (SystemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// NewTimer creates a timer that fires after d
+func (SystemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+// NewTicker creates a ticker that fires every d
+func (SystemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ timer *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.timer.C }
+func (t systemTimer) Stop() bool                 { return t.timer.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.timer.Reset(d) }
+
+type systemTicker struct{ ticker *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.ticker.C }
+func (t systemTicker) Stop()               { t.ticker.Stop() }
+
+// FakeClock is a Clock that only moves when Advance is called. Timers and tickers
+// fire during Advance once their deadline has passed; like real tickers, a tick
+// is dropped if the previous one has not been received yet.
+type FakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	mutex  sync.Mutex
+}
+
+// NewFakeClock creates a fake clock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the fake current time
+func (f *FakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.now
+}
+
+// Since returns the fake time elapsed since t
+func (f *FakeClock) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// NewTimer creates a timer that fires once the clock has advanced by d
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	return f.addTimer(d, 0)
+}
+
+// NewTicker creates a ticker that fires each time the clock advances past another d
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{f.addTimer(d, d)}
+}
+
+// Advance moves the clock forward by d, firing every timer and tick that falls due
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.now = f.now.Add(d)
+	for _, timer := range f.timers {
+		for timer.active && !timer.deadline.After(f.now) {
+			select {
+			case timer.c <- timer.deadline:
+			default:
+			}
+			if timer.period == 0 {
+				timer.active = false
+			} else {
+				timer.deadline = timer.deadline.Add(timer.period)
+			}
+		}
+	}
+}
+
+// addTimer registers a timer, or a ticker when period is positive
+func (f *FakeClock) addTimer(d, period time.Duration) *fakeTimer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	timer := &fakeTimer{
+		clock:    f,
+		c:        make(chan time.Time, 1),
+		deadline: f.now.Add(d),
+		period:   period,
+		active:   true,
+	}
+	f.timers = append(f.timers, timer)
+	return timer
+}
+
+// fakeTimer is a timer or ticker driven by a FakeClock
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	wasActive := t.active
+	t.deadline = t.clock.now.Add(d)
+	t.active = true
+	return wasActive
+}
+
+// fakeTicker adapts a periodic fakeTimer to the Ticker interface
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gen2brain/malgo"
+)
+
+// FindDevice returns the device of the given type whose name contains nameSubstr,
+// ignoring case. Device numbers change between boots and plug events, names don't.
+func FindDevice(ctx malgo.Context, deviceType malgo.DeviceType, nameSubstr string) (malgo.DeviceInfo, error) {
+	devices, err := ctx.Devices(deviceType)
+	if err != nil {
+		return malgo.DeviceInfo{}, err
+	}
+
+	index, err := MatchDevice(devices, nameSubstr)
+	if err != nil {
+		return malgo.DeviceInfo{}, err
+	}
+	return devices[index], nil
+}
+
+// NativeSampleRate returns the sample rate a device runs at when left to choose, which
+// is the rate it captures at without conversion. A nil deviceID selects the default
+// device. The device is opened briefly to ask and not started.
+func NativeSampleRate(ctx malgo.Context, deviceType malgo.DeviceType, deviceID *malgo.DeviceID) (int, error) {
+	deviceConfig := malgo.DeviceConfig{
+		DeviceType: deviceType,
+		SampleRate: 0, // Native rate
+		Capture: malgo.SubConfig{
+			Format: malgo.FormatUnknown,
+		},
+	}
+	if deviceID != nil {
+		deviceConfig.Capture.DeviceID = deviceID.Pointer()
+	}
+
+	device, err := malgo.InitDevice(ctx, deviceConfig, malgo.DeviceCallbacks{
+		Data: func(output, input []byte, frameCount uint32) {},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer device.Uninit()
+
+	rate := int(device.SampleRate())
+	if rate <= 0 {
+		return 0, fmt.Errorf("device reported no sample rate")
+	}
+	return rate, nil
+}
+
+// MatchDevice returns the index of the device whose name contains nameSubstr, ignoring
+// case. A name that matches exactly wins over partial matches; otherwise the substring
+// must match exactly one device.
+func MatchDevice(devices []malgo.DeviceInfo, nameSubstr string) (int, error) {
+	names := make([]string, len(devices))
+	for i := range devices {
+		names[i] = devices[i].Name()
+	}
+	return MatchDeviceName(names, nameSubstr)
+}
+
+// MatchDeviceName is MatchDevice for a list of device names
+func MatchDeviceName(names []string, nameSubstr string) (int, error) {
+	want := strings.ToLower(strings.TrimSpace(nameSubstr))
+	if want == "" {
+		return -1, fmt.Errorf("empty device name")
+	}
+
+	var matches []int
+	for i, name := range names {
+		name = strings.ToLower(name)
+		if name == want {
+			return i, nil
+		}
+		if strings.Contains(name, want) {
+			matches = append(matches, i)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return -1, fmt.Errorf("no device matches %q", nameSubstr)
+	case 1:
+		return matches[0], nil
+	}
+
+	quoted := make([]string, len(matches))
+	for i, index := range matches {
+		quoted[i] = fmt.Sprintf("%q", names[index])
+	}
+	return -1, fmt.Errorf("%q matches several devices: %s", nameSubstr, strings.Join(quoted, ", "))
+}
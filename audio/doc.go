@@ -0,0 +1,13 @@
+// Package audio captures, mixes and records microphone and speaker audio.
+//
+// Samples stay float32 in [-1, 1] from capture to the file writer. Capturer decodes
+// the device's native format once (DecodeSamples); processors, the per-source buffers,
+// the mixers, resampling, downmixing and the mixed BroadcastBuffer all work on those
+// floats. The only quantization is EncodeSamples, when the WAV writer stores PCM at the
+// configured depth, so a reader of the live mix (NewMixedTap) sees exactly the floats
+// the mixer produced, and a single unprocessed microphone without a speaker stream
+// reaches it bit-identical to what was captured.
+//
+// Saved files are quantized to their bit depth. Batch work that reads them back with
+// ReadWAV gets that quantized audio; record at 24 or 32 bits when the difference matters.
+package audio
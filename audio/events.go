@@ -0,0 +1,148 @@
+package audio
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType identifies something that happened during a recording
+type EventType int
+
+const (
+	EventStart   EventType = iota // Recording started; the message is the output file
+	EventRotate                   // Recording moved on to a new part; the message is the new file
+	EventMarker                   // A marker was added; the message is its label
+	EventClip                     // Written samples reached full scale
+	EventDevice                   // A capture device changed rate or was lost
+	EventSilence                  // Sustained silence is stopping the recording
+	EventSpeech                   // Speech started or ended; the event time is the edge's capture time
+	EventStop                     // Recording stopped; the message is the last output file
+	EventError                    // A panic stopped the recording; the message says where
+)
+
+// eventTypeNames maps each event type to the name used in the event log
+var eventTypeNames = map[EventType]string{
+	EventStart:   "start",
+	EventRotate:  "rotate",
+	EventMarker:  "marker",
+	EventClip:    "clip",
+	EventDevice:  "device",
+	EventSilence: "silence",
+	EventSpeech:  "speech",
+	EventStop:    "stop",
+	EventError:   "error",
+}
+
+// String returns the event log name of the event type
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is one entry of the recording's event stream
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Message string
+}
+
+// EventBus delivers events to every subscriber in the order they were published.
+// Handlers run on the publishing goroutine, one event at a time, so they must be
+// quick and must not publish themselves.
+type EventBus struct {
+	handlers []func(Event)
+	mutex    sync.Mutex
+}
+
+// Subscribe registers a handler for all events published after the call
+func (b *EventBus) Subscribe(handler func(Event)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+// Publish delivers an event to every subscriber
+func (b *EventBus) Publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+}
+
+// EventLog writes events as timestamped lines to a text file. Each line starts with
+// the local wall-clock time and its UTC offset, for reading, followed by the time since
+// the recording started. The offset comes from the monotonic clock of times taken with
+// time.Now, so it keeps counting up across daylight-saving changes and clock
+// adjustments that make the wall-clock times repeat or jump back.
+type EventLog struct {
+	file  *os.File
+	start time.Time // Start of the recording, which offsets are measured from
+	mutex sync.Mutex
+}
+
+// NewEventLog creates the log file, replacing any existing file at path. Offsets are
+// measured from start.
+func NewEventLog(path string, start time.Time) (*EventLog, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{file: file, start: start}, nil
+}
+
+// AppendEventLog is NewEventLog that continues an existing log at path instead of
+// replacing it, e.g. for a resumed recording. Offsets of the new events are measured
+// from start.
+func AppendEventLog(path string, start time.Time) (*EventLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{file: file, start: start}, nil
+}
+
+// Handle writes one event; subscribe it to an EventBus. Events after Close are dropped.
+func (l *EventLog) Handle(event Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return
+	}
+	line := fmt.Sprintf("%s  %s  %-7s  %s\n", event.Time.Format("2006-01-02 15:04:05.000 -0700"),
+		formatOffset(event.Time.Sub(l.start)), event.Type, event.Message)
+	if _, err := l.file.WriteString(line); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing event log:", err)
+	}
+}
+
+// formatOffset formats a time since the start of a recording as +h:mm:ss.mmm
+func formatOffset(offset time.Duration) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	offset = offset.Round(time.Millisecond)
+	return fmt.Sprintf("%s%d:%02d:%02d.%03d", sign, int(offset.Hours()), int(offset.Minutes())%60,
+		int(offset.Seconds())%60, offset.Milliseconds()%1000)
+}
+
+// Close closes the log file
+func (l *EventLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logEntry is the type and message of one event log line
+type logEntry struct {
+	event, message string
+}
+
+// readEventLog returns the entries of an event log in the order they were written
+func readEventLog(t *testing.T, path string) []logEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading event log: %v", err)
+	}
+	var entries []logEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		// Wall-clock time, offset, type and message, separated by two spaces
+		fields := strings.SplitN(line, "  ", 4)
+		if len(fields) != 4 {
+			t.Fatalf("malformed event log line %q", line)
+		}
+		entries = append(entries, logEntry{strings.TrimSpace(fields[2]), strings.TrimSpace(fields[3])})
+	}
+	return entries
+}
+
+func TestEventLogOrder(t *testing.T) {
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.EventLog = true
+	})
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	first := recorder.GetOutputFilePath()
+
+	// A marker, then audio reaching full scale, written out by a rotation
+	start := time.Now()
+	recorder.AddMarker("intro")
+	recorder.AddMicSamples(slices.Repeat([]float32{1}, 800), start)
+	if err := recorder.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	second := recorder.GetOutputFilePath()
+
+	// Events from outside the recorder, such as a lost device, land in the same log
+	recorder.Events().Publish(Event{Type: EventDevice, Time: time.Now(), Message: "microphone 1 stopped unexpectedly"})
+	recorder.AddMicSamples(make([]float32, 8000), start.Add(100*time.Millisecond))
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []logEntry{
+		{"start", first},
+		{"marker", "intro"},
+		{"clip", "samples at full scale: 800"},
+		{"rotate", second},
+		{"device", "microphone 1 stopped unexpectedly"},
+		{"stop", second},
+	}
+	if got := readEventLog(t, recorder.outputBase+".log"); !slices.Equal(got, want) {
+		t.Errorf("event log =\n%v\nwant\n%v", got, want)
+	}
+
+	// The log is closed with the recording, so later events are dropped
+	recorder.Events().Publish(Event{Type: EventMarker, Time: time.Now(), Message: "late"})
+	if got := readEventLog(t, recorder.outputBase+".log"); len(got) != len(want) {
+		t.Errorf("event log has %d entries after a late event, want %d", len(got), len(want))
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	tests := []struct {
+		offset time.Duration
+		want   string
+	}{
+		{0, "+0:00:00.000"},
+		{1500 * time.Millisecond, "+0:00:01.500"},
+		{time.Hour + 2*time.Minute + 3*time.Second + 4*time.Millisecond, "+1:02:03.004"},
+		{999600 * time.Microsecond, "+0:00:01.000"},
+		{-250 * time.Millisecond, "-0:00:00.250"},
+	}
+	for _, tc := range tests {
+		if got := formatOffset(tc.offset); got != tc.want {
+			t.Errorf("formatOffset(%v) = %q, want %q", tc.offset, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// ExtractSegment reads the audio between startSec and endSec of a WAV file, e.g. the
+// lines of a transcript under review. It seeks straight to the first frame, which
+// sits at a fixed byte offset after the header, and reads only the segment, so it is
+// cheap on long recordings. Times are rounded to whole frames and clamped to the file;
+// a segment entirely outside it is empty. It returns the interleaved samples, the
+// sample rate and the channel count.
+func ExtractSegment(wavPath string, startSec, endSec float64) ([]float32, int, int, error) {
+	samples, header, _, err := extractSegment(wavPath, startSec, endSec)
+	return samples, header.SampleRate, header.Channels, err
+}
+
+// ExtractSegmentToFile writes the segment read by ExtractSegment to a WAV file in the
+// format of the source. A bext timecode is moved to the start of the segment.
+func ExtractSegmentToFile(wavPath, outputPath string, startSec, endSec float64) error {
+	samples, header, startFrame, err := extractSegment(wavPath, startSec, endSec)
+	if err != nil {
+		return err
+	}
+	if header.Bext != nil {
+		bext := *header.Bext
+		bext.TimeReference += uint64(startFrame)
+		header.Bext = &bext
+	}
+	header.DataSize = 0
+
+	output := wavWriter{filePath: outputPath}
+	err = output.create(header)
+	if err == nil {
+		err = output.append(samples)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", outputPath, err)
+	}
+	return nil
+}
+
+// extractSegment reads a segment of a WAV file and returns it with the file's header
+// and the frame the segment starts at
+func extractSegment(wavPath string, startSec, endSec float64) ([]float32, WAVHeader, int64, error) {
+	if math.IsNaN(startSec) || math.IsNaN(endSec) || endSec < startSec {
+		return nil, WAVHeader{}, 0, fmt.Errorf("invalid segment %g-%g seconds", startSec, endSec)
+	}
+
+	file, err := os.Open(wavPath)
+	if err != nil {
+		return nil, WAVHeader{}, 0, err
+	}
+	defer file.Close()
+
+	header, dataOffset, dataBytes, err := readWAVLayout(file)
+	if err != nil {
+		return nil, header, 0, err
+	}
+	format, err := sampleFormat(header)
+	if err != nil {
+		return nil, header, 0, err
+	}
+	blockAlign := int64(header.Channels * header.BitsPerSample / 8)
+	if blockAlign == 0 {
+		return nil, header, 0, fmt.Errorf("invalid format: %d channels, %d bits", header.Channels, header.BitsPerSample)
+	}
+
+	// Convert the times to frames within the file
+	frames := dataBytes / blockAlign
+	toFrame := func(seconds float64) int64 {
+		frame := math.Round(seconds * float64(header.SampleRate))
+		return int64(max(0, min(frame, float64(frames))))
+	}
+	startFrame, endFrame := toFrame(startSec), toFrame(endSec)
+
+	if _, err := file.Seek(dataOffset+startFrame*blockAlign, io.SeekStart); err != nil {
+		return nil, header, startFrame, err
+	}
+	data := make([]byte, (endFrame-startFrame)*blockAlign)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, header, startFrame, err
+	}
+
+	width := header.BitsPerSample / 8
+	return DecodeSamples(nil, data, format, len(data)/width), header, startFrame, nil
+}
@@ -0,0 +1,72 @@
+package audio
+
+import "math"
+
+// MeanAbsLevel returns the average absolute sample value (0-1 for normalized audio)
+func MeanAbsLevel(samples []float32) float32 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	level := float32(0)
+	for _, value := range samples {
+		if value < 0 {
+			level -= value
+		} else {
+			level += value
+		}
+	}
+
+	return level / float32(len(samples))
+}
+
+// RMSLevel returns the root mean square of the samples (0-1 for normalized audio)
+func RMSLevel(samples []float32) float32 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sum := float64(0)
+	for _, value := range samples {
+		sum += float64(value) * float64(value)
+	}
+
+	return float32(math.Sqrt(sum / float64(len(samples))))
+}
+
+// PerChannelRMS returns the RMS level of each channel of interleaved samples
+func PerChannelRMS(samples []float32, channels int) []float32 {
+	levels := make([]float32, channels)
+	frames := len(samples) / channels
+	if frames == 0 {
+		return levels
+	}
+
+	sums := make([]float64, channels)
+	for i := 0; i < frames*channels; i++ {
+		sums[i%channels] += float64(samples[i]) * float64(samples[i])
+	}
+	for ch, sum := range sums {
+		levels[ch] = float32(math.Sqrt(sum / float64(frames)))
+	}
+
+	return levels
+}
+
+// PerChannelPeak returns the largest absolute sample value of each channel of interleaved samples
+func PerChannelPeak(samples []float32, channels int) []float32 {
+	peaks := make([]float32, channels)
+	frames := len(samples) / channels
+
+	for i := 0; i < frames*channels; i++ {
+		value := samples[i]
+		if value < 0 {
+			value = -value
+		}
+		if value > peaks[i%channels] {
+			peaks[i%channels] = value
+		}
+	}
+
+	return peaks
+}
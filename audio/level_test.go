@@ -0,0 +1,64 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+// interleave joins equal-length channels into interleaved frames
+func interleave(channels ...[]float32) []float32 {
+	samples := make([]float32, 0, len(channels)*len(channels[0]))
+	for i := range channels[0] {
+		for _, channel := range channels {
+			samples = append(samples, channel[i])
+		}
+	}
+	return samples
+}
+
+func TestPerChannelRMS(t *testing.T) {
+	loud := sine(1000, 0.8)
+	quiet := sine(440, 0.1)
+	silent := make([]float32, len(loud))
+
+	tests := []struct {
+		name     string
+		samples  []float32
+		channels int
+		want     []float64
+	}{
+		{"Empty", nil, 2, []float64{0, 0}},
+		{"EmptySlice", []float32{}, 1, []float64{0}},
+		// A partial frame has no level of its own
+		{"PartialFrame", []float32{0.5}, 2, []float64{0, 0}},
+		{"Mono", loud, 1, []float64{sineRMS(0.8)}},
+		{"LeftLoudRightSilent", interleave(loud, silent), 2, []float64{sineRMS(0.8), 0}},
+		{"LeftSilentRightLoud", interleave(silent, loud), 2, []float64{0, sineRMS(0.8)}},
+		{"ThreeChannels", interleave(quiet, silent, loud), 3, []float64{sineRMS(0.1), 0, sineRMS(0.8)}},
+		// A trailing partial frame is ignored rather than counted against channel 0
+		{"TrailingPartialFrame", append(interleave(loud, silent), 1), 2, []float64{sineRMS(0.8), 0}},
+		{"DC", interleave([]float32{-0.5, 0.5, -0.5, 0.5}, []float32{0.25, 0.25, 0.25, 0.25}), 2,
+			[]float64{0.5, 0.25}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := PerChannelRMS(tc.samples, tc.channels)
+			if len(got) != len(tc.want) {
+				t.Fatalf("PerChannelRMS = %d levels, want %d", len(got), len(tc.want))
+			}
+			for ch, want := range tc.want {
+				if !near(got[ch], want) {
+					t.Errorf("channel %d RMS = %.4f, want %.4f", ch, got[ch], want)
+				}
+			}
+		})
+	}
+
+	// Every channel of a whole interleaved signal matches RMSLevel of that channel alone
+	got := PerChannelRMS(interleave(quiet, loud), 2)
+	for ch, channel := range [][]float32{quiet, loud} {
+		if want := RMSLevel(channel); math.Abs(float64(got[ch]-want)) > 1e-6 {
+			t.Errorf("channel %d RMS = %v, RMSLevel = %v", ch, got[ch], want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package audio
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// loopbackSilenceLevel is the RMS level below which loopback audio counts as silent
+const loopbackSilenceLevel = 0.001
+
+// VerifyLoopback plays a short tone on the default output device and checks that it
+// arrives on the loopback capture within timeout. It returns the loudest RMS level
+// captured and an error if the loopback stayed silent or could not be opened.
+func VerifyLoopback(ctx malgo.Context, sampleRate, channels int, timeout time.Duration) (float32, error) {
+	var peak float32
+	var peakMutex sync.Mutex
+	heard := make(chan struct{})
+	var heardOnce sync.Once
+
+	capturer, err := NewCapturer(ctx, malgo.Loopback, nil, sampleRate, channels,
+		func(samples []float32, timestamp time.Time) {
+			level := RMSLevel(samples)
+
+			peakMutex.Lock()
+			if level > peak {
+				peak = level
+			}
+			peakMutex.Unlock()
+
+			if level >= loopbackSilenceLevel {
+				heardOnce.Do(func() { close(heard) })
+			}
+		})
+	if err != nil {
+		return 0, fmt.Errorf("opening loopback capture: %w", err)
+	}
+	defer capturer.Uninit()
+
+	player, err := NewTonePlayer(ctx, NewToneGenerator(440, 0.25, sampleRate, channels))
+	if err != nil {
+		return 0, fmt.Errorf("opening playback device: %w", err)
+	}
+	defer player.Uninit()
+
+	if err := capturer.Start(); err != nil {
+		return 0, fmt.Errorf("starting loopback capture: %w", err)
+	}
+	defer capturer.Stop()
+
+	if err := player.Start(); err != nil {
+		return 0, fmt.Errorf("starting playback: %w", err)
+	}
+	defer player.Stop()
+
+	select {
+	case <-heard:
+	case <-time.After(timeout):
+	}
+
+	peakMutex.Lock()
+	defer peakMutex.Unlock()
+
+	if peak < loopbackSilenceLevel {
+		return peak, fmt.Errorf("loopback capture stayed silent for %s while a tone was playing", timeout)
+	}
+	return peak, nil
+}
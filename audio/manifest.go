@@ -0,0 +1,41 @@
+package audio
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Manifest describes a finished recording for cataloging tools, such as a system
+// filing recordings by meeting ID. It is written as JSON next to the recording.
+type Manifest struct {
+	Name          string            `json:"name"`
+	Start         time.Time         `json:"start"`
+	Duration      float64           `json:"duration_seconds"`
+	SampleRate    int               `json:"sample_rate"`
+	Channels      int               `json:"channels"`
+	BitsPerSample int               `json:"bits_per_sample"`
+	Float         bool              `json:"float,omitempty"`
+	Files         []string          `json:"files"` // Audio files, relative to the manifest
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+// WriteManifest writes a manifest to path as indented JSON
+func WriteManifest(path string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadManifest reads a manifest written by WriteManifest
+func ReadManifest(path string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
@@ -0,0 +1,115 @@
+package audio
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRecordingTags(t *testing.T) {
+	tags := map[string]string{"meeting": "4711", "project": "Apollo"}
+
+	tests := []struct {
+		name         string
+		tags         map[string]string
+		tagsInWAV    bool
+		manifest     bool
+		wantManifest bool
+	}{
+		{"NoTags", nil, false, false, false},
+		// Tags alone go to the manifest, leaving the WAV files without an INFO chunk
+		{"Tags", tags, false, false, true},
+		{"TagsInWAV", tags, true, false, true},
+		{"ManifestWithoutTags", nil, false, true, true},
+		// Without tags there is nothing for an INFO chunk to hold
+		{"TagsInWAVWithoutTags", nil, true, false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.Tags = tc.tags
+				config.TagsInWAV = tc.tagsInWAV
+				config.Manifest = tc.manifest
+				config.TranscriptionOutput = true
+			})
+			recorder.DisableSpeaker()
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+			recorder.AddMicSamples(sineChunks(8000, 8000, 8000, 0.5)[0], time.Now())
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			wantWAVTags := tc.tagsInWAV && len(tc.tags) > 0
+			for _, path := range []string{recorder.GetOutputFilePath(), recorder.GetTranscriptionFilePath()} {
+				header, _, err := ProbeWAV(path)
+				if err != nil {
+					t.Fatalf("ProbeWAV(%s): %v", filepath.Base(path), err)
+				}
+				if got := header.Tags != nil; got != wantWAVTags {
+					t.Errorf("%s has tags %v, want tags %v", filepath.Base(path), header.Tags, wantWAVTags)
+				} else if wantWAVTags && !maps.Equal(header.Tags, tc.tags) {
+					t.Errorf("%s tags = %v, want %v", filepath.Base(path), header.Tags, tc.tags)
+				}
+			}
+
+			manifestPath := recorder.outputBase + ".json"
+			manifest, err := ReadManifest(manifestPath)
+			if !tc.wantManifest {
+				if !os.IsNotExist(err) {
+					t.Errorf("manifest written without tags or -manifest: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadManifest: %v", err)
+			}
+			if !maps.Equal(manifest.Tags, tc.tags) {
+				t.Errorf("manifest tags = %v, want %v", manifest.Tags, tc.tags)
+			}
+			wantFiles := []string{
+				filepath.Base(recorder.GetOutputFilePath()),
+				filepath.Base(recorder.GetTranscriptionFilePath()),
+			}
+			if !slices.Equal(manifest.Files, wantFiles) {
+				t.Errorf("manifest files = %v, want %v", manifest.Files, wantFiles)
+			}
+			if manifest.Name != "test" || manifest.SampleRate != 8000 || manifest.Channels != 1 ||
+				manifest.BitsPerSample != 16 || manifest.Duration <= 0 {
+				t.Errorf("manifest = %+v, want test at 8000 Hz, mono, 16-bit with a duration", manifest)
+			}
+		})
+	}
+}
+
+func TestManifestListsParts(t *testing.T) {
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.Manifest = true
+	})
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.AddMicSamples(make([]float32, 8000), time.Now())
+	if err := recorder.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.AddMicSamples(make([]float32, 8000), time.Now())
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ReadManifest(recorder.outputBase + ".json")
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	// The first file keeps its name and the rotation continues in part 2
+	want := []string{filepath.Base(recorder.outputBase) + ".wav", filepath.Base(partPath(recorder.outputBase, 2))}
+	if !slices.Equal(manifest.Files, want) {
+		t.Errorf("manifest files = %v, want %v", manifest.Files, want)
+	}
+}
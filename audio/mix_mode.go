@@ -0,0 +1,63 @@
+package audio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MixMode selects how microphone and speaker streams are combined
+type MixMode int
+
+const (
+	MixAverage     MixMode = iota // 50/50 average where both sources overlap (default)
+	MixWeighted                   // Per-source weights from MicWeight/SpeakerWeight
+	MixSumLimit                   // Full-level sum, limited to the [-1, 1] range
+	MixStereoSplit                // Microphone on the left channel, speaker on the right
+	MixDuck                       // Speaker attenuated while the microphone is active
+	MixMultitrack                 // Microphone channels then speaker channels, unmixed
+)
+
+// mixModeNames maps each mix mode to its command line name
+var mixModeNames = map[MixMode]string{
+	MixAverage:     "average",
+	MixWeighted:    "weighted",
+	MixSumLimit:    "sumlimit",
+	MixStereoSplit: "stereo",
+	MixDuck:        "duck",
+	MixMultitrack:  "multitrack",
+}
+
+// String returns the command line name of the mix mode
+func (m MixMode) String() string {
+	if name, ok := mixModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("MixMode(%d)", int(m))
+}
+
+// MarshalText encodes the mix mode by its command line name, e.g. in JSON
+func (m MixMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// ParseMixMode converts a command line name into a mix mode
+func ParseMixMode(name string) (MixMode, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for mode, modeName := range mixModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return MixAverage, fmt.Errorf("unknown mix mode %q", name)
+}
+
+// OutputChannels returns the number of channels the mix mode produces
+func (m MixMode) OutputChannels(inputChannels int) int {
+	switch m {
+	case MixStereoSplit:
+		return 2
+	case MixMultitrack:
+		return inputChannels * 2
+	}
+	return inputChannels
+}
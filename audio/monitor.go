@@ -0,0 +1,139 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// Monitor plays the recording's mix on an output device so it can be heard while it
+// is made, e.g. on headphones during an interview. It reads the mix through a tap on
+// the recorder's mixed output (see Recorder.NewMixedTap), so it plays microphones and
+// speaker as they are recorded and never takes audio away from the file.
+//
+// The recorder mixes every RecordingConfig.MixInterval, so the monitor plays about
+// that far behind the capture, plus its own latency. It waits until half its latency
+// is queued before playing, which bridges the time between mixes. Audio that queues
+// up beyond the latency is dropped, and when the queue runs dry the device plays
+// silence until it has refilled.
+type Monitor struct {
+	device    *malgo.Device
+	reader    *BroadcastReader
+	channels  int
+	maxQueued int // Samples kept before the oldest are dropped
+	minQueued int // Samples queued before playback starts or resumes after an underrun
+	queue     []float32
+	playing   bool
+	mixed     []float32
+}
+
+// NewMonitor initializes a playback device for the mix that reader taps, at its sample
+// rate and channel count. A nil deviceID selects the default output. The latency is
+// how much audio may wait in the queue; small values react faster but underrun more
+// easily, and it should be at least twice the recorder's mix interval. The monitor
+// closes the reader when it is released.
+func NewMonitor(ctx malgo.Context, deviceID *malgo.DeviceID, reader *BroadcastReader,
+	latency time.Duration) (*Monitor, error) {
+	m := newMonitor(reader, latency)
+
+	// Ask for periods well below the latency so the queue drains smoothly
+	periodMs := uint32(latency.Milliseconds() / 4)
+	if periodMs < 5 {
+		periodMs = 5
+	}
+
+	deviceConfig := malgo.DeviceConfig{
+		DeviceType:               malgo.Playback,
+		SampleRate:               uint32(reader.buffer.SampleRate()),
+		PeriodSizeInMilliseconds: periodMs,
+		Playback: malgo.SubConfig{
+			Format:   malgo.FormatF32,
+			Channels: uint32(m.channels),
+		},
+	}
+	if deviceID != nil {
+		deviceConfig.Playback.DeviceID = deviceID.Pointer()
+	}
+
+	device, err := malgo.InitDevice(ctx, deviceConfig, malgo.DeviceCallbacks{
+		Data: m.dataCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.device = device
+
+	return m, nil
+}
+
+// newMonitor creates a monitor of the mix that reader taps, without its device
+func newMonitor(reader *BroadcastReader, latency time.Duration) *Monitor {
+	channels := reader.buffer.Channels()
+	maxFrames := int(latency.Seconds() * float64(reader.buffer.SampleRate()))
+	return &Monitor{
+		reader:    reader,
+		channels:  channels,
+		maxQueued: maxFrames * channels,
+		minQueued: maxFrames / 2 * channels,
+	}
+}
+
+// Start starts playback
+func (m *Monitor) Start() error {
+	return m.device.Start()
+}
+
+// Stop stops playback
+func (m *Monitor) Stop() error {
+	return m.device.Stop()
+}
+
+// Uninit releases the underlying device and closes the reader
+func (m *Monitor) Uninit() {
+	m.device.Uninit()
+	m.reader.Close()
+}
+
+// fill queues what the recorder mixed since the last call and copies the oldest
+// queued audio into out, with silence for whatever the queue can't cover
+func (m *Monitor) fill(out []float32) {
+	if samples, _ := m.reader.Read(); len(samples) > 0 {
+		m.queue = append(m.queue, samples...)
+		if excess := len(m.queue) - m.maxQueued; excess > 0 {
+			// Drop whole frames from the front to get back to the latency
+			excess = (excess + m.channels - 1) / m.channels * m.channels
+			m.queue = append(m.queue[:0], m.queue[excess:]...)
+		}
+	}
+
+	if !m.playing && len(m.queue) >= m.minQueued {
+		m.playing = true
+	}
+	n := 0
+	if m.playing {
+		n = copy(out, m.queue)
+		m.queue = append(m.queue[:0], m.queue[n:]...)
+		if n < len(out) {
+			m.playing = false // Underrun: refill before playing again
+		}
+	}
+	clear(out[n:])
+}
+
+// dataCallback plays the queued mix on the device
+func (m *Monitor) dataCallback(output, input []byte, frameCount uint32) {
+	count := int(frameCount) * m.channels
+	if cap(m.mixed) < count {
+		m.mixed = make([]float32, count)
+	}
+	mixed := m.mixed[:count]
+	m.fill(mixed)
+
+	for i, sample := range mixed {
+		if i*4+3 < len(output) {
+			binary.LittleEndian.PutUint32(output[i*4:i*4+4], math.Float32bits(clampSample(sample)))
+		}
+	}
+}
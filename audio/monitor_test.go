@@ -0,0 +1,75 @@
+package audio
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMonitorFill(t *testing.T) {
+	// ramp returns count mono samples counting up from start, so each is identifiable
+	ramp := func(start, count int) []float32 {
+		samples := make([]float32, count)
+		for i := range samples {
+			samples[i] = float32(start + i)
+		}
+		return samples
+	}
+
+	type step struct {
+		write int       // Samples the recorder mixes before the device asks for audio
+		want  []float32 // What the device plays for a 100-sample period
+	}
+	silence := make([]float32, 100)
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		// 100ms at 8 kHz queues at most 800 samples and starts playing at 400
+		{"WaitsForHalfTheLatency", []step{
+			{300, silence},
+			{100, ramp(0, 100)},
+			{0, ramp(100, 100)},
+		}},
+		{"DropsBeyondTheLatency", []step{
+			{1000, ramp(200, 100)},
+			{0, ramp(300, 100)},
+		}},
+		{"SilenceOnUnderrun", []step{
+			{400, ramp(0, 100)},
+			{0, ramp(100, 100)},
+			{0, ramp(200, 100)},
+			{0, ramp(300, 100)},
+			{0, silence},
+			// After an underrun it refills before playing again
+			{300, silence},
+			{100, ramp(400, 100)},
+		}},
+		{"PartialPeriodThenSilence", []step{
+			{450, ramp(0, 100)},
+			{0, ramp(100, 100)},
+			{0, ramp(200, 100)},
+			{0, ramp(300, 100)},
+			{0, slices.Concat(ramp(400, 50), make([]float32, 50))},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mix := NewBroadcastBuffer(8000, 1, 1)
+			monitor := newMonitor(mix.NewReader(), 100*time.Millisecond)
+
+			written := 0
+			for i, step := range tc.steps {
+				if step.write > 0 {
+					mix.Write(ramp(written, step.write), time.Now())
+					written += step.write
+				}
+				played := make([]float32, 100)
+				monitor.fill(played)
+				if !slices.Equal(played, step.want) {
+					t.Fatalf("period %d played %v..., want %v...", i, played[:3], step.want[:3])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package audio
+
+import (
+	"math"
+	"sync"
+)
+
+// AudioProcessor transforms a stream of interleaved samples chunk by chunk.
+// Implementations keep any filter state between calls so chunk boundaries are seamless.
+type AudioProcessor interface {
+	// Process returns the processed samples; it may modify and return the input slice
+	Process(samples []float32, sampleRate, channels int) []float32
+	// Reset clears any state carried between chunks
+	Reset()
+}
+
+// ProcessorChain applies a list of processors in the order they were added
+type ProcessorChain struct {
+	processors []AudioProcessor
+	mutex      sync.Mutex
+}
+
+// Add appends a processor to the end of the chain
+func (c *ProcessorChain) Add(p AudioProcessor) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.processors = append(c.processors, p)
+}
+
+// Process runs the samples through every processor in order
+func (c *ProcessorChain) Process(samples []float32, sampleRate, channels int) []float32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, p := range c.processors {
+		samples = p.Process(samples, sampleRate, channels)
+	}
+	return samples
+}
+
+// Reset clears the state of every processor in the chain
+func (c *ProcessorChain) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, p := range c.processors {
+		p.Reset()
+	}
+}
+
+// GainProcessor scales samples by a fixed gain, clamping to the valid range
+type GainProcessor struct {
+	Gain float32
+}
+
+// Process applies the gain
+func (g *GainProcessor) Process(samples []float32, sampleRate, channels int) []float32 {
+	for i, sample := range samples {
+		samples[i] = clampSample(sample * g.Gain)
+	}
+	return samples
+}
+
+// Reset does nothing; the gain processor is stateless
+func (g *GainProcessor) Reset() {}
+
+// HighPassProcessor is a one-pole high-pass filter that removes rumble and DC offset
+type HighPassProcessor struct {
+	CutoffHz float64
+
+	prevInput  []float32
+	prevOutput []float32
+}
+
+// Process filters each channel, continuing from the previous chunk's state
+func (h *HighPassProcessor) Process(samples []float32, sampleRate, channels int) []float32 {
+	if len(h.prevInput) != channels {
+		h.prevInput = make([]float32, channels)
+		h.prevOutput = make([]float32, channels)
+	}
+
+	rc := 1 / (2 * math.Pi * h.CutoffHz)
+	dt := 1 / float64(sampleRate)
+	alpha := float32(rc / (rc + dt))
+
+	for i, sample := range samples {
+		ch := i % channels
+		output := alpha * (h.prevOutput[ch] + sample - h.prevInput[ch])
+		h.prevInput[ch] = sample
+		h.prevOutput[ch] = output
+		samples[i] = output
+	}
+	return samples
+}
+
+// Reset clears the filter history
+func (h *HighPassProcessor) Reset() {
+	h.prevInput = nil
+	h.prevOutput = nil
+}
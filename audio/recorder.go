@@ -1,172 +1,1562 @@
 package audio
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // RecordingConfig contains configuration for the recorder
 type RecordingConfig struct {
-	ChunkDurationSeconds int    // Duration between saves in seconds
+	ChunkDurationSeconds int    // Duration between saves in seconds, at least 1
 	OutputFolder         string // Where to save the recordings
+	SessionFolders       bool   // Put each recording's files in their own <name>_<timestamp> folder
 	RecordingName        string // Base name for recordings
 	SampleRate           int    // Audio sample rate
 	Channels             int    // Number of audio channels
+	BitsPerSample        int    // PCM depth of the recording: 16, 24 or 32 (0 means 16)
+	FloatWAV             bool   // Write 32-bit IEEE float instead of PCM, keeping the captured floats exactly
+
+	// Gain of each microphone, one entry per mic; empty means a single mic at unity gain.
+	// The microphones are summed into one mic stream before it is mixed with the speaker.
+	MicGains []float32
+
+	// HighPrecisionMix sums the microphones in float64 and rounds once at the end,
+	// instead of rounding after every addition (see TimeSyncMixNHighPrecision).
+	// Worth it when mixing many microphones; mixes of two sources gain nothing.
+	HighPrecisionMix bool
+
+	TranscriptionOutput bool            // Also write a 16kHz mono copy for transcription
+	DownmixWeights      []float32       // Per-channel weights when collapsing input to mono; empty averages
+	ResampleQuality     ResampleQuality // Interpolation used to convert sources and the transcription copy
+	BroadcastWave       bool            // Write a BWF bext chunk with the start timecode
+	KeepEmpty           bool            // Keep recordings that captured no audio instead of deleting them
+	EventLog            bool            // Write the recording's events to <name>_<timestamp>.log
+
+	// FsyncInterval syncs written audio to disk on saves at least this far apart, so a
+	// crash or power loss cannot lose data the OS had not flushed yet. Any value up to
+	// the chunk duration syncs on every save. Syncing blocks the writer until the disk
+	// confirms, which can be slow on some filesystems; 0 leaves flushing to the OS.
+	FsyncInterval time.Duration
+
+	// HeaderUpdateInterval patches the WAV header sizes on saves at least this far apart
+	// instead of on every save, saving two seeks and a write each time. The header is
+	// always correct once the file is finished; a crash in between leaves it short,
+	// which RepairWAVHeader fixes. 0 updates the header on every save.
+	HeaderUpdateInterval time.Duration
+
+	// MinWriteBlock holds mixed audio back until at least this much is ready and then
+	// appends it in one write, so frequent saves don't become many tiny appends that
+	// each patch the header and, with FsyncInterval, sync. Stopping and moving to the
+	// next part write whatever is held. A crash loses at most one block more than it
+	// otherwise would. 0 appends on every save.
+	MinWriteBlock time.Duration
+
+	// MixInterval mixes the captured audio this often between saves, so taps on the
+	// mixed output (see NewMixedTap), such as a monitor, get it within about this long
+	// instead of at the next save. The file is still only written on saves. 0 mixes on
+	// saves only.
+	MixInterval time.Duration
+
+	// PartialFiles writes each output file as <name>.partial and renames it to its
+	// final name once it is finished, so tools watching the output folder never pick
+	// up a file that is still growing. A crash leaves the .partial file behind.
+	PartialFiles bool
+
+	// SeekIndexInterval writes a <file>.idx sidecar next to each output file with the
+	// byte offset of every position this far apart, for players seeking in long files
+	// (0 writes no index). The index describes the WAV file, not archived copies.
+	SeekIndexInterval time.Duration
+
+	// Sink receives the recording instead of a file in OutputFolder, e.g. a buffer in
+	// memory or a writer to blob storage. The WAV file is written from offset 0 and its
+	// header is patched in place, so the sink must allow seeking back; a sink with a
+	// Sync method is synced like a file. Companion files such as the transcription copy
+	// and event log are still written to OutputFolder. A sink holds one file, so it
+	// can't be split into parts, written as a partial file or indexed.
+	Sink io.WriteSeeker
+
+	// ResumePath continues an earlier recording in its existing WAV file, e.g. after the
+	// process was restarted, instead of starting a new file in OutputFolder. The file is
+	// repaired if it wasn't finalized and must have the recording's format; new audio is
+	// appended after its own. Companion files keep its name, and an existing
+	// transcription copy and event log are continued too. A resumed recording is one
+	// file without a start tone, so it can't be split into parts or use partial files.
+	ResumePath string
+
+	// PartDurationSeconds splits the recording into numbered files of about this length,
+	// switching files at the first save past the limit (0 keeps a single file)
+	PartDurationSeconds int
+
+	MixMode       MixMode // How microphone and speaker are combined
+	MicWeight     float32 // Microphone weight for MixWeighted
+	SpeakerWeight float32 // Speaker weight for MixWeighted
+	DuckThreshold float32 // Microphone level that triggers ducking for MixDuck
+	DuckLevel     float32 // Speaker gain while ducked for MixDuck (0-1)
+
+	// MixTargetPeak is the highest level of the mix as a fraction of full scale
+	// (0 means 1.0), lowered further by MixHeadroomDB. Below full scale the mix is
+	// scaled so a full-scale source peaks at the target and limited there, so one
+	// source alone and both together come out equally loud; MixAverage then sums
+	// overlapping sources at full level instead of halving them.
+	MixTargetPeak float32
+	MixHeadroomDB float32 // Headroom in dB kept below MixTargetPeak (0 means none)
+
+	// ChannelMap sets the source of each output channel for MixStereoSplit, e.g.
+	// speaker, mic to swap the sides or more entries for a multichannel file.
+	// Empty is mic left, speaker right.
+	ChannelMap []ChannelSource
+
+	// StartTone writes a short beep as the first samples of the recording, ahead of the
+	// captured audio, so editors can line the audio up with video that heard it too.
+	// It is added as a "start tone" marker whose negative offset is the tone's length.
+	StartTone          bool
+	StartToneFrequency float64       // Frequency of the start tone in Hz (0 means 1000)
+	StartToneDuration  time.Duration // Length of the start tone (0 means 500ms)
+
+	// SpeechEvents publishes EventSpeech when speech starts and ends in the mix, giving
+	// the event log an activity timeline (see SpeechDetector)
+	SpeechEvents     bool
+	SpeechThreshold  float32       // RMS level at which audio counts as speech (0 means 0.02)
+	SpeechMinSilence time.Duration // Pauses shorter than this don't end speech (0 means 500ms)
+
+	StopAfterSilenceSeconds int     // Stop after this much sustained silence (0 disables)
+	SilenceThreshold        float32 // RMS input level below which audio counts as silence
+
+	// Tags label the recording for cataloging, e.g. a meeting ID or project. They are
+	// written to the recording's manifest and, with TagsInWAV, to a LIST/INFO chunk of
+	// every output file (see ValidateTags for what keys and values may hold).
+	Tags      map[string]string
+	TagsInWAV bool
+
+	// Manifest writes <name>_<timestamp>.json next to the finished recording, listing
+	// its files, format and tags (see Manifest). A recording with tags always gets one,
+	// since that is where its tags are kept.
+	Manifest bool
+
+	Clock Clock // Time source for timestamps and timers; nil uses the system clock
+}
+
+// Validate checks the configuration, including the parameters of the selected mix mode
+func (c RecordingConfig) Validate() error {
+	// Without a positive interval the save timer would fire continuously
+	if c.ChunkDurationSeconds < 1 {
+		return fmt.Errorf("chunk duration must be at least 1 second, got %d", c.ChunkDurationSeconds)
+	}
+
+	switch c.MixMode {
+	case MixAverage, MixSumLimit, MixStereoSplit, MixMultitrack:
+		// No mode-specific parameters
+	case MixWeighted:
+		if c.MicWeight < 0 || c.SpeakerWeight < 0 {
+			return fmt.Errorf("mix weights must not be negative (mic %.2f, speaker %.2f)",
+				c.MicWeight, c.SpeakerWeight)
+		}
+		if c.MicWeight == 0 && c.SpeakerWeight == 0 {
+			return fmt.Errorf("mix weights must not both be zero")
+		}
+	case MixDuck:
+		if c.DuckThreshold <= 0 || c.DuckThreshold > 1 {
+			return fmt.Errorf("duck threshold must be in (0, 1], got %.2f", c.DuckThreshold)
+		}
+		if c.DuckLevel < 0 || c.DuckLevel > 1 {
+			return fmt.Errorf("duck level must be in [0, 1], got %.2f", c.DuckLevel)
+		}
+	default:
+		return fmt.Errorf("unknown mix mode %d", int(c.MixMode))
+	}
+
+	if err := ValidateChannels(c.Channels); err != nil {
+		return err
+	}
+	if c.MixMode == MixMultitrack {
+		if err := ValidateChannels(c.OutputChannels()); err != nil {
+			return fmt.Errorf("%s mix of %d-channel sources: %w", MixMultitrack, c.Channels, err)
+		}
+	}
+
+	if len(c.ChannelMap) > 0 {
+		if c.MixMode != MixStereoSplit {
+			return fmt.Errorf("channel map needs the %s mix mode, got %s", MixStereoSplit, c.MixMode)
+		}
+		if err := ValidateChannelMap(c.ChannelMap); err != nil {
+			return err
+		}
+	}
+
+	if c.BitsPerSample != 0 {
+		if err := ValidateOutputBits(c.BitsPerSample); err != nil {
+			return err
+		}
+		if c.FloatWAV && c.BitsPerSample != 32 {
+			return fmt.Errorf("float WAV is always 32-bit, got %d bits per sample", c.BitsPerSample)
+		}
+	}
+
+	for i, gain := range c.MicGains {
+		if gain < 0 {
+			return fmt.Errorf("gain of microphone %d must not be negative, got %.2f", i, gain)
+		}
+	}
+
+	if c.PartDurationSeconds < 0 {
+		return fmt.Errorf("part duration must not be negative, got %d", c.PartDurationSeconds)
+	}
+
+	if c.ResumePath != "" {
+		switch {
+		case c.PartDurationSeconds > 0:
+			return fmt.Errorf("a resumed recording can't be split into parts")
+		case c.PartialFiles:
+			return fmt.Errorf("a resumed recording can't use partial files")
+		case c.Sink != nil:
+			return fmt.Errorf("a resumed recording is written to its file, not a sink")
+		case c.StartTone:
+			return fmt.Errorf("a resumed recording can't start with a start tone")
+		}
+	}
+
+	if c.Sink != nil {
+		switch {
+		case c.PartDurationSeconds > 0:
+			return fmt.Errorf("a recording written to a sink can't be split into parts")
+		case c.PartialFiles:
+			return fmt.Errorf("a recording written to a sink can't use partial files")
+		case c.SeekIndexInterval > 0:
+			return fmt.Errorf("a recording written to a sink can't have a seek index")
+		}
+	}
+
+	if c.FsyncInterval < 0 {
+		return fmt.Errorf("fsync interval must not be negative, got %s", c.FsyncInterval)
+	}
+
+	if c.HeaderUpdateInterval < 0 {
+		return fmt.Errorf("header update interval must not be negative, got %s", c.HeaderUpdateInterval)
+	}
+	if c.MinWriteBlock < 0 {
+		return fmt.Errorf("minimum write block must not be negative, got %s", c.MinWriteBlock)
+	}
+	if c.MixInterval < 0 {
+		return fmt.Errorf("mix interval must not be negative, got %s", c.MixInterval)
+	}
+
+	if c.StartTone {
+		if c.StartToneFrequency < 0 || c.StartToneFrequency >= float64(c.SampleRate)/2 {
+			return fmt.Errorf("start tone frequency must be below %d Hz, got %.0f", c.SampleRate/2, c.StartToneFrequency)
+		}
+		if c.StartToneDuration < 0 || c.StartToneDuration > maxStartToneDuration {
+			return fmt.Errorf("start tone duration must be at most %s, got %s", maxStartToneDuration, c.StartToneDuration)
+		}
+	}
+
+	if c.MixTargetPeak < 0 || c.MixTargetPeak > 1 {
+		return fmt.Errorf("mix target peak must be in (0, 1], got %.2f", c.MixTargetPeak)
+	}
+	if c.MixHeadroomDB < 0 || c.MixHeadroomDB > maxMixHeadroomDB {
+		return fmt.Errorf("mix headroom must be between 0 and %d dB, got %.1f", maxMixHeadroomDB, c.MixHeadroomDB)
+	}
+
+	if c.SpeechThreshold < 0 || c.SpeechThreshold > 1 {
+		return fmt.Errorf("speech threshold must be in [0, 1], got %.3f", c.SpeechThreshold)
+	}
+	if c.SpeechMinSilence < 0 {
+		return fmt.Errorf("speech minimum silence must not be negative, got %s", c.SpeechMinSilence)
+	}
+
+	if c.SeekIndexInterval < 0 {
+		return fmt.Errorf("seek index interval must not be negative, got %s", c.SeekIndexInterval)
+	}
+
+	if len(c.DownmixWeights) > 0 {
+		if err := ValidateDownmixWeights(c.DownmixWeights, c.Channels); err != nil {
+			return err
+		}
+	}
+
+	if c.TranscriptionOutput {
+		if err := ValidateResampleRates(c.SampleRate, TranscriptionSampleRate); err != nil {
+			return fmt.Errorf("transcription copy: %w", err)
+		}
+	}
+
+	if err := ValidateTags(c.Tags); err != nil {
+		return err
+	}
+
+	if c.StopAfterSilenceSeconds < 0 {
+		return fmt.Errorf("silence timeout must not be negative, got %d", c.StopAfterSilenceSeconds)
+	}
+	if c.StopAfterSilenceSeconds > 0 && (c.SilenceThreshold <= 0 || c.SilenceThreshold > 1) {
+		return fmt.Errorf("silence threshold must be in (0, 1], got %.3f", c.SilenceThreshold)
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the configuration for diagnostics, with modes by name and
+// intervals as durations such as "5s". The clock and sink are left out.
+func (c RecordingConfig) MarshalJSON() ([]byte, error) {
+	type plain RecordingConfig // Without this method, so encoding doesn't recurse
+	return json.Marshal(struct {
+		plain
+		FsyncInterval        string
+		HeaderUpdateInterval string
+		MinWriteBlock        string
+		MixInterval          string
+		SeekIndexInterval    string
+		Clock                string `json:",omitempty"`
+		Sink                 string `json:",omitempty"`
+	}{
+		plain:                plain(c),
+		FsyncInterval:        c.FsyncInterval.String(),
+		HeaderUpdateInterval: c.HeaderUpdateInterval.String(),
+		MinWriteBlock:        c.MinWriteBlock.String(),
+		MixInterval:          c.MixInterval.String(),
+		SeekIndexInterval:    c.SeekIndexInterval.String(),
+	})
+}
+
+// OutputChannels returns the number of channels written to the recording
+func (c RecordingConfig) OutputChannels() int {
+	if c.MixMode == MixStereoSplit && len(c.ChannelMap) > 0 {
+		return len(c.ChannelMap)
+	}
+	return c.MixMode.OutputChannels(c.Channels)
+}
+
+// OutputBits returns the bits per sample of the recording, 32 for float
+func (c RecordingConfig) OutputBits() int {
+	if c.FloatWAV {
+		return 32
+	}
+	if c.BitsPerSample == 0 {
+		return 16
+	}
+	return c.BitsPerSample
+}
+
+// EstimateSize returns about how many bytes a recording of the given length takes,
+// counting the main output file only
+func (c RecordingConfig) EstimateSize(durationSeconds float64) int64 {
+	return EstimateSize(durationSeconds, c.SampleRate, c.OutputChannels(), c.OutputBits())
+}
+
+// MixCeiling returns the peak level of the mix: MixTargetPeak less MixHeadroomDB
+func (c RecordingConfig) MixCeiling() float32 {
+	peak := c.MixTargetPeak
+	if peak == 0 {
+		peak = 1
+	}
+	return peak * float32(math.Pow(10, -float64(c.MixHeadroomDB)/20))
+}
+
+// MicCount returns the number of microphones recorded
+func (c RecordingConfig) MicCount() int {
+	if len(c.MicGains) == 0 {
+		return 1
+	}
+	return len(c.MicGains)
+}
+
+// micGain returns the gain of one microphone
+func (c RecordingConfig) micGain(index int) float32 {
+	if len(c.MicGains) == 0 {
+		return 1
+	}
+	return c.MicGains[index]
+}
+
+// mixedOutputSeconds is how much mixed audio is kept for taps that fall behind
+const mixedOutputSeconds = 60
+
+// Start tone defaults and limits
+const (
+	defaultStartToneFrequency = 1000
+	defaultStartToneDuration  = 500 * time.Millisecond
+	maxStartToneDuration      = 10 * time.Second
+	startToneAmplitude        = 0.5 // -6 dBFS, loud enough to see in a waveform without clipping
+)
+
+// maxMixHeadroomDB is the most headroom the mix can keep below its target peak
+const maxMixHeadroomDB = 60
+
+// Speech detection defaults
+const (
+	defaultSpeechThreshold  = 0.02
+	defaultSpeechMinSilence = 500 * time.Millisecond
+)
+
+// TranscriptionSampleRate is the sample rate Whisper-style transcribers expect
+const TranscriptionSampleRate = 16000
+
+// Recorder manages the continuous recording process
+type Recorder struct {
+	config                RecordingConfig
+	output                wavWriter
+	outputBase            string // Output path without extension, for numbering parts
+	partIndex             int
+	completedBytes        int64 // Audio bytes in parts already completed
+	resumedBytes          int64 // Audio bytes the resumed file already held
+	samplesWritten        atomic.Int64
+	nonFiniteSamples      atomic.Int64 // NaN or infinite captured samples replaced with silence
+	onFileComplete        func(path string)
+	completedFiles        []string        // Output files finished so far, for the manifest
+	seekIndex             *seekIndex      // Index of the current output file, nil without SeekIndexInterval
+	speechDetector        *SpeechDetector // Finds speech in the written mix, nil without SpeechEvents
+	transcriptionOutput   wavWriter
+	transcriptionRate     *StreamResampler // Carries the 16 kHz conversion across writes
+	micBuffers            []*Buffer
+	speakerBuffer         *Buffer
+	mixedOutput           *BroadcastBuffer
+	fileReader            *BroadcastReader
+	speakerEnabled        atomic.Bool // Read by the capture callbacks and the writer without a lock
+	startMutex            sync.Mutex  // Held by StartRecording; DisableSpeaker rebuilds the mix under it
+	micProcessors         []ProcessorChain
+	micGaps               []time.Time      // When each microphone paused, e.g. for a device switch
+	micRates              []*rateConverter // Brings each microphone to the recording rate
+	speakerRate           *rateConverter
+	micGapMutex           sync.Mutex
+	micDevices            []CaptureDevice // Device feeding each microphone, nil where none was opened
+	speakerDevice         CaptureDevice
+	deviceMutex           sync.Mutex // Guards the devices against a switch during stop
+	devicesStopped        bool
+	speakerProcessors     ProcessorChain
+	recordingActive       atomic.Bool  // Read by the capture callbacks without a lock
+	inputMutex            sync.RWMutex // Read-held by sample handlers while they add; stop takes it to wait them out
+	stopOnce              sync.Once
+	stopErr               error // Result of the shutdown, returned by every StopRecording call
+	panicked              atomic.Bool
+	writingActive         bool
+	writerWaitGroup       sync.WaitGroup
+	startTime             time.Time // Guarded by startMutex; the writer, started once it is set, reads it directly
+	stopTime              time.Time // Guarded by startMutex; freezes the duration once stopped
+	currentChunkStartTime time.Time
+	chunkDuration         time.Duration
+	chunkReset            chan struct{} // Wakes the save timer to pick up a new chunk duration
+	stopTimers            chan struct{}
+	timerMutex            sync.Mutex
+	firstSampleTime       time.Time
+	heldMix               []float32 // Mixed audio taken for the file, written on the next save
+	heldMixTime           time.Time // Capture time of heldMix[0]
+	lastSoundTime         time.Time
+	micLevels             []float32
+	micChannelLevels      [][]float32
+	speakerLevel          float32
+	speakerChannelLevels  []float32
+	levelMutex            sync.Mutex // Guards the levels and the written counters below
+	clipCount             int64      // Mixed samples written at full scale
+	outputPath            string     // Copy of the current output file, for readers outside the writer
+	outputPart            int
+	outputBytes           int64
+	outputDataStart       int64     // Offset of the current file's captured audio, after its header and any resumed audio
+	outputStart           time.Time // Copy of firstSampleTime, for ByteOffsetAt
+	markers               []Marker
+	markerMutex           sync.Mutex
+	events                EventBus
+	eventLog              *EventLog
+	writeSignal           chan bool
+	stopSignal            chan bool
+	rotateRequests        chan chan struct{}
+	done                  chan struct{}
+	debugMode             bool
+}
+
+// NewRecorder creates a new continuous recorder. It keeps its original signature for
+// existing callers: a chunk duration under one second is raised to one with a
+// warning, and any other invalid configuration panics. Use NewRecorderE, or check
+// the configuration with Validate first, to handle errors instead.
+func NewRecorder(config RecordingConfig) *Recorder {
+	if config.ChunkDurationSeconds < 1 {
+		fmt.Fprintf(os.Stderr, "Warning: chunk duration of %d seconds is too short, saving every second\n",
+			config.ChunkDurationSeconds)
+		config.ChunkDurationSeconds = 1
+	}
+	recorder, err := NewRecorderE(config)
+	if err != nil {
+		panic(fmt.Sprintf("audio.NewRecorder: %v", err))
+	}
+	return recorder
+}
+
+// NewRecorderE creates a new continuous recorder, or returns why the configuration
+// can't be recorded
+func NewRecorderE(config RecordingConfig) (*Recorder, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock{}
+	}
+	if config.StartToneFrequency == 0 {
+		config.StartToneFrequency = defaultStartToneFrequency
+	}
+	if config.StartToneDuration == 0 {
+		config.StartToneDuration = defaultStartToneDuration
+	}
+	if config.SpeechThreshold == 0 {
+		config.SpeechThreshold = defaultSpeechThreshold
+	}
+	if config.SpeechMinSilence == 0 {
+		config.SpeechMinSilence = defaultSpeechMinSilence
+	}
+
+	// Create output directory if it doesn't exist
+	os.MkdirAll(config.OutputFolder, 0755)
+
+	// Generate a single output filename
+	timestamp := config.Clock.Now().Format("2006_01_02_15_04_05")
+	outputBase := uniqueOutputBase(filepath.Join(config.OutputFolder, fmt.Sprintf("%s_%s", config.RecordingName, timestamp)))
+	if config.ResumePath != "" {
+		// Continue the earlier recording under its own name
+		existing, _, err := ProbeWAV(config.ResumePath)
+		if err != nil {
+			return nil, fmt.Errorf("resuming %s: %w", config.ResumePath, err)
+		}
+		expected := WAVHeader{SampleRate: config.SampleRate, Channels: config.OutputChannels(),
+			BitsPerSample: config.OutputBits(), Float: config.FloatWAV}
+		if !sameFormat(existing, expected) {
+			return nil, fmt.Errorf("resuming %s: file is %s, recording is %s",
+				config.ResumePath, describeFormat(existing), describeFormat(expected))
+		}
+		outputBase = strings.TrimSuffix(config.ResumePath, filepath.Ext(config.ResumePath))
+	} else if config.SessionFolders {
+		// Every file of the session goes into a folder named like the recording
+		if err := os.MkdirAll(outputBase, 0755); err != nil {
+			return nil, fmt.Errorf("creating session folder: %w", err)
+		}
+		outputBase = filepath.Join(outputBase, filepath.Base(outputBase))
+	}
+	filePath := outputBase + ".wav"
+	if config.PartDurationSeconds > 0 {
+		filePath = partPath(outputBase, 1)
+	} else if config.ResumePath != "" {
+		filePath = config.ResumePath
+	}
+
+	// The transcription copy shares the recording's name and timestamp
+	var transcriptionPath string
+	if config.TranscriptionOutput {
+		transcriptionPath = outputBase + "_16k.wav"
+	}
+
+	// A sink replaces the output file; companion files keep the recording's name
+	output := newOutputWriter(filePath, config)
+	if config.Sink != nil {
+		output = newOutputWriter("", config)
+		output.sink = config.Sink
+	}
+
+	mixedOutput := NewBroadcastBuffer(config.SampleRate, config.OutputChannels(), mixedOutputSeconds)
+
+	// One buffer, processor chain and level per microphone
+	micBuffers := make([]*Buffer, config.MicCount())
+	for i := range micBuffers {
+		micBuffers[i] = NewBuffer(config.SampleRate, config.Channels)
+	}
+
+	r := &Recorder{
+		config:              config,
+		output:              output,
+		outputBase:          outputBase,
+		partIndex:           1,
+		outputPath:          output.filePath,
+		outputPart:          1,
+		transcriptionOutput: newOutputWriter(transcriptionPath, config),
+		transcriptionRate:   NewStreamResampler(config.SampleRate, TranscriptionSampleRate, 1, config.ResampleQuality),
+		micBuffers:          micBuffers,
+		micProcessors:       make([]ProcessorChain, len(micBuffers)),
+		micGaps:             make([]time.Time, len(micBuffers)),
+		micRates:            make([]*rateConverter, len(micBuffers)),
+		micDevices:          make([]CaptureDevice, len(micBuffers)),
+		speakerRate:         &rateConverter{source: "speaker"},
+		micLevels:           make([]float32, len(micBuffers)),
+		micChannelLevels:    make([][]float32, len(micBuffers)),
+		speakerBuffer:       NewBuffer(config.SampleRate, config.Channels),
+		mixedOutput:         mixedOutput,
+		fileReader:          mixedOutput.NewReader(),
+		writingActive:       false,
+		chunkDuration:       time.Duration(config.ChunkDurationSeconds) * time.Second,
+		chunkReset:          make(chan struct{}, 1),
+		stopTimers:          make(chan struct{}),
+		writeSignal:         make(chan bool, 1),
+		stopSignal:          make(chan bool, 1),
+		rotateRequests:      make(chan chan struct{}),
+		done:                make(chan struct{}),
+		debugMode:           false,
+	}
+	for i := range r.micRates {
+		r.micRates[i] = &rateConverter{source: fmt.Sprintf("microphone %d", i+1)}
+	}
+	r.speakerEnabled.Store(true)
+	return r, nil
+}
+
+// uniqueOutputBase returns base, or base_2, base_3 and so on if files of another
+// recording already use it, so a recording started within the same second as an
+// earlier one never overwrites its audio, transcription copy or event log
+func uniqueOutputBase(base string) string {
+	candidate := base
+	for n := 2; outputBaseTaken(candidate); n++ {
+		candidate = fmt.Sprintf("%s_%d", base, n)
+	}
+	return candidate
+}
+
+// outputBaseTaken returns whether any file or session folder of a recording already
+// uses base, including parts that have since been archived to another format
+func outputBaseTaken(base string) bool {
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return false
+	}
+
+	name := filepath.Base(base)
+	for _, entry := range entries {
+		rest, found := strings.CutPrefix(entry.Name(), name)
+		if found && (rest == "" || strings.HasPrefix(rest, ".") ||
+			strings.HasPrefix(rest, "_part") || strings.HasPrefix(rest, "_16k")) {
+			return true
+		}
+	}
+	return false
+}
+
+// DisableSpeaker marks the speaker stream as absent so the recorder is mic only.
+// Call it before StartRecording so the output format reflects the single source;
+// mix modes that need both streams then pass the microphone through unchanged.
+// Called once recording has started, e.g. when the speaker device fails, it drops
+// the speaker but keeps the output format.
+func (r *Recorder) DisableSpeaker() {
+	r.startMutex.Lock()
+	defer r.startMutex.Unlock()
+
+	r.speakerEnabled.Store(false)
+	if r.startTime.IsZero() {
+		r.mixedOutput = NewBroadcastBuffer(r.config.SampleRate, r.outputChannels(), mixedOutputSeconds)
+		r.fileReader = r.mixedOutput.NewReader()
+	}
+}
+
+// IsSpeakerEnabled returns whether a speaker stream is being recorded
+func (r *Recorder) IsSpeakerEnabled() bool {
+	return r.speakerEnabled.Load()
+}
+
+// outputChannels returns the channel count of the recording for the active sources
+func (r *Recorder) outputChannels() int {
+	if !r.speakerEnabled.Load() {
+		return r.config.Channels
+	}
+	return r.config.OutputChannels()
+}
+
+// SetDebugMode enables or disables debug outputs
+func (r *Recorder) SetDebugMode(enabled bool) {
+	r.debugMode = enabled
+}
+
+// StartRecording begins the continuous recording process. It returns an error if the
+// output files can't be created or resumed; the recorder is then left unstarted, with
+// any output file it had created removed again.
+func (r *Recorder) StartRecording() error {
+	r.startMutex.Lock()
+	defer r.startMutex.Unlock()
+
+	r.startTime = r.config.Clock.Now()
+	r.timerMutex.Lock()
+	r.currentChunkStartTime = r.startTime
+	r.timerMutex.Unlock()
+	r.lastSoundTime = r.startTime
+
+	// Initialize WAV file with header
+	err := r.openOutput(&r.output, r.outputHeader(r.startTime))
+	if err != nil {
+		r.abortStart(&r.output)
+		return fmt.Errorf("initializing WAV file: %w", err)
+	}
+	r.resumedBytes = r.output.fileSize - int64(r.output.headerSize)
+	r.recordWrite(0, 0)
+	r.openSeekIndex()
+
+	// Initialize the 16kHz mono transcription copy
+	if r.config.TranscriptionOutput {
+		err = r.openOutput(&r.transcriptionOutput, WAVHeader{
+			SampleRate:    TranscriptionSampleRate,
+			Channels:      1,
+			BitsPerSample: r.transcriptionBits(),
+			Float:         r.config.FloatWAV,
+			Tags:          r.wavTags(),
+		})
+		if err != nil {
+			r.abortStart(&r.output, &r.transcriptionOutput)
+			return fmt.Errorf("initializing transcription WAV file: %w", err)
+		}
+	}
+
+	// Log the recording's events next to its audio
+	if r.config.EventLog {
+		openLog := NewEventLog
+		if r.config.ResumePath != "" {
+			openLog = AppendEventLog
+		}
+		eventLog, err := openLog(r.outputBase+".log", r.startTime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating event log:", err)
+		} else {
+			r.eventLog = eventLog
+			r.events.Subscribe(eventLog.Handle)
+		}
+	}
+	r.publish(EventStart, r.output.filePath)
+	if r.config.SpeechEvents {
+		r.speechDetector = NewSpeechDetector(r.config.SampleRate, r.mixedOutput.Channels(),
+			r.config.SpeechThreshold, r.config.SpeechMinSilence)
+	}
+	r.writeStartTone()
+
+	// Accept samples and start the writer goroutine
+	r.recordingActive.Store(true)
+	r.writingActive = true
+	r.writerWaitGroup.Add(1)
+	go r.audioWriterRoutine()
+
+	// Start the timer for regular saving. Timers are created here rather than in
+	// their goroutines so they count from the recording start on any clock.
+	go r.saveTimerRoutine(r.config.Clock.NewTimer(r.GetChunkDuration()))
+
+	// Start watching for sustained silence
+	if r.config.StopAfterSilenceSeconds > 0 {
+		go r.silenceMonitorRoutine(r.config.Clock.NewTicker(time.Second))
+	}
+
+	if r.config.Sink != nil {
+		fmt.Fprintln(os.Stderr, "Recording to the configured sink")
+	} else {
+		fmt.Fprintln(os.Stderr, "Recording to file:", r.currentWritePath())
+	}
+	return nil
+}
+
+// abortStart undoes a StartRecording that failed after opening outputs: it removes
+// the files and seek index it created, keeping resumed files with their earlier
+// audio, and leaves the recorder unstarted
+func (r *Recorder) abortStart(outputs ...*wavWriter) {
+	r.closeSeekIndex()
+	if !r.output.resumed {
+		r.removeSeekIndex()
+	}
+	for _, output := range outputs {
+		if output.resumed || output.sink != nil {
+			continue
+		}
+		if info, err := os.Stat(output.writePath()); err != nil || !info.Mode().IsRegular() {
+			continue // Never created, or not ours to remove
+		}
+		if err := os.Remove(output.writePath()); err != nil {
+			fmt.Fprintln(os.Stderr, "Error removing unused recording:", err)
+		}
+	}
+	if r.config.SessionFolders {
+		os.Remove(r.SessionFolder()) // Only if it is left empty
+	}
+	r.startTime = time.Time{}
+}
+
+// writeStartTone writes the start tone into the freshly opened output files, ahead of
+// any captured audio. It goes straight to the writers rather than through the mix, so
+// it is never lost to the mix buffer's limit however long the first save waits. It is
+// timed to end as the recording starts, so the captured audio keeps its place on the
+// file's timeline (see ByteOffsetAt). The marker is only added once the tone is in.
+func (r *Recorder) writeStartTone() {
+	if !r.config.StartTone {
+		return
+	}
+
+	sampleRate, channels := r.config.SampleRate, r.mixedOutput.Channels()
+	tone := ToneBurst(r.config.StartToneFrequency, startToneAmplitude, r.config.StartToneDuration,
+		sampleRate, channels)
+	if err := r.output.append(tone); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing start tone:", err)
+		return
+	}
+	if r.config.TranscriptionOutput {
+		if err := r.transcriptionOutput.append(r.transcriptionSamples(tone, channels)); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing start tone to transcription WAV file:", err)
+		}
+	}
+
+	start := r.startTime.Add(-r.config.StartToneDuration)
+	r.firstSampleTime = start
+	r.recordWrite(int64(len(tone)), 0)
+	r.updateSeekIndex()
+
+	marker := Marker{Label: "start tone", Time: start, Offset: -r.config.StartToneDuration}
+	r.markerMutex.Lock()
+	r.markers = append(r.markers, marker)
+	r.markerMutex.Unlock()
+	r.publish(EventMarker, marker.Label)
+}
+
+// startToneSamples returns how many samples of the output the start tone takes
+func (r *Recorder) startToneSamples() int64 {
+	if !r.config.StartTone {
+		return 0
+	}
+	frames := int64(r.config.StartToneDuration) * int64(r.config.SampleRate) / int64(time.Second)
+	return frames * int64(r.mixedOutput.Channels())
+}
+
+// StopRecording stops the recording and finalizes the file.
+// Stop the capturers first so their last callbacks have landed in the buffers.
+// It is safe to call more than once and from several goroutines, e.g. racing the
+// silence stop: the first call shuts down, the others wait for it to finish, and
+// every call returns the same error. Calling it before StartRecording does nothing.
+func (r *Recorder) StopRecording() error {
+	if r.GetStartTime().IsZero() {
+		return nil
+	}
+	r.stopOnce.Do(func() {
+		r.stopErr = r.shutdown()
+	})
+	return r.stopErr
+}
+
+// shutdown flushes the buffered audio and finalizes the output files. Errors are
+// reported as they happen and returned together.
+func (r *Recorder) shutdown() error {
+	var errs []error
+
+	// Signal that recording is stopping; no new samples are accepted after this. Taking
+	// the input lock waits for handlers that already passed their check to finish
+	// adding, so the drain below sees every accepted sample.
+	r.inputMutex.Lock()
+	r.recordingActive.Store(false)
+	r.inputMutex.Unlock()
+	r.startMutex.Lock()
+	r.stopTime = r.config.Clock.Now()
+	r.startMutex.Unlock()
+
+	// Wake the save timer and silence monitor so they exit without finishing their wait
+	close(r.stopTimers)
+
+	// Signal writer to flush everything still buffered, then wait for it to complete
+	r.stopSignal <- true
+	r.writerWaitGroup.Wait()
+
+	// Make sure the final flush is on disk even if the sync interval had not passed
+	for _, output := range []*wavWriter{&r.output, &r.transcriptionOutput} {
+		if err := output.finalize(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error finalizing WAV header:", err)
+			errs = append(errs, err)
+		}
+		if err := output.sync(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error syncing WAV file:", err)
+			errs = append(errs, err)
+		}
+	}
+
+	// Index the audio finalize wrote from a held-back block
+	r.updateSeekIndex()
+	r.closeSeekIndex()
+	if r.speechDetector != nil {
+		r.publishSpeech(r.speechDetector.Flush())
+	}
+
+	// Don't leave header-only files behind when nothing was captured
+	empty := r.IsEmptyRecording()
+	if !empty {
+		r.checkSampleCount()
+	}
+	if n := r.NonFiniteSamples(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: replaced %d invalid (NaN or infinite) captured samples with silence\n", n)
+	}
+	if empty {
+		r.discardEmptyOutput()
+		r.publish(EventStop, "no audio captured")
+	} else if r.partIndex > 1 && r.output.fileSize == int64(r.output.headerSize) {
+		// The part opened at the last rotation never received audio
+		os.Remove(r.output.writePath())
+		r.removeSeekIndex()
+		errs = append(errs, r.completeOutput(&r.transcriptionOutput))
+		fmt.Fprintln(os.Stderr, "Recording stopped and saved in parts to:", r.SessionFolder())
+		r.publish(EventStop, partPath(r.outputBase, r.partIndex-1))
+	} else {
+		errs = append(errs, r.completeOutput(&r.output), r.completeOutput(&r.transcriptionOutput))
+		if r.config.Sink != nil {
+			fmt.Fprintln(os.Stderr, "Recording stopped and saved to the configured sink")
+		} else {
+			fmt.Fprintln(os.Stderr, "Recording stopped and saved to:", r.output.filePath)
+		}
+		r.fileComplete(r.output.filePath)
+		r.publish(EventStop, r.output.filePath)
+	}
+	if !empty {
+		r.writeManifest()
+	}
+
+	if r.Panicked() {
+		errs = append(errs, errors.New("recording stopped after a panic"))
+	}
+
+	if r.eventLog != nil {
+		if err := r.eventLog.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error closing event log:", err)
+		}
+	}
+
+	close(r.done)
+	return errors.Join(errs...)
+}
+
+// sampleCountTolerance is how far, as a fraction of the recording's length, the written
+// audio may fall short of or exceed the wall-clock duration before StopRecording warns
+const sampleCountTolerance = 0.02
+
+// checkSampleCount warns when the audio written does not match how long the recording
+// ran, which means samples were dropped (or duplicated) somewhere along the way.
+// Half a second of slack covers capture latency at the start and end.
+func (r *Recorder) checkSampleCount() {
+	samplesPerSecond := float64(r.config.SampleRate * r.mixedOutput.Channels())
+	expected := r.GetRecordingDuration().Seconds() * samplesPerSecond
+	written := float64(r.SamplesWritten() - r.startToneSamples())
+	allowed := expected*sampleCountTolerance + samplesPerSecond/2
+
+	if math.Abs(written-expected) > allowed {
+		fmt.Fprintf(os.Stderr, "Warning: wrote %.1f seconds of audio in %.1f seconds of recording, audio may have been dropped\n",
+			written/samplesPerSecond, expected/samplesPerSecond)
+	}
+}
+
+// SamplesWritten returns how many samples, counting every channel, the recording has
+// written to its output files so far, across all parts
+func (r *Recorder) SamplesWritten() int64 {
+	return r.samplesWritten.Load()
+}
+
+// NonFiniteSamples returns how many captured samples were NaN or infinite and were
+// recorded as silence instead
+func (r *Recorder) NonFiniteSamples() int64 {
+	return r.nonFiniteSamples.Load()
+}
+
+// minRecordingDuration is the least audio a recording needs to not count as empty
+const minRecordingDuration = 100 * time.Millisecond
+
+// IsEmptyRecording returns whether the recording holds less than minRecordingDuration of
+// captured audio; the start tone doesn't count
+func (r *Recorder) IsEmptyRecording() bool {
+	bytesPerSecond := int64(r.config.SampleRate * r.mixedOutput.Channels() * r.config.OutputBits() / 8)
+	minBytes := bytesPerSecond * int64(minRecordingDuration) / int64(time.Second)
+
+	toneBytes := r.startToneSamples() * int64(r.config.OutputBits()/8)
+
+	return r.completedBytes+r.output.fileSize-int64(r.output.headerSize)-toneBytes < minBytes
+}
+
+// discardEmptyOutput removes the output files of an empty recording unless KeepEmpty is
+// set. A resumed file is always kept; it holds the earlier recording.
+func (r *Recorder) discardEmptyOutput() {
+	if r.config.KeepEmpty || r.config.ResumePath != "" {
+		r.completeOutput(&r.output)
+		r.completeOutput(&r.transcriptionOutput)
+		fmt.Fprintln(os.Stderr, "Warning: no audio captured, keeping empty file:", r.output.filePath)
+		return
+	}
+
+	for _, output := range []*wavWriter{&r.output, &r.transcriptionOutput} {
+		if output.filePath == "" {
+			continue
+		}
+		if err := os.Remove(output.writePath()); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, "Error removing empty recording:", err)
+		}
+	}
+	r.removeSeekIndex()
+	if r.config.Sink != nil {
+		fmt.Fprintln(os.Stderr, "No audio captured; the sink holds only a header")
+	} else {
+		fmt.Fprintln(os.Stderr, "No audio captured, removed empty file:", r.output.filePath)
+	}
+
+	// Only succeeds when nothing else, such as an event log, was written to the folder
+	if r.config.SessionFolders {
+		os.Remove(r.SessionFolder())
+	}
+}
+
+// recoverPanic is deferred by the sample handlers and the recorder's timers. A panic
+// is recorded and stops the recording the normal way, so the writer flushes what was
+// buffered and finalizes the files itself; nothing else touches them while it writes.
+// It doesn't panic again: the sample handlers run on the audio driver's threads, and
+// unwinding a panic into its C code crashes the process without a trace.
+func (r *Recorder) recoverPanic(where string) {
+	if value := recover(); value != nil {
+		r.recordPanic(where, value)
+		go r.StopRecording()
+	}
+}
+
+// recoverWriter is recoverPanic for the writer, which owns the output files and so
+// finalizes them itself, keeping the audio written so far playable, before it exits
+func (r *Recorder) recoverWriter() {
+	value := recover()
+	if value == nil {
+		return
+	}
+	r.recordPanic("writer", value)
+	for _, output := range []*wavWriter{&r.output, &r.transcriptionOutput} {
+		if err := output.finalize(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error finalizing WAV file after panic:", err)
+		}
+	}
+	go r.StopRecording()
+}
+
+// recordPanic logs the first panic with its stack, flags it and publishes it. Later
+// ones, e.g. from callbacks still running into the same bug, are dropped.
+func (r *Recorder) recordPanic(where string, value any) {
+	if !r.panicked.CompareAndSwap(false, true) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nPanic in recorder %s: %v\n%s", where, value, debug.Stack())
+	r.publish(EventError, fmt.Sprintf("panic in %s: %v", where, value))
+}
+
+// Panicked returns whether a panic stopped the recording
+func (r *Recorder) Panicked() bool {
+	return r.panicked.Load()
+}
+
+// Done returns a channel that is closed once the recording has stopped and been saved,
+// including when it stops on its own after sustained silence
+func (r *Recorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// audioWriterRoutine handles writing audio data in a separate thread
+func (r *Recorder) audioWriterRoutine() {
+	defer r.writerWaitGroup.Done()
+	defer r.recoverWriter()
+
+	// Mix between saves for taps that want the audio sooner than the file does
+	var mixTicks <-chan time.Time
+	if r.config.MixInterval > 0 {
+		ticker := r.config.Clock.NewTicker(r.config.MixInterval)
+		defer ticker.Stop()
+		mixTicks = ticker.C()
+	}
+
+	for r.writingActive {
+		select {
+		case <-r.writeSignal:
+			r.flushPendingAudio(false)
+
+		case <-mixTicks:
+			r.mixPendingAudio(false)
+
+		case reply := <-r.rotateRequests:
+			// Write everything captured so far to the old file before switching
+			r.flushPendingAudio(false)
+			if r.output.hasAudio() {
+				r.rotateOutput()
+			}
+			close(reply)
+
+		case <-r.stopSignal:
+			// Drain whatever the capture callbacks delivered before they stopped
+			r.drainPendingAudio()
+			r.writingActive = false
+			return
+		}
+	}
+}
+
+// drainPendingAudio is the final flush on stop: it writes until every microphone and
+// speaker buffer is empty. Stop has waited out the sample handlers by then, so the
+// buffers hold every accepted sample. The mix covers the longer of the streams, so a
+// tail that outlasts the others is written unmixed rather than dropped.
+func (r *Recorder) drainPendingAudio() {
+	r.flushPendingAudio(true)
+	for !r.inputBuffersEmpty() {
+		r.flushPendingAudio(true)
+	}
+}
+
+// inputBuffersEmpty returns whether no microphone or speaker samples wait to be mixed
+func (r *Recorder) inputBuffersEmpty() bool {
+	for _, buffer := range r.micBuffers {
+		if !buffer.IsEmpty() {
+			return false
+		}
+	}
+	return !r.speakerEnabled.Load() || r.speakerBuffer.IsEmpty()
+}
+
+// flushPendingAudio mixes the buffered input and appends it to the WAV file, along
+// with the audio mixed since the last save. Unless final is set, audio that only some
+// sources have delivered so far waits for the next save (see mixPendingAudio).
+func (r *Recorder) flushPendingAudio(final bool) {
+	r.mixPendingAudio(final)
+	samples, timestamp := r.heldMix, r.heldMixTime
+	r.heldMix, r.heldMixTime = nil, time.Time{}
+	sampleRate, channels := r.mixedOutput.SampleRate(), r.mixedOutput.Channels()
+
+	// Only write if we have samples
+	if len(samples) > 0 {
+		// Remember the wall-clock time of the first sample in the file
+		if r.firstSampleTime.IsZero() {
+			r.firstSampleTime = timestamp
+		}
+
+		clips := r.countClips(samples)
+		if r.speechDetector != nil {
+			r.publishSpeech(r.speechDetector.Feed(samples, timestamp))
+		}
+
+		err := r.output.append(samples)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing to WAV file:", err)
+			r.recordWrite(0, clips)
+		} else {
+			r.recordWrite(int64(len(samples)), clips)
+			r.updateSeekIndex()
+		}
+		if err == nil && r.debugMode {
+			seconds := float64(len(samples)) / float64(sampleRate*channels)
+			fmt.Fprintf(os.Stderr, "Appended %.2f seconds of audio (total: %.2f MB)\n",
+				seconds, float64(r.output.fileSize)/(1024*1024))
+		}
+
+		// Feed the transcription copy from the same samples
+		if r.config.TranscriptionOutput {
+			if err := r.transcriptionOutput.append(r.transcriptionSamples(samples, channels)); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing to transcription WAV file:", err)
+			}
+		}
+
+		// Move on to the next part once this one is long enough
+		if r.config.PartDurationSeconds > 0 && r.outputDuration() >= time.Duration(r.config.PartDurationSeconds)*time.Second {
+			r.rotateOutput()
+		}
+	}
+}
+
+// countClips returns how many samples reach full scale and reports them as a clip event
+func (r *Recorder) countClips(samples []float32) int64 {
+	clips := int64(0)
+	for _, value := range samples {
+		if value >= 1 || value <= -1 {
+			clips++
+		}
+	}
+
+	if clips > 0 {
+		r.publish(EventClip, fmt.Sprintf("samples at full scale: %d", clips))
+	}
+	return clips
+}
+
+// recordWrite adds written samples and clips to the counters and copies the current
+// output file's path, size and layout, all under one lock so Stats and ByteOffsetAt
+// see them change together
+func (r *Recorder) recordWrite(samples, clips int64) {
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	r.samplesWritten.Add(samples)
+	r.clipCount += clips
+	r.outputPath = r.output.filePath
+	r.outputPart = r.partIndex
+	r.outputBytes = r.output.fileSize
+	r.outputDataStart = int64(r.output.headerSize) + r.resumedBytes
+	r.outputStart = r.firstSampleTime
+}
+
+// transcriptionSamples converts mixed output to the 16 kHz mono of the transcription
+// copy. The resampler runs across writes, so chunk edges don't click or drift.
+func (r *Recorder) transcriptionSamples(samples []float32, channels int) []float32 {
+	return r.transcriptionRate.Process(r.downmixOutput(samples, channels))
+}
+
+// downmixOutput collapses mixed output to mono for the transcription copy. The downmix
+// weights apply to input channels, so a stereo-split or multitrack file of microphone
+// and speaker is averaged.
+func (r *Recorder) downmixOutput(samples []float32, channels int) []float32 {
+	splitSources := (r.config.MixMode == MixStereoSplit || r.config.MixMode == MixMultitrack) && r.speakerEnabled.Load()
+	if splitSources || len(r.config.DownmixWeights) != channels {
+		return DownmixToMono(samples, channels)
+	}
+	return DownmixWeighted(samples, r.config.DownmixWeights)
+}
+
+// outputHeader returns the WAV header for an output file starting at the given time
+func (r *Recorder) outputHeader(start time.Time) WAVHeader {
+	header := WAVHeader{
+		SampleRate:    r.config.SampleRate,
+		Channels:      r.mixedOutput.Channels(),
+		BitsPerSample: r.config.OutputBits(),
+		Float:         r.config.FloatWAV,
+		Tags:          r.wavTags(),
+	}
+	if r.config.BroadcastWave {
+		header.Bext = NewBextChunk(start, r.config.SampleRate, r.config.RecordingName)
+	}
+	return header
+}
+
+// wavTags returns the tags to store in each output file, nil unless TagsInWAV is set
+func (r *Recorder) wavTags() map[string]string {
+	if !r.config.TagsInWAV {
+		return nil
+	}
+	return r.config.Tags
+}
+
+// transcriptionBits returns the depth of the transcription copy: 16-bit PCM, or
+// float like the recording with FloatWAV
+func (r *Recorder) transcriptionBits() int {
+	if r.config.FloatWAV {
+		return 32
+	}
+	return 16
+}
+
+// outputDuration returns how much audio the current output file holds
+func (r *Recorder) outputDuration() time.Duration {
+	bytesPerSecond := int64(r.config.SampleRate * r.mixedOutput.Channels() * r.config.OutputBits() / 8)
+	dataBytes := r.output.fileSize - int64(r.output.headerSize)
+	return time.Duration(dataBytes * int64(time.Second) / bytesPerSecond)
+}
+
+// rotateOutput completes the current part and continues the recording in the next one
+func (r *Recorder) rotateOutput() {
+	if err := r.output.finalize(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error finalizing WAV header:", err)
+	}
+	r.updateSeekIndex()
+
+	completed := r.output
+	if err := completed.sync(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error syncing WAV file:", err)
+	}
+	r.completedBytes += completed.fileSize - int64(completed.headerSize)
+	r.completeOutput(&completed)
+	r.closeSeekIndex()
+
+	r.partIndex++
+	r.output = newOutputWriter(partPath(r.outputBase, r.partIndex), r.config)
+	r.firstSampleTime = time.Time{}
+	if err := r.output.create(r.outputHeader(r.config.Clock.Now())); err != nil {
+		fmt.Fprintln(os.Stderr, "Error initializing WAV file:", err)
+	}
+	r.recordWrite(0, 0)
+	r.openSeekIndex()
+
+	r.fileComplete(completed.filePath)
+	r.publish(EventRotate, r.output.filePath)
+}
+
+// Rotate finishes the current output file and continues the recording in a new part
+// without losing samples, like logrotate for audio. Everything captured up to the call
+// is flushed to the old file, which is then reported to the file-complete handler.
+// A recording without PartDurationSeconds keeps its first file name and numbers the
+// following parts from 002. Rotate returns once the new file is in place; it does
+// nothing if the current file holds no audio yet.
+func (r *Recorder) Rotate() error {
+	if !r.recordingActive.Load() {
+		return fmt.Errorf("not recording")
+	}
+	if r.config.Sink != nil {
+		return fmt.Errorf("a recording written to a sink can't be split into parts")
+	}
+
+	reply := make(chan struct{})
+	select {
+	case r.rotateRequests <- reply:
+	case <-r.done:
+		return fmt.Errorf("recording stopped")
+	}
+	// A writer that panics mid-rotation never replies, but stops the recording
+	select {
+	case <-reply:
+	case <-r.done:
+		return fmt.Errorf("recording stopped")
+	}
+
+	return nil
+}
+
+// openOutput creates an output file, or continues it when resuming a recording that
+// already wrote it
+func (r *Recorder) openOutput(output *wavWriter, header WAVHeader) error {
+	if r.config.ResumePath != "" {
+		if _, err := os.Stat(output.writePath()); err == nil {
+			output.resumed = true
+			return output.resume(header)
+		}
+	}
+	return output.create(header)
 }
 
-// Recorder manages the continuous recording process
-type Recorder struct {
-	config                RecordingConfig
-	outputFilePath        string
-	micBuffer             *Buffer
-	speakerBuffer         *Buffer
-	mixedBuffer           *Buffer
-	currentFileSize       int64
-	recordingActive       bool
-	writingActive         bool
-	writerWaitGroup       sync.WaitGroup
-	startTime             time.Time
-	currentChunkStartTime time.Time
-	writeSignal           chan bool
-	stopSignal            chan bool
-	debugMode             bool
+// newOutputWriter creates a writer for one of the recording's files with its sync and header settings
+func newOutputWriter(path string, config RecordingConfig) wavWriter {
+	return wavWriter{
+		filePath:       path,
+		partial:        config.PartialFiles && path != "",
+		fsyncInterval:  config.FsyncInterval,
+		headerInterval: config.HeaderUpdateInterval,
+		minBlock:       config.MinWriteBlock,
+	}
 }
 
-// NewRecorder creates a new continuous recorder
-func NewRecorder(config RecordingConfig) *Recorder {
-	// Create output directory if it doesn't exist
-	os.MkdirAll(config.OutputFolder, 0755)
+// partPath returns the file name of one numbered part of a recording
+func partPath(base string, index int) string {
+	return fmt.Sprintf("%s_part%03d.wav", base, index)
+}
 
-	// Generate a single output filename
-	timestamp := time.Now().Format("2006_01_02_15_04_05")
-	filename := fmt.Sprintf("%s_%s.wav", config.RecordingName, timestamp)
-	filePath := filepath.Join(config.OutputFolder, filename)
+// openSeekIndex starts the seek index of the current output file, if enabled
+func (r *Recorder) openSeekIndex() {
+	if r.config.SeekIndexInterval <= 0 {
+		return
+	}
+	index, err := newSeekIndex(WAVIndexPath(r.output.filePath), r.config.SeekIndexInterval)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating seek index:", err)
+		return
+	}
+	r.seekIndex = index
+}
 
-	return &Recorder{
-		config:          config,
-		outputFilePath:  filePath,
-		micBuffer:       NewBuffer(config.SampleRate, config.Channels),
-		speakerBuffer:   NewBuffer(config.SampleRate, config.Channels),
-		mixedBuffer:     NewBuffer(config.SampleRate, config.Channels),
-		recordingActive: false,
-		writingActive:   false,
-		writeSignal:     make(chan bool, 1),
-		stopSignal:      make(chan bool, 1),
-		debugMode:       false,
+// updateSeekIndex adds the entries for audio just appended to the current output file
+func (r *Recorder) updateSeekIndex() {
+	if r.seekIndex == nil {
+		return
+	}
+	if err := r.seekIndex.update(&r.output, r.config.SampleRate); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing seek index:", err)
 	}
 }
 
-// SetDebugMode enables or disables debug outputs
-func (r *Recorder) SetDebugMode(enabled bool) {
-	r.debugMode = enabled
+// closeSeekIndex finishes the seek index of the current output file
+func (r *Recorder) closeSeekIndex() {
+	if r.seekIndex == nil {
+		return
+	}
+	if err := r.seekIndex.close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error closing seek index:", err)
+	}
+	r.seekIndex = nil
 }
 
-// StartRecording begins the continuous recording process
-func (r *Recorder) StartRecording() {
-	r.recordingActive = true
-	r.writingActive = true
-	r.startTime = time.Now()
-	r.currentChunkStartTime = time.Now()
+// removeSeekIndex deletes the seek index of a current output file that is discarded
+func (r *Recorder) removeSeekIndex() {
+	if r.config.SeekIndexInterval > 0 {
+		os.Remove(WAVIndexPath(r.output.filePath))
+	}
+}
 
-	// Initialize WAV file with header
-	err := InitializeWAVFile(r.outputFilePath, r.config.SampleRate, r.config.Channels)
+// completeOutput moves a finished output file from its partial name to its final one
+func (r *Recorder) completeOutput(output *wavWriter) error {
+	if output.filePath == "" {
+		return nil
+	}
+	err := output.complete()
 	if err != nil {
-		fmt.Println("Error initializing WAV file:", err)
+		fmt.Fprintln(os.Stderr, "Error renaming finished WAV file:", err)
+	}
+	return err
+}
+
+// fileComplete records a finished file on disk for the manifest and notifies the
+// file-complete handler, if any
+func (r *Recorder) fileComplete(path string) {
+	if path == "" {
 		return
 	}
+	r.completedFiles = append(r.completedFiles, path)
+	if r.onFileComplete != nil {
+		r.onFileComplete(path)
+	}
+}
 
-	// Get initial file size
-	info, err := os.Stat(r.outputFilePath)
-	if err == nil {
-		r.currentFileSize = info.Size()
+// OutputFiles returns the files the recording was saved to: each finished part or the
+// single output file, then the transcription copy. A recording to a sink lists only
+// its companion files. Call it after StopRecording; it returns nothing for a
+// recording that captured no audio.
+func (r *Recorder) OutputFiles() []string {
+	if r.IsRecording() || r.IsEmptyRecording() {
+		return nil
 	}
+	files := slices.Clone(r.completedFiles)
+	if r.transcriptionOutput.filePath != "" {
+		files = append(files, r.transcriptionOutput.filePath)
+	}
+	return files
+}
 
-	// Start the writer goroutine
-	r.writerWaitGroup.Add(1)
-	go r.audioWriterRoutine()
+// writeManifest writes the manifest of a finished recording, if enabled
+func (r *Recorder) writeManifest() {
+	if !r.config.Manifest && len(r.config.Tags) == 0 {
+		return
+	}
 
-	// Start the timer for regular saving
-	go r.saveTimerRoutine()
+	files := r.OutputFiles()
+	manifest := Manifest{
+		Name:          r.config.RecordingName,
+		Start:         r.GetStartTime(),
+		Duration:      r.GetRecordingDuration().Seconds(),
+		SampleRate:    r.config.SampleRate,
+		Channels:      r.mixedOutput.Channels(),
+		BitsPerSample: r.config.OutputBits(),
+		Float:         r.config.FloatWAV,
+		Files:         make([]string, len(files)),
+		Tags:          r.config.Tags,
+	}
+	// The files are listed relative to the manifest, which sits next to them
+	for i, file := range files {
+		manifest.Files[i] = filepath.Base(file)
+	}
 
-	fmt.Println("Recording to file:", r.outputFilePath)
+	if err := WriteManifest(r.outputBase+".json", manifest); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing manifest:", err)
+	}
 }
 
-// StopRecording stops the recording and finalizes the file
-func (r *Recorder) StopRecording() {
-	if !r.recordingActive {
-		return // Already stopped
-	}
+// Events returns the recording's event bus. Subscribe before StartRecording to see
+// every event; the bus also accepts events from outside, such as device problems.
+func (r *Recorder) Events() *EventBus {
+	return &r.events
+}
 
-	// Signal that recording is stopping
-	r.recordingActive = false
+// publish sends an event stamped with the recorder's clock
+func (r *Recorder) publish(eventType EventType, message string) {
+	r.events.Publish(Event{Type: eventType, Time: r.config.Clock.Now(), Message: message})
+}
 
-	// Trigger one final write
-	r.writeSignal <- true
+// publishSpeech publishes speech edges at the time they were captured
+func (r *Recorder) publishSpeech(edges []SpeechEdge) {
+	for _, edge := range edges {
+		message := "speech ended"
+		if edge.Speech {
+			message = "speech started"
+		}
+		r.events.Publish(Event{Type: EventSpeech, Time: edge.Time, Message: message})
+	}
+}
 
-	// Wait briefly to ensure the write signal is processed
-	time.Sleep(100 * time.Millisecond)
+// SetFileCompleteHandler registers a function called with the path of each output file
+// once it is finished: every part as the recording moves on to the next, and the last
+// file when recording stops. The file's header is final when the handler runs.
+// Call it before StartRecording.
+func (r *Recorder) SetFileCompleteHandler(handler func(path string)) {
+	r.onFileComplete = handler
+}
 
-	// Signal writer to stop and wait for it to complete
-	r.stopSignal <- true
-	r.writerWaitGroup.Wait()
+// mixPendingAudio mixes the buffered input into the mixed output, where taps read it,
+// and takes the file's share of the mix, holding it until the next save writes it.
+// Holding it here rather than leaving it in the mixed output keeps a long interval
+// between saves from running past the mixed output's capacity.
+//
+// Only the audio that every source has delivered is mixed (see mixHorizon); the rest
+// waits in the source buffers for the next mix. Mixing a source's audio before the
+// others' has arrived would place the late audio after it instead of alongside it.
+// A final mix takes everything, as nothing more is coming.
+func (r *Recorder) mixPendingAudio(final bool) {
+	r.processPendingAudio(final)
 
-	fmt.Println("Recording stopped and saved to:", r.outputFilePath)
+	samples, timestamp := r.fileReader.Read()
+	if len(samples) == 0 {
+		return
+	}
+	if len(r.heldMix) == 0 {
+		r.heldMixTime = timestamp
+	}
+	r.heldMix = append(r.heldMix, samples...)
 }
 
-// audioWriterRoutine handles writing audio data in a separate thread
-func (r *Recorder) audioWriterRoutine() {
-	defer r.writerWaitGroup.Done()
+// mixMaxWait is how far one source may lag the source furthest ahead before the mix
+// stops waiting for it, e.g. a loopback device that delivers nothing while nothing
+// plays, or a device that was lost
+const mixMaxWait = 500 * time.Millisecond
 
-	for r.writingActive {
-		select {
-		case <-r.writeSignal:
-			// Process any pending microphone and speaker data into mixed buffer
-			r.processPendingAudio()
-
-			// Get mixed samples from buffer
-			samples, _, sampleRate, channels := r.mixedBuffer.Get()
-
-			// Only write if we have samples
-			if len(samples) > 0 {
-				err := r.appendToWAVFile(samples, sampleRate, channels)
-				if err != nil {
-					fmt.Println("Error writing to WAV file:", err)
-				} else if r.debugMode {
-					seconds := float64(len(samples)) / float64(sampleRate*channels)
-					fmt.Printf("Appended %.2f seconds of audio (total: %.2f MB)\n",
-						seconds, float64(r.currentFileSize)/(1024*1024))
-				}
-			}
+// mixHorizon returns the capture time up to which every source has delivered its
+// audio, not counting sources that lag by more than mixMaxWait. A source that hasn't
+// delivered anything yet counts as having delivered up to the start of the recording,
+// so its first audio is waited for too. The horizon is zero when no source has
+// delivered anything.
+func (r *Recorder) mixHorizon() time.Time {
+	ends := make([]time.Time, 0, len(r.micBuffers)+1)
+	for _, buffer := range r.micBuffers {
+		ends = append(ends, buffer.End())
+	}
+	if r.speakerEnabled.Load() {
+		ends = append(ends, r.speakerBuffer.End())
+	}
+	for i, end := range ends {
+		if end.IsZero() {
+			ends[i] = r.startTime
+		}
+	}
 
-		case <-r.stopSignal:
-			// Final write handled before this is triggered
-			r.writingActive = false
-			return
+	var latest time.Time
+	for _, end := range ends {
+		if end.After(latest) {
+			latest = end
+		}
+	}
+	horizon := latest
+	for _, end := range ends {
+		if !end.IsZero() && end.Before(horizon) && latest.Sub(end) <= mixMaxWait {
+			horizon = end
 		}
 	}
+	return horizon
 }
 
-// processPendingAudio processes and mixes microphone and speaker data
-func (r *Recorder) processPendingAudio() {
-	// Get microphone samples
-	micSamples, micTimestamp, _, _ := r.micBuffer.Get()
+// takePending takes a source's audio up to horizon, or all of it when final is set
+func takePending(buffer *Buffer, horizon time.Time, final bool) ([]float32, time.Time) {
+	if final {
+		samples, timestamp, _, _ := buffer.Get()
+		return samples, timestamp
+	}
+	return buffer.GetUntil(horizon)
+}
+
+// processPendingAudio processes and mixes microphone and speaker data, up to the
+// mix horizon unless final is set
+func (r *Recorder) processPendingAudio(final bool) {
+	var horizon time.Time
+	if !final {
+		horizon = r.mixHorizon()
+	}
 
-	// Get speaker samples
-	speakerSamples, speakerTimestamp, _, _ := r.speakerBuffer.Get()
+	// Get microphone samples, combining all microphones into one stream
+	micSamples, micTimestamp := r.collectMicAudio(horizon, final)
+
+	// Get speaker samples, if there is a speaker stream at all
+	var speakerSamples []float32
+	var speakerTimestamp time.Time
+	if r.speakerEnabled.Load() {
+		speakerSamples, speakerTimestamp = takePending(r.speakerBuffer, horizon, final)
+	}
 
 	// Mix the samples with proper time synchronization
-	mixedSamples, mixedTimestamp := TimeSyncMixAudioSamples(
-		micSamples, micTimestamp,
-		speakerSamples, speakerTimestamp,
-		r.config.SampleRate, r.config.Channels)
+	mixedSamples, mixedTimestamp := r.mixStreams(micSamples, micTimestamp, speakerSamples, speakerTimestamp)
 
-	// Add to mixed buffer using the correctly synchronized timestamp
+	// Publish to the mixed output using the correctly synchronized timestamp
 	if len(mixedSamples) > 0 {
-		r.mixedBuffer.Add(mixedSamples, mixedTimestamp)
+		r.mixedOutput.Write(mixedSamples, mixedTimestamp)
 	}
 
 	if r.debugMode {
@@ -175,28 +1565,158 @@ func (r *Recorder) processPendingAudio() {
 			var diff int64
 			if micTimestamp.Before(speakerTimestamp) {
 				diff = speakerTimestamp.Sub(micTimestamp).Milliseconds()
-				fmt.Printf("\nSync info: Speaker is %dms behind mic\n", diff)
+				fmt.Fprintf(os.Stderr, "\nSync info: Speaker is %dms behind mic\n", diff)
 			} else {
 				diff = micTimestamp.Sub(speakerTimestamp).Milliseconds()
-				fmt.Printf("\nSync info: Mic is %dms behind speaker\n", diff)
+				fmt.Fprintf(os.Stderr, "\nSync info: Mic is %dms behind speaker\n", diff)
 			}
 		}
 	}
 }
 
-// saveTimerRoutine triggers periodic saves
-func (r *Recorder) saveTimerRoutine() {
-	for r.recordingActive {
-		// Sleep for the chunk duration
-		time.Sleep(time.Duration(r.config.ChunkDurationSeconds) * time.Second)
+// collectMicAudio takes the pending samples of every microphone up to horizon, or
+// all of them when final is set, and sums them with their gains on a shared timeline
+func (r *Recorder) collectMicAudio(horizon time.Time, final bool) ([]float32, time.Time) {
+	if len(r.micBuffers) == 1 && r.config.micGain(0) == 1 {
+		return takePending(r.micBuffers[0], horizon, final)
+	}
+
+	streams := make([]TimedStream, len(r.micBuffers))
+	for i, buffer := range r.micBuffers {
+		samples, timestamp := takePending(buffer, horizon, final)
+		streams[i] = TimedStream{Samples: samples, Timestamp: timestamp, Gain: r.config.micGain(i)}
+	}
+
+	if r.config.HighPrecisionMix {
+		return TimeSyncMixNHighPrecision(streams, r.config.SampleRate, r.config.Channels)
+	}
+	return TimeSyncMixN(streams, r.config.SampleRate, r.config.Channels)
+}
+
+// mixStreams combines microphone and speaker samples using the configured mix mode
+// and brings the result to the configured level
+func (r *Recorder) mixStreams(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time) ([]float32, time.Time) {
+	mixed, timestamp := r.mixSources(micSamples, micTimestamp, speakerSamples, speakerTimestamp)
+	if ceiling := r.config.MixCeiling(); ceiling < 1 {
+		mixed = LevelToCeiling(mixed, ceiling)
+	}
+	return mixed, timestamp
+}
+
+// mixSources combines microphone and speaker samples using the configured mix mode
+func (r *Recorder) mixSources(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time) ([]float32, time.Time) {
+	sampleRate, channels := r.config.SampleRate, r.config.Channels
+
+	// Without a speaker stream there is nothing to mix, unless the output layout
+	// was already fixed to stereo split, in which case the right channel is silent
+	if !r.speakerEnabled.Load() && r.mixedOutput.Channels() == channels {
+		return micSamples, micTimestamp
+	}
+
+	switch r.config.MixMode {
+	case MixWeighted:
+		return TimeSyncMixWeighted(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+			sampleRate, channels, r.config.MicWeight, r.config.SpeakerWeight)
+	case MixSumLimit:
+		return TimeSyncMixSumLimit(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+			sampleRate, channels)
+	case MixStereoSplit:
+		if len(r.config.ChannelMap) > 0 {
+			return TimeSyncChannelMap(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+				sampleRate, channels, r.config.DownmixWeights, r.config.ChannelMap)
+		}
+		return TimeSyncStereoSplitWeighted(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+			sampleRate, channels, r.config.DownmixWeights)
+	case MixDuck:
+		return TimeSyncMixDuck(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+			sampleRate, channels, r.config.DuckThreshold, r.config.DuckLevel)
+	case MixMultitrack:
+		return TimeSyncMultitrack(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+			sampleRate, channels)
+	default:
+		// Below full scale the level policy replaces the 50/50 overlap
+		if r.config.MixCeiling() < 1 {
+			return TimeSyncMixSumLimit(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+				sampleRate, channels)
+		}
+		return TimeSyncMixAudioSamples(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+			sampleRate, channels)
+	}
+}
+
+// silenceMonitorRoutine stops the recording once all inputs have been silent
+// for StopAfterSilenceSeconds. Brief pauses shorter than that do not trip it.
+func (r *Recorder) silenceMonitorRoutine(ticker Ticker) {
+	timeout := time.Duration(r.config.StopAfterSilenceSeconds) * time.Second
+	defer ticker.Stop()
+	defer r.recoverPanic("silence monitor")
+
+	for {
+		select {
+		case <-r.stopTimers:
+			return
+		case <-ticker.C():
+		}
+
+		r.levelMutex.Lock()
+		silentFor := r.config.Clock.Since(r.lastSoundTime)
+		r.levelMutex.Unlock()
+
+		if silentFor >= timeout {
+			fmt.Fprintf(os.Stderr, "\nNo sound for %d seconds, stopping recording\n", r.config.StopAfterSilenceSeconds)
+			r.publish(EventSilence, fmt.Sprintf("no sound for %d seconds", r.config.StopAfterSilenceSeconds))
+			r.StopRecording()
+			return
+		}
+	}
+}
+
+// trackLevel stores the overall and per-channel RMS levels of the latest samples from
+// one source and records when any input last rose above the silence threshold
+func (r *Recorder) trackLevel(level *float32, channelLevels *[]float32, samples []float32, timestamp time.Time) {
+	rms := RMSLevel(samples)
+	perChannel := PerChannelRMS(samples, r.config.Channels)
+
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	*level = rms
+	*channelLevels = perChannel
+	if r.config.StopAfterSilenceSeconds > 0 && rms >= r.config.SilenceThreshold {
+		r.lastSoundTime = timestamp
+	}
+}
+
+// saveTimerRoutine triggers periodic saves. The interval can be changed while
+// recording through SetChunkDuration, which reschedules the pending save.
+func (r *Recorder) saveTimerRoutine(timer Timer) {
+	defer timer.Stop()
+	defer r.recoverPanic("save timer")
 
-		// Skip if not recording anymore
-		if !r.recordingActive {
-			break
+	for {
+		select {
+		case <-r.stopTimers:
+			return
+		case <-timer.C():
+		case <-r.chunkReset:
+			// Reschedule the pending save relative to when the current chunk started
+			timer.Stop()
+			remaining := r.GetChunkDuration() - r.config.Clock.Since(r.GetCurrentChunkStartTime())
+			if remaining > 0 {
+				timer.Reset(remaining)
+				continue
+			}
+			// The new interval has already elapsed, save right away
 		}
 
-		// Reset chunk start time
-		r.currentChunkStartTime = time.Now()
+		// Reset chunk start time and schedule the next save
+		r.timerMutex.Lock()
+		r.currentChunkStartTime = r.config.Clock.Now()
+		interval := r.chunkDuration
+		r.timerMutex.Unlock()
+		timer.Reset(interval)
 
 		// Signal the writer to save data
 		select {
@@ -205,106 +1725,499 @@ func (r *Recorder) saveTimerRoutine() {
 		default:
 			// Channel is full, which means a write is already pending
 			if r.debugMode {
-				fmt.Println("Save signal dropped - writer busy")
+				fmt.Fprintln(os.Stderr, "Save signal dropped - writer busy")
 			}
 		}
 	}
 }
 
-// appendToWAVFile safely appends audio data to the WAV file
-func (r *Recorder) appendToWAVFile(samples []float32, sampleRate, channels int) error {
-	if len(samples) == 0 {
-		return nil
+// SetChunkDuration changes the interval between saves, taking effect immediately
+// even while recording. Values below one second are raised to one second. It never
+// blocks, so it is safe to call from several goroutines and after the recording stopped.
+func (r *Recorder) SetChunkDuration(seconds int) {
+	if seconds < 1 {
+		seconds = 1
 	}
+	interval := time.Duration(seconds) * time.Second
 
-	// Open file for appending
-	file, err := os.OpenFile(r.outputFilePath, os.O_RDWR, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	r.timerMutex.Lock()
+	r.chunkDuration = interval
+	r.timerMutex.Unlock()
 
-	// Seek to the end of the file (after header and existing data)
-	_, err = file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return err
+	// Wake the timer; a wake-up it has not picked up yet already reads the new duration
+	select {
+	case r.chunkReset <- struct{}{}:
+	default:
 	}
+}
 
-	// Write audio data
-	bytesWritten, err := WriteFloatSamples(file, samples)
-	if err != nil {
-		return err
-	}
+// GetChunkDuration returns the current interval between saves
+func (r *Recorder) GetChunkDuration() time.Duration {
+	r.timerMutex.Lock()
+	defer r.timerMutex.Unlock()
 
-	// Update file size
-	r.currentFileSize += int64(bytesWritten)
+	return r.chunkDuration
+}
 
-	// Update the WAV header with new size
-	dataSize := int(r.currentFileSize - 44) // 44 bytes is the WAV header size
-	err = UpdateWAVHeader(file, dataSize)
-	if err != nil {
-		return err
+// AddMicProcessor appends a processor to the chain of the first microphone.
+// Processors run in the order added, on the capture thread, before metering and buffering.
+func (r *Recorder) AddMicProcessor(p AudioProcessor) {
+	r.AddMicProcessorFor(0, p)
+}
+
+// AddMicProcessorFor appends a processor to the chain of one microphone.
+// Processors keep state between chunks, so each microphone needs its own instances.
+func (r *Recorder) AddMicProcessorFor(index int, p AudioProcessor) {
+	if index < 0 || index >= len(r.micProcessors) {
+		return
 	}
+	r.micProcessors[index].Add(p)
+}
 
-	return nil
+// AddSpeakerProcessor appends a processor to the speaker chain
+func (r *Recorder) AddSpeakerProcessor(p AudioProcessor) {
+	r.speakerProcessors.Add(p)
 }
 
-// AddMicSamples adds microphone samples to the recorder
+// AddMicSamples adds samples from the first microphone to the recorder
 func (r *Recorder) AddMicSamples(samples []float32, timestamp time.Time) {
-	if !r.recordingActive || len(samples) == 0 {
+	r.AddMicSamplesFrom(0, samples, timestamp)
+}
+
+// AddMicSamplesFrom adds samples from one of the microphones to the recorder.
+// Samples must already be at the recording's sample rate and channel count;
+// AddMicSamplesAtRate converts them from another rate.
+func (r *Recorder) AddMicSamplesFrom(index int, samples []float32, timestamp time.Time) {
+	r.addMicSamples(index, samples, r.config.SampleRate, timestamp)
+}
+
+// AddMicSamplesAtRate adds samples from one of the microphones that were captured at
+// sampleRate, converting them to the recording's rate first. Use it when a device
+// delivers at its own rate, so microphone and speaker are mixed at one rate instead
+// of playing back at the wrong speed. Each source keeps its own resampler, so the
+// callbacks of one device must be delivered in order.
+func (r *Recorder) AddMicSamplesAtRate(index int, samples []float32, sampleRate int, timestamp time.Time) {
+	r.addMicSamples(index, samples, sampleRate, timestamp)
+}
+
+// addMicSamples converts, processes and buffers one microphone's samples
+func (r *Recorder) addMicSamples(index int, samples []float32, sampleRate int, timestamp time.Time) {
+	r.inputMutex.RLock()
+	defer r.inputMutex.RUnlock()
+	if !r.recordingActive.Load() || len(samples) == 0 || index < 0 || index >= len(r.micBuffers) {
+		return
+	}
+	defer r.recoverPanic("microphone processing")
+
+	// Clean the samples before resampling, which would spread a non-finite one
+	r.sanitize(samples)
+	samples = r.micRates[index].convert(r, samples, sampleRate)
+	if len(samples) == 0 {
 		return
 	}
+	samples = r.micProcessors[index].Process(samples, r.config.SampleRate, r.config.Channels)
+	r.trackLevel(&r.micLevels[index], &r.micChannelLevels[index], samples, timestamp)
+	r.fillMicGap(index, len(samples)/r.config.Channels, timestamp)
 
 	// Add samples to the buffer
-	r.micBuffer.Add(samples, timestamp)
+	r.micBuffers[index].Add(samples, timestamp)
+}
+
+// AddSpeakerSamplesAtRate is AddMicSamplesAtRate for the speaker stream
+func (r *Recorder) AddSpeakerSamplesAtRate(samples []float32, sampleRate int, timestamp time.Time) {
+	r.addSpeakerSamples(samples, sampleRate, timestamp)
+}
+
+// rateConverter brings one source's samples to the recording rate. Every source has
+// its own, so the resampler's position carries over between that source's callbacks.
+type rateConverter struct {
+	source    string
+	rate      int              // Rate the source last delivered, 0 until it has
+	resampler *StreamResampler // nil while the source is at the recording rate
+	err       error            // Why the current rate can't be recorded
+	mutex     sync.Mutex
+}
+
+// convert resamples samples delivered at sampleRate to the recording rate. A change
+// of rate, e.g. after a device switch, is logged and published, and starts a new
+// resampler. Samples at an unsupported rate are dropped.
+func (c *rateConverter) convert(r *Recorder, samples []float32, sampleRate int) []float32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if sampleRate != c.rate {
+		if c.rate != 0 {
+			message := fmt.Sprintf("%s changed rate from %d Hz to %d Hz", c.source, c.rate, sampleRate)
+			fmt.Fprintf(os.Stderr, "\n%s\n", message)
+			r.publish(EventDevice, message)
+		}
+		c.rate = sampleRate
+		c.resampler = nil
+		c.err = nil
+		if sampleRate != r.config.SampleRate {
+			c.err = ValidateResampleRates(sampleRate, r.config.SampleRate)
+			if c.err != nil {
+				fmt.Fprintf(os.Stderr, "\nCannot record %s: %v\n", c.source, c.err)
+			} else {
+				c.resampler = NewStreamResampler(sampleRate, r.config.SampleRate, r.config.Channels, r.config.ResampleQuality)
+			}
+		}
+	}
+
+	if c.err != nil {
+		return nil
+	}
+	if c.resampler == nil {
+		return samples
+	}
+	return c.resampler.Process(samples)
+}
+
+// lastRate returns the rate the source last delivered, 0 if it hasn't
+func (c *rateConverter) lastRate() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.rate
+}
+
+// SourceRates returns the rate each microphone and then the speaker last delivered,
+// 0 for sources that haven't delivered yet
+func (r *Recorder) SourceRates() (mics []int, speaker int) {
+	mics = make([]int, len(r.micRates))
+	for i, converter := range r.micRates {
+		mics[i] = converter.lastRate()
+	}
+	return mics, r.speakerRate.lastRate()
+}
+
+// MarkMicGap notes that a microphone stopped delivering samples at the given time,
+// e.g. while its capture device is being switched. When its samples resume, the gap
+// is recorded as silence so the microphone stays in time with the other sources.
+func (r *Recorder) MarkMicGap(index int, at time.Time) {
+	r.micGapMutex.Lock()
+	defer r.micGapMutex.Unlock()
+
+	if index >= 0 && index < len(r.micGaps) {
+		r.micGaps[index] = at
+	}
+}
+
+// fillMicGap adds silence for a marked gap that ends with the first frames after it.
+// Capture timestamps are taken when a chunk arrives, so the chunk began its own
+// duration earlier.
+func (r *Recorder) fillMicGap(index, frames int, arrived time.Time) {
+	r.micGapMutex.Lock()
+	gapStart := r.micGaps[index]
+	r.micGaps[index] = time.Time{}
+	r.micGapMutex.Unlock()
+
+	if gapStart.IsZero() {
+		return
+	}
+	resumed := arrived.Add(-time.Duration(frames) * time.Second / time.Duration(r.config.SampleRate))
+	gapFrames := int(resumed.Sub(gapStart).Seconds() * float64(r.config.SampleRate))
+	if gapFrames <= 0 {
+		return
+	}
+	r.micBuffers[index].AddSilence(gapFrames, gapStart)
+}
+
+// sanitize silences non-finite captured samples before they reach the processors
+// and the mix, counting them
+func (r *Recorder) sanitize(samples []float32) {
+	if cleaned := SanitizeSamples(samples); cleaned > 0 {
+		r.nonFiniteSamples.Add(int64(cleaned))
+	}
 }
 
 // AddSpeakerSamples adds speaker samples to the recorder
 func (r *Recorder) AddSpeakerSamples(samples []float32, timestamp time.Time) {
-	if !r.recordingActive || len(samples) == 0 {
+	r.addSpeakerSamples(samples, r.config.SampleRate, timestamp)
+}
+
+// addSpeakerSamples converts, processes and buffers the speaker's samples
+func (r *Recorder) addSpeakerSamples(samples []float32, sampleRate int, timestamp time.Time) {
+	r.inputMutex.RLock()
+	defer r.inputMutex.RUnlock()
+	if !r.recordingActive.Load() || !r.speakerEnabled.Load() || len(samples) == 0 {
+		return
+	}
+	defer r.recoverPanic("speaker processing")
+
+	r.sanitize(samples)
+	samples = r.speakerRate.convert(r, samples, sampleRate)
+	if len(samples) == 0 {
 		return
 	}
+	samples = r.speakerProcessors.Process(samples, r.config.SampleRate, r.config.Channels)
+	r.trackLevel(&r.speakerLevel, &r.speakerChannelLevels, samples, timestamp)
 
 	// Add samples to the buffer
 	r.speakerBuffer.Add(samples, timestamp)
 }
 
+// MicLevel returns the RMS level of the most recent microphone samples,
+// the loudest microphone when there are several
+func (r *Recorder) MicLevel() float32 {
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	level := float32(0)
+	for _, micLevel := range r.micLevels {
+		if micLevel > level {
+			level = micLevel
+		}
+	}
+	return level
+}
+
+// MicLevels returns the RMS level of the most recent samples of each microphone
+func (r *Recorder) MicLevels() []float32 {
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	levels := make([]float32, len(r.micLevels))
+	copy(levels, r.micLevels)
+	return levels
+}
+
+// MicChannelLevels returns the RMS level of each microphone channel in the most recent
+// samples, taking the loudest microphone per channel when there are several.
+// A channel stuck near zero while the others move points to a dead or unplugged side.
+func (r *Recorder) MicChannelLevels() []float32 {
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	levels := make([]float32, r.config.Channels)
+	for _, micChannels := range r.micChannelLevels {
+		for ch, level := range micChannels {
+			if level > levels[ch] {
+				levels[ch] = level
+			}
+		}
+	}
+	return levels
+}
+
+// SpeakerLevel returns the RMS level of the most recent speaker samples
+func (r *Recorder) SpeakerLevel() float32 {
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	return r.speakerLevel
+}
+
+// SpeakerChannelLevels returns the RMS level of each speaker channel in the most recent samples
+func (r *Recorder) SpeakerChannelLevels() []float32 {
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	levels := make([]float32, r.config.Channels)
+	copy(levels, r.speakerChannelLevels)
+	return levels
+}
+
+// ClipCount returns how many samples of the recording were written at full scale
+func (r *Recorder) ClipCount() int64 {
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	return r.clipCount
+}
+
+// RecorderStats is a snapshot of a recording's counters and gauges. The levels and the
+// written counters are taken together, so e.g. ClipCount and SamplesWritten always
+// describe the same written audio. The backlogs are read right after.
+type RecorderStats struct {
+	Recording        bool
+	Duration         time.Duration // Since the recording started, zero once stopped
+	MicLevels        []float32     // RMS level of each microphone's latest samples
+	SpeakerLevel     float32
+	ClipCount        int64
+	SamplesWritten   int64 // Every channel, across all parts
+	NonFiniteSamples int64
+	File             string // Final path of the current output file
+	Part             int    // Number of the current output file, from 1
+	FileBytes        int64  // Size of the current output file, header included
+	MicBacklog       []int  // Samples captured but not yet mixed, per microphone
+	SpeakerBacklog   int
+	MicRates         []int // Rate each microphone last delivered at, 0 before it has (see SourceRates)
+	SpeakerRate      int
+}
+
+// Stats returns a snapshot of the recording's counters and gauges. It is safe to call
+// from any goroutine while recording, e.g. to serve metrics.
+func (r *Recorder) Stats() RecorderStats {
+	stats := RecorderStats{
+		Recording:        r.IsRecording(),
+		NonFiniteSamples: r.nonFiniteSamples.Load(),
+	}
+	if stats.Recording {
+		stats.Duration = r.GetRecordingDuration()
+	}
+
+	r.levelMutex.Lock()
+	stats.MicLevels = slices.Clone(r.micLevels)
+	stats.SpeakerLevel = r.speakerLevel
+	stats.ClipCount = r.clipCount
+	stats.SamplesWritten = r.samplesWritten.Load()
+	stats.File = r.outputPath
+	stats.Part = r.outputPart
+	stats.FileBytes = r.outputBytes
+	r.levelMutex.Unlock()
+
+	stats.MicBacklog = make([]int, len(r.micBuffers))
+	for i, buffer := range r.micBuffers {
+		stats.MicBacklog[i] = buffer.Size()
+	}
+	stats.SpeakerBacklog = r.speakerBuffer.Size()
+	stats.MicRates, stats.SpeakerRate = r.SourceRates()
+	return stats
+}
+
+// Marker labels a moment in the recording
+type Marker struct {
+	Label  string
+	Time   time.Time     // Wall-clock time the marker was added, for display
+	Offset time.Duration // Position from the recording start; unlike Time, never jumps with clock changes
+}
+
+// AddMarker records a labelled marker at the current time and returns it
+func (r *Recorder) AddMarker(label string) Marker {
+	now := r.config.Clock.Now()
+	marker := Marker{Label: label, Time: now, Offset: now.Sub(r.GetStartTime())}
+
+	r.markerMutex.Lock()
+	r.markers = append(r.markers, marker)
+	r.markerMutex.Unlock()
+
+	r.publish(EventMarker, label)
+	return marker
+}
+
+// Markers returns the markers added so far, oldest first
+func (r *Recorder) Markers() []Marker {
+	r.markerMutex.Lock()
+	defer r.markerMutex.Unlock()
+
+	markers := make([]Marker, len(r.markers))
+	copy(markers, r.markers)
+	return markers
+}
+
 // GetCurrentChunkStartTime returns when the current chunk started saving
 func (r *Recorder) GetCurrentChunkStartTime() time.Time {
+	r.timerMutex.Lock()
+	defer r.timerMutex.Unlock()
+
 	return r.currentChunkStartTime
 }
 
-// GetStartTime returns when the recording started
+// GetStartTime returns when the recording started, zero before it has
 func (r *Recorder) GetStartTime() time.Time {
+	r.startMutex.Lock()
+	defer r.startMutex.Unlock()
+
 	return r.startTime
 }
 
-// GetOutputFilePath returns the current output file path
+// GetOutputFilePath returns the final path of the current output file, or an empty
+// string when recording to a Sink. With PartialFiles the audio is written to
+// currentWritePath until the file is finished.
 func (r *Recorder) GetOutputFilePath() string {
-	return r.outputFilePath
+	r.levelMutex.Lock()
+	defer r.levelMutex.Unlock()
+
+	return r.outputPath
+}
+
+// currentWritePath returns the path the current output file is being written to
+func (r *Recorder) currentWritePath() string {
+	return r.output.writePath()
 }
 
-// GetRecordingDuration returns the current recording duration
+// SessionFolder returns the folder holding the recording's files: the output folder,
+// or the recording's own folder inside it with SessionFolders. Companion files such
+// as transcripts belong here, named after the recording.
+func (r *Recorder) SessionFolder() string {
+	return filepath.Dir(r.outputBase)
+}
+
+// GetTranscriptionFilePath returns the 16kHz mono transcription file path,
+// or an empty string when TranscriptionOutput is disabled
+func (r *Recorder) GetTranscriptionFilePath() string {
+	return r.transcriptionOutput.filePath
+}
+
+// ByteOffsetAt returns the byte offset in the current output file of the sample captured
+// at the given wall-clock time. Audio data starts right after the WAV header
+// (StandardWAVHeaderSize bytes, more with optional chunks), so seeking a reader to this
+// offset positions it at the start of that frame. Times outside the written audio are
+// clamped to its first or last frame; in a resumed file, times before the resume are
+// clamped to where it continued. It is safe to call from any goroutine while recording.
+func (r *Recorder) ByteOffsetAt(t time.Time) int64 {
+	// Take the layout the writer last published rather than its live state
+	r.levelMutex.Lock()
+	start, firstSample, fileSize := r.outputDataStart, r.outputStart, r.outputBytes
+	r.levelMutex.Unlock()
+
+	if firstSample.IsZero() || !t.After(firstSample) {
+		return start
+	}
+
+	// One sample per channel in each frame
+	blockAlign := int64(r.GetMixedBuffer().Channels() * r.config.OutputBits() / 8)
+	frames := int64(t.Sub(firstSample)) * int64(r.config.SampleRate) / int64(time.Second)
+	offset := start + frames*blockAlign
+	if end := start + (fileSize-start)/blockAlign*blockAlign; offset >= end {
+		offset = max(end-blockAlign, start)
+	}
+
+	return offset
+}
+
+// GetRecordingDuration returns how long the recording has been running, or ran once
+// it stopped. It is zero before the recording starts.
 func (r *Recorder) GetRecordingDuration() time.Duration {
-	return time.Since(r.startTime)
+	r.startMutex.Lock()
+	defer r.startMutex.Unlock()
+
+	switch {
+	case r.startTime.IsZero():
+		return 0
+	case !r.stopTime.IsZero():
+		return r.stopTime.Sub(r.startTime)
+	}
+	return r.config.Clock.Since(r.startTime)
 }
 
 // IsRecording returns whether recording is active
 func (r *Recorder) IsRecording() bool {
-	return r.recordingActive
+	return r.recordingActive.Load()
 }
 
-// GetMicBuffer returns the microphone buffer for external processing
+// GetMicBuffer returns the first microphone's buffer for external processing
 func (r *Recorder) GetMicBuffer() *Buffer {
-	return r.micBuffer
+	return r.micBuffers[0]
 }
 
-// GetMicBuffer returns the speaker buffer for external processing
+// GetSpeakerBuffer returns the speaker buffer for external processing
 func (r *Recorder) GetSpeakerBuffer() *Buffer {
 	return r.speakerBuffer
 }
 
-// GetMicBuffer returns the speaker buffer for external processing
-func (r *Recorder) GetMixedBuffer() *Buffer {
-	return r.mixedBuffer
+// GetMixedBuffer returns the mixed output; call NewReader on it to consume the mix
+// alongside the file writer without taking samples away from it
+func (r *Recorder) GetMixedBuffer() *BroadcastBuffer {
+	r.startMutex.Lock()
+	defer r.startMutex.Unlock()
+
+	return r.mixedOutput
+}
+
+// NewMixedTap registers an independent reader of the mixed output.
+// Close the reader when done so it stops holding samples in memory.
+func (r *Recorder) NewMixedTap() *BroadcastReader {
+	return r.GetMixedBuffer().NewReader()
 }
@@ -0,0 +1,1663 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestRecorder creates a recorder of 8 kHz mono audio writing into a temporary
+// folder; configure adjusts the configuration first
+func newTestRecorder(t *testing.T, configure func(*RecordingConfig)) *Recorder {
+	t.Helper()
+	config := RecordingConfig{
+		ChunkDurationSeconds: 1,
+		OutputFolder:         t.TempDir(),
+		RecordingName:        "test",
+		SampleRate:           8000,
+		Channels:             1,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+	recorder, err := NewRecorderE(config)
+	if err != nil {
+		t.Fatalf("NewRecorderE: %v", err)
+	}
+	return recorder
+}
+
+// feed adds 10ms chunks from a capture source, ten times faster than real time,
+// until stop is closed
+func feed(recorder *Recorder, add func([]float32, time.Time), stop <-chan struct{}) {
+	timestamp := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		chunk := make([]float32, 80)
+		for i := range chunk {
+			chunk[i] = 0.25
+		}
+		add(chunk, timestamp)
+		timestamp = timestamp.Add(10 * time.Millisecond)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStopRecordingRacingCapture(t *testing.T) {
+	tests := []struct {
+		name    string
+		mics    int
+		speaker bool
+	}{
+		{"Mic", 1, false},
+		{"MicAndSpeaker", 1, true},
+		{"TwoMicsAndSpeaker", 2, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.MicGains = make([]float32, tc.mics)
+				for i := range config.MicGains {
+					config.MicGains[i] = 1
+				}
+			})
+			if !tc.speaker {
+				recorder.DisableSpeaker()
+			}
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Capture keeps delivering while stop runs, as a device not stopped first would
+			stopFeeding := make(chan struct{})
+			var feeders sync.WaitGroup
+			for mic := range tc.mics {
+				feeders.Add(1)
+				go func() {
+					defer feeders.Done()
+					feed(recorder, func(samples []float32, timestamp time.Time) {
+						recorder.AddMicSamplesFrom(mic, samples, timestamp)
+					}, stopFeeding)
+				}()
+			}
+			if tc.speaker {
+				feeders.Add(1)
+				go func() {
+					defer feeders.Done()
+					feed(recorder, recorder.AddSpeakerSamples, stopFeeding)
+				}()
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			// Several callers stop at once, e.g. a signal racing the silence stop
+			var stoppers sync.WaitGroup
+			errs := make([]error, 4)
+			for i := range errs {
+				stoppers.Add(1)
+				go func() {
+					defer stoppers.Done()
+					errs[i] = recorder.StopRecording()
+				}()
+			}
+			stoppers.Wait()
+			for i, err := range errs {
+				if err != errs[0] {
+					t.Errorf("StopRecording call %d returned %v, call 0 returned %v", i, err, errs[0])
+				}
+			}
+
+			// Everything accepted before stop was drained into the file
+			stats := recorder.Stats()
+			for mic, backlog := range stats.MicBacklog {
+				if backlog != 0 {
+					t.Errorf("microphone %d has %d samples left unwritten", mic+1, backlog)
+				}
+			}
+			if stats.SpeakerBacklog != 0 {
+				t.Errorf("speaker has %d samples left unwritten", stats.SpeakerBacklog)
+			}
+
+			// Samples arriving after stop are dropped, not buffered
+			time.Sleep(10 * time.Millisecond)
+			close(stopFeeding)
+			feeders.Wait()
+			stats = recorder.Stats()
+			if stats.Recording || stats.MicBacklog[0] != 0 || stats.SpeakerBacklog != 0 {
+				t.Errorf("stopped recorder accepted samples: %+v", stats)
+			}
+
+			_, header, err := ReadWAV(stats.File)
+			if err != nil {
+				t.Fatalf("reading the recording: %v", err)
+			}
+			if written := stats.SamplesWritten; written == 0 || int64(header.DataSize) != written*2 {
+				t.Errorf("file holds %d bytes of audio, recorder wrote %d samples", header.DataSize, written)
+			}
+		})
+	}
+}
+
+func TestStatsWhileRecording(t *testing.T) {
+	recorder := newTestRecorder(t, nil)
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { recorder.StopRecording() })
+
+	stopFeeding := make(chan struct{})
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		feed(recorder, recorder.AddMicSamples, stopFeeding)
+	}()
+
+	// Poll while the capture goroutine feeds and the writer writes, through two saves;
+	// every snapshot must describe one consistent state
+	var last RecorderStats
+	writes := 0
+	deadline := time.Now().Add(10 * time.Second)
+	for writes < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("saw %d saves before the deadline, want 2", writes)
+		}
+		stats := recorder.Stats()
+		if stats.SamplesWritten < last.SamplesWritten {
+			t.Fatalf("SamplesWritten went back from %d to %d", last.SamplesWritten, stats.SamplesWritten)
+		}
+		if stats.SamplesWritten > last.SamplesWritten {
+			writes++
+		}
+		if want := int64(StandardWAVHeaderSize) + stats.SamplesWritten*2; stats.FileBytes != want {
+			t.Fatalf("FileBytes = %d with %d samples written, want %d", stats.FileBytes, stats.SamplesWritten, want)
+		}
+		if !stats.Recording || stats.Part != 1 || stats.File == "" || len(stats.MicLevels) != 1 {
+			t.Fatalf("inconsistent snapshot while recording: %+v", stats)
+		}
+		last = stats
+		time.Sleep(time.Millisecond)
+	}
+	close(stopFeeding)
+	<-fed
+
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := recorder.Stats(); stats.Recording || stats.Duration != 0 || stats.SamplesWritten == 0 {
+		t.Errorf("snapshot after stop = %+v", stats)
+	}
+}
+
+func TestNewRecorderChunkDuration(t *testing.T) {
+	tests := []struct {
+		name      string
+		seconds   int
+		wantErr   bool
+		wantChunk time.Duration // What NewRecorder saves every
+	}{
+		// Without a positive interval the save timer would spin
+		{"Zero", 0, true, time.Second},
+		{"Negative", -5, true, time.Second},
+		{"OneSecond", 1, false, time.Second},
+		{"Minute", 60, false, time.Minute},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := RecordingConfig{
+				ChunkDurationSeconds: tc.seconds,
+				OutputFolder:         t.TempDir(),
+				RecordingName:        "test",
+				SampleRate:           8000,
+				Channels:             1,
+			}
+			if _, err := NewRecorderE(config); (err != nil) != tc.wantErr {
+				t.Errorf("NewRecorderE error = %v, want an error %v", err, tc.wantErr)
+			}
+
+			// The original constructor raises a short duration instead
+			if chunk := NewRecorder(config).GetChunkDuration(); chunk != tc.wantChunk {
+				t.Errorf("NewRecorder saves every %v, want %v", chunk, tc.wantChunk)
+			}
+		})
+	}
+
+	// Other invalid configurations can't be recovered and panic
+	defer func() {
+		if recover() == nil {
+			t.Error("NewRecorder accepted a negative mix weight")
+		}
+	}()
+	NewRecorder(RecordingConfig{
+		ChunkDurationSeconds: 1,
+		OutputFolder:         t.TempDir(),
+		SampleRate:           8000,
+		Channels:             1,
+		MixMode:              MixWeighted,
+		MicWeight:            -1,
+	})
+}
+
+func TestStartToneSurvivesLongFirstSave(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunkSeconds  int
+		captured      time.Duration // Audio captured before the first save
+		transcription bool
+	}{
+		{"ShortSave", 1, 2 * time.Second, false},
+		// More audio than the mix buffer holds arrives before the first save
+		{"SaveAfterMixBuffer", 120, (mixedOutputSeconds + 5) * time.Second, false},
+		{"Transcription", 120, 2 * time.Second, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.ChunkDurationSeconds = tc.chunkSeconds
+				config.StartTone = true
+				config.StartToneDuration = 200 * time.Millisecond
+				config.TranscriptionOutput = tc.transcription
+			})
+			recorder.DisableSpeaker()
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			tone := ToneBurst(defaultStartToneFrequency, startToneAmplitude, 200*time.Millisecond, 8000, 1)
+			if written := recorder.SamplesWritten(); written != int64(len(tone)) {
+				t.Errorf("%d samples written once recording started, want the %d of the tone", written, len(tone))
+			}
+			markers := recorder.Markers()
+			if len(markers) != 1 || markers[0].Label != "start tone" || markers[0].Offset != -200*time.Millisecond {
+				t.Errorf("markers = %+v, want the start tone at -200ms", markers)
+			}
+
+			captured := make([]float32, int(tc.captured.Seconds()*8000))
+			for i := range captured {
+				captured[i] = 0.25
+			}
+			recorder.AddMicSamples(captured, time.Now())
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			samples, _, err := ReadWAV(recorder.GetOutputFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(samples) != len(tone)+len(captured) {
+				t.Fatalf("file holds %d samples, want %d of tone and %d captured", len(samples), len(tone), len(captured))
+			}
+			for i, sample := range tone {
+				if want := Int16ToFloat(FloatToInt16(sample)); samples[i] != want {
+					t.Fatalf("sample %d = %v, want the tone's %v", i, samples[i], want)
+				}
+			}
+			if samples[len(tone)] != 0.25 {
+				t.Errorf("captured audio starts with %v, want 0.25", samples[len(tone)])
+			}
+
+			if tc.transcription {
+				copied, header, err := ReadWAV(recorder.GetTranscriptionFilePath())
+				if err != nil {
+					t.Fatal(err)
+				}
+				toneFrames := len(tone) * TranscriptionSampleRate / 8000
+				if seconds := float64(len(copied)) / float64(header.SampleRate); len(copied) < toneFrames || seconds < tc.captured.Seconds() {
+					t.Errorf("transcription copy holds %.2fs, want the tone and %v", seconds, tc.captured)
+				}
+			}
+		})
+	}
+}
+
+func TestStartRecordingFailure(t *testing.T) {
+	mono := WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16}
+	tests := []struct {
+		name      string
+		resume    bool                                   // Resume a mono file created first
+		configure func(config *RecordingConfig)          // Adjusts the configuration
+		breakFile func(t *testing.T, recorder *Recorder) // Makes starting fail
+		wantFiles []string                               // WAV files left in the folder
+	}{
+		// The file changed format between NewRecorder and StartRecording: it is kept
+		{"ResumedFileChanged", true, nil, func(t *testing.T, recorder *Recorder) {
+			stereo := WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 16}
+			if err := CreateWAVFile(recorder.config.ResumePath, stereo); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"resumed.wav"}},
+		// The transcription copy can't be created: the main file created first is removed
+		{"TranscriptionBlocked", false, func(config *RecordingConfig) {
+			config.TranscriptionOutput = true
+			config.SeekIndexInterval = time.Second
+		}, func(t *testing.T, recorder *Recorder) {
+			if err := os.Mkdir(recorder.GetTranscriptionFilePath(), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			folder := t.TempDir()
+			var resumePath string
+			if tc.resume {
+				resumePath = filepath.Join(folder, "resumed.wav")
+				if err := CreateWAVFile(resumePath, mono); err != nil {
+					t.Fatal(err)
+				}
+			}
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.OutputFolder = folder
+				config.ResumePath = resumePath
+				if tc.configure != nil {
+					tc.configure(config)
+				}
+			})
+			recorder.DisableSpeaker()
+			tc.breakFile(t, recorder)
+
+			if err := recorder.StartRecording(); err == nil {
+				t.Fatal("StartRecording succeeded")
+			}
+			if recorder.IsRecording() {
+				t.Error("recorder is recording after a failed start")
+			}
+			if err := recorder.StopRecording(); err != nil {
+				t.Errorf("StopRecording after a failed start: %v", err)
+			}
+
+			var files []string
+			entries, err := os.ReadDir(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					files = append(files, entry.Name())
+				}
+			}
+			if !slices.Equal(files, tc.wantFiles) {
+				t.Errorf("files left = %v, want %v", files, tc.wantFiles)
+			}
+		})
+	}
+}
+
+func TestByteOffsetAt(t *testing.T) {
+	captureStart := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	second := make([]float32, 8000)
+
+	tests := []struct {
+		name      string
+		configure func(*RecordingConfig)
+		at        time.Duration // From the first captured sample
+		want      int64
+	}{
+		{"BeforeAudio", nil, -time.Second, StandardWAVHeaderSize},
+		{"FirstSample", nil, 0, StandardWAVHeaderSize},
+		{"HalfSecond", nil, 500 * time.Millisecond, StandardWAVHeaderSize + 4000*2},
+		{"PartialFrame", nil, 500*time.Millisecond + 50*time.Microsecond, StandardWAVHeaderSize + 4000*2},
+		{"PastEnd", nil, time.Hour, StandardWAVHeaderSize + 7999*2},
+		{"Stereo", func(config *RecordingConfig) { config.Channels = 2 }, 250 * time.Millisecond,
+			StandardWAVHeaderSize + 2000*4},
+		{"Float", func(config *RecordingConfig) { config.FloatWAV = true }, 250 * time.Millisecond,
+			int64(HeaderSize(WAVHeader{Float: true})) + 2000*4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, tc.configure)
+			recorder.DisableSpeaker()
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+			if offset := recorder.ByteOffsetAt(captureStart); offset != int64(HeaderSize(recorder.outputHeader(captureStart))) {
+				t.Errorf("offset before any audio = %d, want the header size", offset)
+			}
+			channels := recorder.config.Channels
+			recorder.AddMicSamples(make([]float32, len(second)*channels), captureStart)
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			if offset := recorder.ByteOffsetAt(captureStart.Add(tc.at)); offset != tc.want {
+				t.Errorf("ByteOffsetAt(+%v) = %d, want %d", tc.at, offset, tc.want)
+			}
+		})
+	}
+}
+
+func TestByteOffsetAtResumed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resumed.wav")
+	header := WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16}
+	if err := CreateWAVFile(path, header); err != nil {
+		t.Fatal(err)
+	}
+	earlier := &wavWriter{filePath: path}
+	if err := earlier.resume(header); err != nil {
+		t.Fatal(err)
+	}
+	if err := earlier.append(make([]float32, 8000)); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := newTestRecorder(t, func(config *RecordingConfig) { config.ResumePath = path })
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	captureStart := time.Now()
+	recorder.AddMicSamples(make([]float32, 8000), captureStart)
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// New audio continues after the second already in the file
+	resumed := int64(StandardWAVHeaderSize + 8000*2)
+	if offset := recorder.ByteOffsetAt(captureStart.Add(-time.Minute)); offset != resumed {
+		t.Errorf("offset before the resume = %d, want %d", offset, resumed)
+	}
+	if offset := recorder.ByteOffsetAt(captureStart.Add(500 * time.Millisecond)); offset != resumed+4000*2 {
+		t.Errorf("offset half a second in = %d, want %d", offset, resumed+4000*2)
+	}
+}
+
+func TestByteOffsetAtStartTone(t *testing.T) {
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.StartTone = true
+		config.StartToneDuration = 250 * time.Millisecond
+	})
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	start := recorder.GetStartTime()
+	recorder.AddMicSamples(make([]float32, 8000), start)
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tone ends as the recording starts, so that moment follows the tone's frames
+	if offset, want := recorder.ByteOffsetAt(start), int64(StandardWAVHeaderSize+2000*2); offset != want {
+		t.Errorf("offset at the recording start = %d, want %d after the tone", offset, want)
+	}
+}
+
+func TestByteOffsetAtWhileRecording(t *testing.T) {
+	recorder := newTestRecorder(t, nil)
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	stopFeeding := make(chan struct{})
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		feed(recorder, recorder.AddMicSamples, stopFeeding)
+	}()
+
+	// Offsets are frame aligned and inside the file the writer has published so far
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		offset := recorder.ByteOffsetAt(time.Now().Add(time.Hour))
+		stats := recorder.Stats()
+		if (offset-StandardWAVHeaderSize)%2 != 0 || offset > stats.FileBytes && stats.FileBytes > StandardWAVHeaderSize {
+			t.Fatalf("offset %d is not a frame within the %d byte file", offset, stats.FileBytes)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stopFeeding)
+	<-fed
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withinDeadline fails the test if f has not returned after a few seconds, e.g.
+// because it blocked on a channel nobody reads
+func withinDeadline(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s blocked", what)
+	}
+}
+
+func TestSetChunkDurationConcurrent(t *testing.T) {
+	recorder := newTestRecorder(t, nil)
+	recorder.DisableSpeaker()
+
+	setFromMany := func() {
+		var setters sync.WaitGroup
+		for i := range 8 {
+			setters.Add(1)
+			go func() {
+				defer setters.Done()
+				for j := range 50 {
+					recorder.SetChunkDuration(1 + (i+j)%5)
+				}
+			}()
+		}
+		setters.Wait()
+	}
+
+	withinDeadline(t, "SetChunkDuration before start", setFromMany)
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	withinDeadline(t, "SetChunkDuration while recording", setFromMany)
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	withinDeadline(t, "SetChunkDuration after stop", setFromMany)
+
+	withinDeadline(t, "SetChunkDuration after stop", func() { recorder.SetChunkDuration(7) })
+	if interval := recorder.GetChunkDuration(); interval != 7*time.Second {
+		t.Errorf("GetChunkDuration = %v, want the last value set, 7s", interval)
+	}
+}
+
+func TestSetChunkDurationReschedulesSave(t *testing.T) {
+	tests := []struct {
+		name         string
+		seconds      int
+		advance      time.Duration
+		advanceFirst bool // Advance the clock before setting the duration
+	}{
+		{"Shorter", 5, 5 * time.Second, false},
+		// The new interval has already passed when it is set, so the save is immediate
+		{"AlreadyElapsed", 2, 3 * time.Second, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC))
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.ChunkDurationSeconds = 60
+				config.Clock = clock
+			})
+			recorder.DisableSpeaker()
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { recorder.StopRecording() })
+			recorder.AddMicSamples(make([]float32, 8000), clock.Now())
+
+			if tc.advanceFirst {
+				clock.Advance(tc.advance)
+				recorder.SetChunkDuration(tc.seconds)
+			} else {
+				recorder.SetChunkDuration(tc.seconds)
+				clock.Advance(tc.advance)
+			}
+
+			// The save happens long before the original 60 seconds
+			deadline := time.Now().Add(5 * time.Second)
+			for recorder.SamplesWritten() == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("no save after the chunk duration was shortened")
+				}
+				clock.Advance(0)
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestDisableSpeaker(t *testing.T) {
+	tests := []struct {
+		name         string
+		disable      func(recorder *Recorder) error // Runs around StartRecording
+		wantChannels int
+	}{
+		{"BeforeStart", func(recorder *Recorder) error {
+			recorder.DisableSpeaker()
+			return recorder.StartRecording()
+		}, 1},
+		{"AfterStart", func(recorder *Recorder) error {
+			err := recorder.StartRecording()
+			recorder.DisableSpeaker()
+			return err
+		}, 2},
+		// The rebuild of the mix waits for StartRecording, whichever runs first
+		{"RacingStart", func(recorder *Recorder) error {
+			disabled := make(chan struct{})
+			go func() {
+				defer close(disabled)
+				recorder.DisableSpeaker()
+			}()
+			err := recorder.StartRecording()
+			<-disabled
+			return err
+		}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.MixMode = MixStereoSplit
+			})
+
+			// The speaker keeps delivering, as a loopback device would until it fails
+			stopFeeding := make(chan struct{})
+			fed := make(chan struct{})
+			go func() {
+				defer close(fed)
+				feed(recorder, recorder.AddSpeakerSamples, stopFeeding)
+			}()
+			if err := tc.disable(recorder); err != nil {
+				t.Fatal(err)
+			}
+			recorder.AddMicSamples(make([]float32, 8000), time.Now())
+			close(stopFeeding)
+			<-fed
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			if recorder.IsSpeakerEnabled() {
+				t.Error("speaker still enabled")
+			}
+			header, _, err := ProbeWAV(recorder.GetOutputFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := recorder.GetMixedBuffer().Channels(); header.Channels != want {
+				t.Errorf("file has %d channels, mix has %d", header.Channels, want)
+			}
+			if tc.wantChannels != 0 && header.Channels != tc.wantChannels {
+				t.Errorf("file has %d channels, want %d", header.Channels, tc.wantChannels)
+			}
+		})
+	}
+}
+
+// sineChunks splits a 440 Hz sine of the given amplitude at rate into chunks of
+// chunkFrames mono frames
+func sineChunks(rate, frames, chunkFrames int, amplitude float64) [][]float32 {
+	var chunks [][]float32
+	for start := 0; start < frames; start += chunkFrames {
+		chunk := make([]float32, min(chunkFrames, frames-start))
+		for i := range chunk {
+			chunk[i] = float32(amplitude * math.Sin(2*math.Pi*440*float64(start+i)/float64(rate)))
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestAddSamplesAtRate(t *testing.T) {
+	const recordingRate, seconds = 48000, 1
+	tests := []struct {
+		name        string
+		micRate     int
+		speakerRate int // 0 disables the speaker
+		chunkFrames int
+		quality     ResampleQuality
+	}{
+		// 512-frame chunks don't divide evenly, so per-chunk resampling would drift
+		{"Mic44100", 44100, 0, 512, ResampleFast},
+		{"Mic44100HQ", 44100, 0, 512, ResampleHQ},
+		{"Mic16000Speaker48000", 16000, 48000, 160, ResampleFast},
+		{"Mic96000Speaker44100", 96000, 44100, 480, ResampleFast},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.SampleRate = recordingRate
+				config.ResampleQuality = tc.quality
+			})
+			if tc.speakerRate == 0 {
+				recorder.DisableSpeaker()
+			}
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Both sources deliver the same sine, so the mix of aligned streams is one sine
+			start := time.Now()
+			add := func(rate int, deliver func([]float32, time.Time)) {
+				timestamp := start
+				for _, chunk := range sineChunks(rate, seconds*rate, tc.chunkFrames, 0.25) {
+					deliver(chunk, timestamp)
+					timestamp = timestamp.Add(time.Duration(len(chunk)) * time.Second / time.Duration(rate))
+				}
+			}
+			add(tc.micRate, func(samples []float32, timestamp time.Time) {
+				recorder.AddMicSamplesAtRate(0, samples, tc.micRate, timestamp)
+			})
+			if tc.speakerRate != 0 {
+				add(tc.speakerRate, func(samples []float32, timestamp time.Time) {
+					recorder.AddSpeakerSamplesAtRate(samples, tc.speakerRate, timestamp)
+				})
+			}
+
+			mics, speaker := recorder.SourceRates()
+			if mics[0] != tc.micRate || speaker != tc.speakerRate {
+				t.Errorf("SourceRates = %v, %d; want [%d], %d", mics, speaker, tc.micRate, tc.speakerRate)
+			}
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			samples, header, err := ReadWAV(recorder.GetOutputFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.SampleRate != recordingRate {
+				t.Errorf("file rate = %d, want %d", header.SampleRate, recordingRate)
+			}
+			// The resamplers hold back the few frames their filters still need
+			if want := seconds * recordingRate; len(samples) > want || len(samples) < want-2*sincHalfTaps {
+				t.Errorf("recorded %d frames, want about %d", len(samples), want)
+			}
+
+			// A 440 Hz sine moves at most 2*pi*440/48000 of its amplitude per frame;
+			// a chunk boundary that restarted the interpolation would jump further
+			step := 0.0
+			for i := 1; i < len(samples); i++ {
+				step = max(step, math.Abs(float64(samples[i]-samples[i-1])))
+			}
+			if limit := 1.25 * 2 * 0.25 * 2 * math.Pi * 440 / recordingRate; step > limit {
+				t.Errorf("largest step between frames = %.4f, want at most %.4f", step, limit)
+			}
+		})
+	}
+}
+
+func TestNativeRateTranscription(t *testing.T) {
+	const seconds = 1
+	tests := []struct {
+		name        string
+		rate        int // The microphone's native rate, recorded as is
+		channels    int
+		chunkFrames int
+		quality     ResampleQuality
+	}{
+		{"48000", 48000, 1, 480, ResampleFast},
+		{"48000HQ", 48000, 1, 512, ResampleHQ},
+		{"44100", 44100, 1, 441, ResampleFast},
+		{"44100HQ", 44100, 1, 512, ResampleHQ},
+		{"48000Stereo", 48000, 2, 512, ResampleFast},
+		// At 16 kHz the copy needs no conversion
+		{"16000", 16000, 1, 160, ResampleFast},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+			clock := NewFakeClock(start)
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.Clock = clock
+				config.SampleRate = tc.rate
+				config.Channels = tc.channels
+				config.ResampleQuality = tc.quality
+				config.TranscriptionOutput = true
+			})
+			recorder.DisableSpeaker()
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Save after every few chunks, so the copy is converted over several writes
+			// whose lengths aren't a multiple of the conversion ratio
+			timestamp := start
+			for i, chunk := range sineChunks(tc.rate, seconds*tc.rate, tc.chunkFrames, 0.25) {
+				if tc.channels == 2 {
+					chunk = interleave(chunk, chunk)
+				}
+				recorder.AddMicSamplesAtRate(0, chunk, tc.rate, timestamp)
+				timestamp = timestamp.Add(time.Duration(tc.chunkFrames) * time.Second / time.Duration(tc.rate))
+				if i%7 == 6 {
+					clock.Advance(time.Second)
+					fed := int64((i + 1) * tc.chunkFrames * tc.channels)
+					withinDeadline(t, "save", func() {
+						for recorder.SamplesWritten() < fed {
+							time.Sleep(time.Millisecond)
+						}
+					})
+				}
+			}
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The archive keeps the native rate and every captured frame
+			archive, header, err := ReadWAV(recorder.GetOutputFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.SampleRate != tc.rate || header.Channels != tc.channels {
+				t.Errorf("archive is %d Hz with %d channels, want %d Hz with %d", header.SampleRate,
+					header.Channels, tc.rate, tc.channels)
+			}
+			if want := seconds * tc.rate * tc.channels; len(archive) != want {
+				t.Errorf("archive holds %d samples, want %d", len(archive), want)
+			}
+
+			// The transcription copy is 16 kHz mono whatever the archive rate
+			mono, header, err := ReadWAV(recorder.GetTranscriptionFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.SampleRate != TranscriptionSampleRate || header.Channels != 1 {
+				t.Errorf("transcription copy is %d Hz with %d channels, want %d Hz mono",
+					header.SampleRate, header.Channels, TranscriptionSampleRate)
+			}
+			// The resampler holds back the few frames its filter still needs
+			if want := seconds * TranscriptionSampleRate; len(mono) > want || len(mono) < want-2*sincHalfTaps {
+				t.Errorf("transcription copy holds %d frames, want about %d", len(mono), want)
+			}
+			assertRMSNear(t, "transcription copy", mono, sineRMS(0.25))
+
+			// The conversion runs across writes: a restart at a write would jump further
+			// than a 440 Hz sine moves between frames
+			step := 0.0
+			for i := 1; i < len(mono); i++ {
+				step = max(step, math.Abs(float64(mono[i]-mono[i-1])))
+			}
+			if limit := 1.25 * 0.25 * 2 * math.Pi * 440 / TranscriptionSampleRate; step > limit {
+				t.Errorf("largest step between frames = %.4f, want at most %.4f", step, limit)
+			}
+		})
+	}
+}
+
+func TestAddSamplesAtRateChange(t *testing.T) {
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.SampleRate = 48000
+	})
+	recorder.DisableSpeaker()
+	var events []Event
+	recorder.Events().Subscribe(func(event Event) {
+		if event.Type == EventDevice {
+			events = append(events, event)
+		}
+	})
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Half a second at 48 kHz, then the device switches to 44.1 kHz, e.g. a Bluetooth
+	// headset changing profile, for another half. The sine carries on across the switch.
+	timestamp := time.Now()
+	elapsed := 0.0
+	for _, rate := range []int{48000, 44100} {
+		for _, chunk := range sineChunks(rate, rate/2, 512, 0.25) {
+			for i := range chunk {
+				chunk[i] = float32(0.25 * math.Sin(2*math.Pi*440*(elapsed+float64(i)/float64(rate))))
+			}
+			recorder.AddMicSamplesAtRate(0, chunk, rate, timestamp)
+			timestamp = timestamp.Add(time.Duration(len(chunk)) * time.Second / time.Duration(rate))
+			elapsed += float64(len(chunk)) / float64(rate)
+		}
+	}
+	// An index without a microphone is ignored
+	recorder.AddMicSamplesAtRate(1, make([]float32, 512), 44100, timestamp)
+
+	if stats := recorder.Stats(); !slices.Equal(stats.MicRates, []int{44100}) {
+		t.Errorf("Stats MicRates = %v, want [44100]", stats.MicRates)
+	}
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || !strings.Contains(events[0].Message, "from 48000 Hz to 44100 Hz") {
+		t.Errorf("device events = %v, want one rate change", events)
+	}
+	samples, _, err := ReadWAV(recorder.GetOutputFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second half is converted back to 48 kHz: the length is right and the sine
+	// plays on at its pitch, without a jump where the rate changed
+	if len(samples) > 48000 || len(samples) < 48000-4 {
+		t.Errorf("recorded %d frames, want about 48000", len(samples))
+	}
+	step := 0.0
+	for i := 1; i < len(samples); i++ {
+		step = max(step, math.Abs(float64(samples[i]-samples[i-1])))
+	}
+	if limit := 1.25 * 0.25 * 2 * math.Pi * 440 / 48000; step > limit {
+		t.Errorf("largest step between frames = %.4f, want at most %.4f", step, limit)
+	}
+}
+
+func TestMixWaitsForLaggingSource(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		// The fake clock never fires the save timer, so only the test mixes
+		config.Clock = NewFakeClock(start)
+	})
+	tap := recorder.NewMixedTap()
+	defer tap.Close()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The microphone delivers each 10ms chunk before the speaker, and a mix runs in
+	// between, as it would when the mix interval is shorter than the device periods
+	const chunks, chunkFrames = 20, 80
+	chunk := func(value float32) []float32 {
+		samples := make([]float32, chunkFrames)
+		for i := range samples {
+			samples[i] = value
+		}
+		return samples
+	}
+	for i := range chunks {
+		timestamp := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		recorder.AddMicSamples(chunk(0.25), timestamp)
+		recorder.mixPendingAudio(false)
+		recorder.AddSpeakerSamples(chunk(0.5), timestamp)
+		recorder.mixPendingAudio(false)
+	}
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every frame of the mix holds both sources, none one source alone
+	want, _ := recorder.mixStreams(chunk(0.25), start, chunk(0.5), start)
+	mixed, _ := tap.Read()
+	if len(mixed) != chunks*chunkFrames {
+		t.Fatalf("mixed %d frames, want %d", len(mixed), chunks*chunkFrames)
+	}
+	for i, sample := range mixed {
+		if sample != want[0] {
+			t.Fatalf("frame %d = %v, want %v from both sources", i, sample, want[0])
+		}
+	}
+}
+
+func TestMixIntervalFeedsTapsBetweenSaves(t *testing.T) {
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.ChunkDurationSeconds = 60
+		config.MixInterval = 10 * time.Millisecond
+	})
+	tap := recorder.NewMixedTap()
+	defer tap.Close()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Microphone and speaker both reach the tap long before the first save
+	timestamp := time.Now()
+	recorder.AddMicSamples(make([]float32, 800), timestamp)
+	recorder.AddSpeakerSamples(make([]float32, 800), timestamp)
+	deadline := time.Now().Add(5 * time.Second)
+	for tap.Available() < 800 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if available := tap.Available(); available != 800 {
+		t.Fatalf("tap has %d samples, want 800", available)
+	}
+	if written := recorder.Stats().SamplesWritten; written != 0 {
+		t.Errorf("wrote %d samples before the first save", written)
+	}
+
+	// The mix taken between saves still reaches the file
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if written := recorder.Stats().SamplesWritten; written != 800 {
+		t.Errorf("wrote %d samples, want 800", written)
+	}
+}
+
+// fakeDevice is a capture device the test delivers for
+type fakeDevice struct {
+	handler SampleHandler
+	rate    int
+	started bool
+	closed  bool
+}
+
+func (d *fakeDevice) Start() error                           { d.started = true; return nil }
+func (d *fakeDevice) Stop() error                            { d.started = false; return nil }
+func (d *fakeDevice) Uninit()                                { d.started = false; d.closed = true }
+func (d *fakeDevice) SampleRate() int                        { return d.rate }
+func (d *fakeDevice) SetDeviceLostHandler(DeviceLostHandler) {}
+
+// deliver hands the device's handler frames of value captured up to arrived
+func (d *fakeDevice) deliver(value float32, frames int, arrived time.Time) {
+	samples := make([]float32, frames)
+	for i := range samples {
+		samples[i] = value
+	}
+	d.handler(samples, arrived)
+}
+
+func TestSwitchMicDevice(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.ChunkDurationSeconds = 60
+		config.Clock = clock
+	})
+	var events []Event
+	recorder.Events().Subscribe(func(event Event) {
+		if event.Type == EventDevice {
+			events = append(events, event)
+		}
+	})
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	var opened []*fakeDevice
+	opener := func(sampleRate int, handler SampleHandler) (CaptureDevice, error) {
+		device := &fakeDevice{handler: handler, rate: 8000}
+		opened = append(opened, device)
+		return device, nil
+	}
+	failing := func(int, SampleHandler) (CaptureDevice, error) {
+		return nil, errors.New("device busy")
+	}
+	if _, err := recorder.openMicDevice(0, opener); err != nil {
+		t.Fatal(err)
+	}
+
+	// The speaker plays throughout, in 10ms chunks stamped when they arrive. The first
+	// microphone delivers for 100ms, then is switched to a device that takes another
+	// 100ms before its first chunk arrives.
+	const chunkFrames = 80
+	speaker := func(from, to int) {
+		for i := from; i < to; i++ {
+			recorder.AddSpeakerSamples(slices.Repeat([]float32{0.5}, chunkFrames),
+				start.Add(time.Duration(i+1)*10*time.Millisecond))
+		}
+	}
+	for i := range 10 {
+		opened[0].deliver(0.25, chunkFrames, start.Add(time.Duration(i+1)*10*time.Millisecond))
+	}
+	speaker(0, 10)
+	clock.Advance(100 * time.Millisecond)
+
+	// A device that fails to open leaves the old one recording
+	if err := recorder.switchMicDevice(0, failing, "Broken"); err == nil || !opened[0].started {
+		t.Fatalf("failed switch = %v, old device started %v; want an error and the old device", err, opened[0].started)
+	}
+	if err := recorder.switchMicDevice(1, opener, "Headset"); err == nil {
+		t.Error("switched a microphone that doesn't exist")
+	}
+	if err := recorder.switchMicDevice(0, opener, "Headset"); err != nil {
+		t.Fatal(err)
+	}
+	if len(opened) != 2 || !opened[0].closed || !opened[1].started {
+		t.Fatalf("old device closed %v, new device started %v; want the new one recording",
+			opened[0].closed, len(opened) == 2 && opened[1].started)
+	}
+	speaker(10, 20)
+	for i := 20; i < 30; i++ {
+		opened[1].deliver(0.25, chunkFrames, start.Add(time.Duration(i+1)*10*time.Millisecond))
+	}
+	speaker(20, 30)
+	clock.Advance(200 * time.Millisecond)
+
+	recorder.StopDevices()
+	if err := recorder.switchMicDevice(0, opener, "Headset"); err == nil {
+		t.Error("switched a device after the devices were stopped")
+	}
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.CloseDevices()
+	if !opened[1].closed {
+		t.Error("CloseDevices left the new device open")
+	}
+	if len(events) != 1 || !strings.Contains(events[0].Message, "switched to Headset") {
+		t.Errorf("device events = %v, want one switch", events)
+	}
+
+	// The gap is silent on the microphone, and its audio after the switch still lines
+	// up with the speaker: both sources for 100ms, the speaker alone for 100ms while
+	// the devices switched, then both again
+	samples, _, err := ReadWAV(recorder.GetOutputFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	both, _ := recorder.mixStreams([]float32{0.25}, start, []float32{0.5}, start)
+	alone, _ := recorder.mixStreams([]float32{0}, start, []float32{0.5}, start)
+	if len(samples) != 30*chunkFrames {
+		t.Fatalf("recorded %d frames, want %d", len(samples), 30*chunkFrames)
+	}
+	for i, sample := range samples {
+		want := both[0]
+		if i >= 10*chunkFrames && i < 20*chunkFrames {
+			want = alone[0]
+		}
+		if sample != want {
+			t.Fatalf("frame %d = %v, want %v", i, sample, want)
+		}
+	}
+}
+
+// panicProcessor passes samples through for limit chunks, then panics
+type panicProcessor struct {
+	calls, limit int
+}
+
+func (p *panicProcessor) Process(samples []float32, sampleRate, channels int) []float32 {
+	if p.calls++; p.calls > p.limit {
+		panic("processor bug")
+	}
+	return samples
+}
+
+func (p *panicProcessor) Reset() {}
+
+func TestRecoveredPanic(t *testing.T) {
+	const chunkFrames = 800
+	quiet := func() []float32 { return slices.Repeat([]float32{0.25}, chunkFrames) }
+	tests := []struct {
+		name  string
+		setup func(*Recorder)
+		run   func(*Recorder, *FakeClock, time.Time)
+		want  int // Frames saved
+	}{
+		{
+			name: "MicProcessor",
+			setup: func(recorder *Recorder) {
+				recorder.DisableSpeaker()
+				recorder.AddMicProcessor(&panicProcessor{limit: 1})
+			},
+			run: func(recorder *Recorder, clock *FakeClock, start time.Time) {
+				recorder.AddMicSamples(quiet(), start)
+				recorder.AddMicSamples(quiet(), start.Add(100*time.Millisecond))
+			},
+			want: chunkFrames,
+		},
+		{
+			name: "SpeakerProcessor",
+			setup: func(recorder *Recorder) {
+				recorder.AddSpeakerProcessor(&panicProcessor{limit: 1})
+			},
+			run: func(recorder *Recorder, clock *FakeClock, start time.Time) {
+				recorder.AddMicSamples(quiet(), start)
+				recorder.AddSpeakerSamples(quiet(), start)
+				recorder.AddMicSamples(quiet(), start.Add(100*time.Millisecond))
+				recorder.AddSpeakerSamples(quiet(), start.Add(100*time.Millisecond))
+			},
+			want: 2 * chunkFrames,
+		},
+		{
+			// An event handler runs on the writer, which panics while saving the second chunk
+			name: "Writer",
+			setup: func(recorder *Recorder) {
+				recorder.DisableSpeaker()
+				recorder.Events().Subscribe(func(event Event) {
+					if event.Type == EventClip {
+						panic("event handler bug")
+					}
+				})
+			},
+			run: func(recorder *Recorder, clock *FakeClock, start time.Time) {
+				recorder.AddMicSamples(quiet(), start)
+				clock.Advance(time.Second)
+				withinDeadline(t, "first save", func() {
+					for recorder.SamplesWritten() < chunkFrames {
+						time.Sleep(time.Millisecond)
+					}
+				})
+				recorder.AddMicSamples(slices.Repeat([]float32{1}, chunkFrames), start.Add(100*time.Millisecond))
+				clock.Advance(time.Second)
+			},
+			want: chunkFrames,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+			clock := NewFakeClock(start)
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.Clock = clock
+			})
+			var errorEvents atomic.Int32
+			recorder.Events().Subscribe(func(event Event) {
+				if event.Type == EventError {
+					errorEvents.Add(1)
+				}
+			})
+			tc.setup(recorder)
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The panic is recovered without crashing and stops the recording by itself
+			tc.run(recorder, clock, start)
+			select {
+			case <-recorder.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("the recording didn't stop after the panic")
+			}
+			if !recorder.Panicked() || errorEvents.Load() != 1 {
+				t.Errorf("Panicked = %v with %d error events, want true with one", recorder.Panicked(), errorEvents.Load())
+			}
+			if err := recorder.StopRecording(); err == nil || !strings.Contains(err.Error(), "panic") {
+				t.Errorf("StopRecording = %v, want the panic reported", err)
+			}
+
+			// The audio saved before the panic is in a file whose header announces it
+			data, err := os.ReadFile(recorder.GetOutputFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, dataBytes, err := ProbeWAV(recorder.GetOutputFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			headerSize := HeaderSize(header)
+			if declared := binary.LittleEndian.Uint32(data[headerSize-4:]); int64(declared) != dataBytes {
+				t.Errorf("header announces %d bytes, file holds %d", declared, dataBytes)
+			}
+			if frames := int(dataBytes) / (header.BitsPerSample / 8); frames != tc.want {
+				t.Errorf("saved %d frames, want %d", frames, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecordingDuration(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.Clock = clock
+	})
+	if duration := recorder.GetRecordingDuration(); duration != 0 {
+		t.Errorf("duration before the start = %v, want 0", duration)
+	}
+
+	// Status readers poll the duration while the recording starts and stops
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			recorder.GetStartTime()
+			recorder.GetRecordingDuration()
+			recorder.Stats()
+		}
+	}()
+
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.AddMicSamples(make([]float32, 800), start)
+	clock.Advance(2 * time.Second)
+	if duration := recorder.GetRecordingDuration(); duration != 2*time.Second {
+		t.Errorf("duration while recording = %v, want 2s", duration)
+	}
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	readers.Wait()
+
+	// The duration stops counting with the recording
+	clock.Advance(5 * time.Second)
+	if duration := recorder.GetRecordingDuration(); duration != 2*time.Second {
+		t.Errorf("duration after the stop = %v, want 2s", duration)
+	}
+	if got := recorder.GetStartTime(); !got.Equal(start) {
+		t.Errorf("GetStartTime = %v, want %v", got, start)
+	}
+}
+
+func TestStopAfterSilence(t *testing.T) {
+	const rate, loud = 8000, 0.5
+	tests := []struct {
+		name      string
+		levels    []float32 // Level of each second fed
+		stopAfter int       // Seconds recorded when the recording stops by itself
+	}{
+		// Speech followed by silence stops three seconds after the last sound arrived,
+		// and the silent tail up to then is kept
+		{"SilentTail", []float32{loud, 0, 0, 0, 0, 0}, 4},
+		{"QuietBelowThreshold", []float32{loud, loud, 0.005, 0.005, 0.005, 0.005}, 5},
+		// Pauses shorter than the timeout don't stop it
+		{"BriefPauses", []float32{loud, 0, 0, loud, 0, 0, loud, 0, 0, 0, 0}, 10},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+			clock := NewFakeClock(start)
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.ChunkDurationSeconds = 60
+				config.StopAfterSilenceSeconds = 3
+				config.SilenceThreshold = 0.01
+				config.Clock = clock
+			})
+			recorder.DisableSpeaker()
+			var silenceEvents atomic.Int32
+			recorder.Events().Subscribe(func(event Event) {
+				if event.Type == EventSilence {
+					silenceEvents.Add(1)
+				}
+			})
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Each second is stamped when it arrives, at its end, as capture callbacks are
+			for i, level := range tc.levels[:tc.stopAfter] {
+				recorder.AddMicSamples(slices.Repeat([]float32{level}, rate), start.Add(time.Duration(i+1)*time.Second))
+				clock.Advance(time.Second)
+				if i+1 < tc.stopAfter {
+					select {
+					case <-recorder.Done():
+						t.Fatalf("stopped after %d seconds, want %d", i+1, tc.stopAfter)
+					case <-time.After(20 * time.Millisecond):
+					}
+				}
+			}
+			select {
+			case <-recorder.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("still recording after %d seconds", tc.stopAfter)
+			}
+
+			if recorder.IsRecording() || silenceEvents.Load() != 1 {
+				t.Errorf("recording %v with %d silence events, want stopped with one", recorder.IsRecording(), silenceEvents.Load())
+			}
+			samples, _, err := ReadWAV(recorder.GetOutputFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tc.stopAfter * rate; len(samples) != want {
+				t.Errorf("saved %d frames, want %d", len(samples), want)
+			}
+		})
+	}
+}
+
+func TestOutputFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  int
+		rotate  bool
+		wantLen int
+	}{
+		{"Empty", 0, false, 0},
+		{"Single", 8000, false, 2},
+		{"Parts", 8000, true, 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.TranscriptionOutput = true
+			})
+			recorder.DisableSpeaker()
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+			if tc.frames > 0 {
+				recorder.AddMicSamples(make([]float32, tc.frames), time.Now())
+			}
+			if tc.rotate {
+				if err := recorder.Rotate(); err != nil {
+					t.Fatal(err)
+				}
+				recorder.AddMicSamples(make([]float32, tc.frames), time.Now())
+			}
+			if files := recorder.OutputFiles(); files != nil {
+				t.Errorf("OutputFiles while recording = %v, want none", files)
+			}
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Every listed file exists, the current output and transcription copy last
+			files := recorder.OutputFiles()
+			if len(files) != tc.wantLen {
+				t.Fatalf("OutputFiles = %v, want %d files", files, tc.wantLen)
+			}
+			for _, file := range files {
+				if !exists(file) {
+					t.Errorf("listed %s, which doesn't exist", filepath.Base(file))
+				}
+			}
+			if tc.wantLen > 0 {
+				want := []string{recorder.GetOutputFilePath(), recorder.GetTranscriptionFilePath()}
+				if got := files[len(files)-2:]; !slices.Equal(got, want) {
+					t.Errorf("OutputFiles ends with %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	recorder := newTestRecorder(t, nil)
+	recorder.DisableSpeaker()
+	var completed []string
+	recorder.SetFileCompleteHandler(func(path string) {
+		completed = append(completed, path)
+	})
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	first := recorder.GetOutputFilePath()
+
+	// A file without audio yet is kept rather than rotated away empty
+	if err := recorder.Rotate(); err != nil {
+		t.Fatalf("Rotate before any audio: %v", err)
+	}
+	if path := recorder.GetOutputFilePath(); path != first || len(completed) != 0 {
+		t.Errorf("Rotate before any audio moved to %s and completed %v", filepath.Base(path), completed)
+	}
+
+	start := time.Now()
+	recorder.AddMicSamples(slices.Repeat([]float32{0.25}, 8000), start)
+	if err := recorder.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	second := recorder.GetOutputFilePath()
+	if second == first || !exists(second) {
+		t.Fatalf("recording continues in %s, want a new file", filepath.Base(second))
+	}
+	if !slices.Equal(completed, []string{first}) {
+		t.Errorf("completed files = %v, want the old file", completed)
+	}
+
+	// The old file is finalized: its header announces all the audio it holds
+	header, _, err := ProbeWAV(first)
+	if err != nil {
+		t.Fatalf("ProbeWAV(old file): %v", err)
+	}
+	if header.DataSize != 8000*2 {
+		t.Errorf("old file header announces %d bytes, want %d", header.DataSize, 8000*2)
+	}
+
+	recorder.AddMicSamples(slices.Repeat([]float32{0.5}, 4000), start.Add(time.Second))
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Rotate(); err == nil {
+		t.Error("Rotate after stopping succeeded")
+	}
+
+	// Every sample is in exactly one of the files, each in its own
+	for _, file := range []struct {
+		path   string
+		frames int
+		level  float32
+	}{{first, 8000, 0.25}, {second, 4000, 0.5}} {
+		samples, _, err := ReadWAV(file.path)
+		if err != nil {
+			t.Fatalf("ReadWAV(%s): %v", filepath.Base(file.path), err)
+		}
+		if len(samples) != file.frames {
+			t.Errorf("%s holds %d frames, want %d", filepath.Base(file.path), len(samples), file.frames)
+			continue
+		}
+		for i, sample := range samples {
+			if math.Abs(float64(sample-file.level)) > 1e-3 {
+				t.Errorf("%s frame %d = %v, want %v", filepath.Base(file.path), i, sample, file.level)
+				break
+			}
+		}
+	}
+}
+
+func TestSameSecondRecordingsGetDistinctFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*RecordingConfig)
+	}{
+		{"Single", func(*RecordingConfig) {}},
+		{"Transcription", func(config *RecordingConfig) { config.TranscriptionOutput = true }},
+		{"Parts", func(config *RecordingConfig) { config.PartDurationSeconds = 60 }},
+		{"SessionFolders", func(config *RecordingConfig) { config.SessionFolders = true }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Every recording starts in the same second, the second one while the first
+			// is still recording and the third after both stopped
+			folder := t.TempDir()
+			clock := NewFakeClock(time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC))
+			start := func() *Recorder {
+				recorder := newTestRecorder(t, func(config *RecordingConfig) {
+					config.OutputFolder = folder
+					config.Clock = clock
+					tc.configure(config)
+				})
+				recorder.DisableSpeaker()
+				if err := recorder.StartRecording(); err != nil {
+					t.Fatal(err)
+				}
+				recorder.AddMicSamples(make([]float32, 8000), clock.Now())
+				return recorder
+			}
+			first := start()
+			second := start()
+			for _, recorder := range []*Recorder{first, second} {
+				if err := recorder.StopRecording(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			third := start()
+			if err := third.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			seen := make(map[string]bool)
+			for i, recorder := range []*Recorder{first, second, third} {
+				files := recorder.OutputFiles()
+				if len(files) == 0 {
+					t.Fatalf("recording %d saved no files", i+1)
+				}
+				for _, file := range files {
+					if seen[file] {
+						t.Errorf("recording %d reused %s", i+1, filepath.Base(file))
+					}
+					seen[file] = true
+				}
+			}
+
+			// The later recordings are numbered after the first one's name
+			base := filepath.Base(first.outputBase)
+			for i, recorder := range []*Recorder{second, third} {
+				if want := fmt.Sprintf("%s_%d", base, i+2); filepath.Base(recorder.outputBase) != want {
+					t.Errorf("recording %d named %s, want %s", i+2, filepath.Base(recorder.outputBase), want)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputBaseTaken(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		taken bool
+	}{
+		{"Recording", "rec.wav", true},
+		{"Part", "rec_part002.wav", true},
+		{"ArchivedPart", "rec_part001.flac", true},
+		{"TranscriptionLeft", "rec_16k.wav", true},
+		{"SessionFolder", "rec", true},
+		{"EventLog", "rec.log", true},
+		{"NumberedRecording", "rec_2.wav", false},
+		{"OtherName", "record.wav", false},
+		{"Unrelated", "notes.txt", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, tc.file), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if taken := outputBaseTaken(filepath.Join(dir, "rec")); taken != tc.taken {
+				t.Errorf("with %s, base taken = %v, want %v", tc.file, taken, tc.taken)
+			}
+		})
+	}
+}
+
+func TestRecorderMinWriteBlock(t *testing.T) {
+	// A block longer than the recording holds everything until rotating or stopping
+	recorder := newTestRecorder(t, func(config *RecordingConfig) {
+		config.MinWriteBlock = time.Hour
+	})
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	first := recorder.GetOutputFilePath()
+	start := time.Now()
+	for i := range 4 {
+		recorder.AddMicSamples(slices.Repeat([]float32{0.25}, 2000), start.Add(time.Duration(i)*250*time.Millisecond))
+	}
+	if err := recorder.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	second := recorder.GetOutputFilePath()
+	for i := range 3 {
+		recorder.AddMicSamples(slices.Repeat([]float32{0.5}, 2000), start.Add(time.Second+time.Duration(i)*250*time.Millisecond))
+	}
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The held audio reaches both parts, and the totals agree with what was captured
+	total := 0
+	for _, file := range []struct {
+		path   string
+		frames int
+	}{{first, 8000}, {second, 6000}} {
+		samples, header, err := ReadWAV(file.path)
+		if err != nil {
+			t.Fatalf("ReadWAV(%s): %v", filepath.Base(file.path), err)
+		}
+		if len(samples) != file.frames || header.DataSize != file.frames*2 {
+			t.Errorf("%s holds %d frames with DataSize %d, want %d frames", filepath.Base(file.path),
+				len(samples), header.DataSize, file.frames)
+		}
+		total += len(samples)
+	}
+	if written := recorder.SamplesWritten(); written != int64(total) || total != 14000 {
+		t.Errorf("SamplesWritten = %d with %d samples in the files, want 14000", written, total)
+	}
+}
@@ -0,0 +1,391 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ResampleQuality selects the interpolation used when converting sample rates
+type ResampleQuality int
+
+const (
+	// ResampleFast uses linear interpolation, with box-filtered decimation for integer
+	// downsampling. It costs about two multiply-adds per output sample and channel.
+	ResampleFast ResampleQuality = iota
+	// ResampleHQ uses a Kaiser-windowed sinc filter with 16 taps at the output rate
+	// (more when downsampling, to keep the same filter shape). It suppresses aliasing
+	// far better than ResampleFast but costs roughly eight times the CPU, more still
+	// for large downsampling ratios.
+	ResampleHQ
+)
+
+// resampleQualityNames maps each quality to its command line name
+var resampleQualityNames = map[ResampleQuality]string{
+	ResampleFast: "fast",
+	ResampleHQ:   "hq",
+}
+
+// String returns the command line name of the resample quality
+func (q ResampleQuality) String() string {
+	if name, ok := resampleQualityNames[q]; ok {
+		return name
+	}
+	return fmt.Sprintf("ResampleQuality(%d)", int(q))
+}
+
+// MarshalText encodes the resample quality by its command line name, e.g. in JSON
+func (q ResampleQuality) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// ParseResampleQuality converts a command line name into a resample quality
+func ParseResampleQuality(name string) (ResampleQuality, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for quality, qualityName := range resampleQualityNames {
+		if qualityName == name {
+			return quality, nil
+		}
+	}
+	return ResampleFast, fmt.Errorf("unknown resample quality %q", name)
+}
+
+// Supported resampling range. Linear interpolation degrades quickly at extreme
+// ratios, so rates must lie within [MinResampleRate, MaxResampleRate] and neither
+// rate may exceed the other by more than MaxResampleRatio.
+const (
+	MinResampleRate  = 8000
+	MaxResampleRate  = 192000
+	MaxResampleRatio = 8
+)
+
+// maxResampleOutput caps the samples a single Resample call produces (60s of 8ch 192kHz)
+const maxResampleOutput = 60 * 8 * MaxResampleRate
+
+// ValidateResampleRates checks that converting between two rates is supported
+func ValidateResampleRates(fromRate, toRate int) error {
+	for _, rate := range []int{fromRate, toRate} {
+		if rate < MinResampleRate || rate > MaxResampleRate {
+			return fmt.Errorf("sample rate %d outside supported range %d-%d",
+				rate, MinResampleRate, MaxResampleRate)
+		}
+	}
+	if fromRate > toRate*MaxResampleRatio || toRate > fromRate*MaxResampleRatio {
+		return fmt.Errorf("resampling %d to %d exceeds the maximum ratio of %d",
+			fromRate, toRate, MaxResampleRatio)
+	}
+	return nil
+}
+
+// Resample converts interleaved samples between sample rates at ResampleFast quality.
+// Integer downsampling ratios use the anti-aliased Decimate path; other ratios use
+// linear interpolation. Callers should check the rates with ValidateResampleRates;
+// output beyond maxResampleOutput samples is truncated.
+func Resample(samples []float32, fromRate, toRate, channels int) []float32 {
+	return ResampleWithQuality(samples, fromRate, toRate, channels, ResampleFast)
+}
+
+// ResampleWithQuality converts interleaved samples between sample rates using the
+// given interpolation quality
+func ResampleWithQuality(samples []float32, fromRate, toRate, channels int, quality ResampleQuality) []float32 {
+	if fromRate == toRate || len(samples) == 0 || fromRate <= 0 || toRate <= 0 {
+		return samples
+	}
+
+	inFrames := len(samples) / channels
+	outFrames := resampledFrames(inFrames, fromRate, toRate, channels)
+
+	if quality == ResampleHQ {
+		return resampleSinc(samples, fromRate, toRate, channels, outFrames)
+	}
+
+	if fromRate > toRate && fromRate%toRate == 0 {
+		return Decimate(samples, fromRate/toRate, channels)
+	}
+
+	resampled := make([]float32, outFrames*channels)
+
+	step := float64(fromRate) / float64(toRate)
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * step
+		index := int(pos)
+		frac := float32(pos - float64(index))
+
+		next := index + 1
+		if next >= inFrames {
+			next = inFrames - 1
+		}
+
+		for ch := 0; ch < channels; ch++ {
+			a := samples[index*channels+ch]
+			b := samples[next*channels+ch]
+			resampled[i*channels+ch] = a + (b-a)*frac
+		}
+	}
+
+	return resampled
+}
+
+// resampledFrames returns how many frames resampling inFrames produces, capped so the
+// output holds at most maxResampleOutput samples
+func resampledFrames(inFrames, fromRate, toRate, channels int) int {
+	outFrames := int(int64(inFrames) * int64(toRate) / int64(fromRate))
+	return min(outFrames, maxResampleOutput/channels)
+}
+
+// Decimate reduces the sample rate by an integer factor. Each output frame is the
+// average of factor input frames, a simple low-pass prefilter that suppresses most
+// of the aliasing that dropping frames outright would cause.
+func Decimate(samples []float32, factor, channels int) []float32 {
+	if factor <= 1 || len(samples) == 0 {
+		return samples
+	}
+
+	outFrames := len(samples) / channels / factor
+	decimated := make([]float32, outFrames*channels)
+	for i := 0; i < outFrames; i++ {
+		for ch := 0; ch < channels; ch++ {
+			sum := float32(0)
+			for k := 0; k < factor; k++ {
+				sum += samples[(i*factor+k)*channels+ch]
+			}
+			decimated[i*channels+ch] = sum / float32(factor)
+		}
+	}
+
+	return decimated
+}
+
+// Windowed-sinc filter parameters for ResampleHQ
+const (
+	sincHalfTaps    = 8                     // Taps on each side of the output sample, at the output rate
+	sincKaiserBeta  = 6.0                   // Kaiser window shape, about 60 dB stopband attenuation
+	sincKaiserScale = 1 / 67.23440697647797 // 1 / I0(sincKaiserBeta)
+)
+
+// resampleSinc interpolates with a Kaiser-windowed sinc low-pass filter. When
+// downsampling the cutoff drops to the output Nyquist rate and the filter widens
+// to match, so content that would alias is removed first.
+func resampleSinc(samples []float32, fromRate, toRate, channels, outFrames int) []float32 {
+	inFrames := len(samples) / channels
+	ratio := float64(toRate) / float64(fromRate)
+	cutoff := math.Min(1, ratio)
+	halfWidth := sincHalfTaps / cutoff
+	step := 1 / ratio
+
+	resampled := make([]float32, outFrames*channels)
+	weights := make([]float64, 0, int(2*halfWidth)+2)
+	sums := make([]float64, channels)
+
+	for i := 0; i < outFrames; i++ {
+		center := float64(i) * step
+		first := int(math.Ceil(center - halfWidth))
+		last := int(math.Floor(center + halfWidth))
+		if first < 0 {
+			first = 0
+		}
+		if last >= inFrames {
+			last = inFrames - 1
+		}
+
+		// Weights are shared by every channel of the frame
+		var total float64
+		weights, total = sincWeights(weights[:0], first, last, center, cutoff, halfWidth)
+		if total == 0 {
+			continue
+		}
+
+		for ch := range sums {
+			sums[ch] = 0
+		}
+		for j, w := range weights {
+			frame := (first + j) * channels
+			for ch := 0; ch < channels; ch++ {
+				sums[ch] += w * float64(samples[frame+ch])
+			}
+		}
+
+		// Normalizing keeps unity gain at DC, including near the edges of the block
+		for ch := 0; ch < channels; ch++ {
+			resampled[i*channels+ch] = float32(sums[ch] / total)
+		}
+	}
+
+	return resampled
+}
+
+// sincWeights appends the filter weights of input frames first to last for an output
+// frame centered at center, returning them and their sum
+func sincWeights(weights []float64, first, last int, center, cutoff, halfWidth float64) ([]float64, float64) {
+	total := 0.0
+	for k := first; k <= last; k++ {
+		x := float64(k) - center
+		w := cutoff * sinc(cutoff*x) * kaiser(x/halfWidth)
+		weights = append(weights, w)
+		total += w
+	}
+	return weights, total
+}
+
+// sinc is the normalized sinc function sin(pi x) / (pi x)
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser evaluates the Kaiser window at x in [-1, 1]
+func kaiser(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return besselI0(sincKaiserBeta*math.Sqrt(1-x*x)) * sincKaiserScale
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 32; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+// StreamResampler converts a continuous stream that arrives in chunks, such as
+// capture callbacks, between sample rates with the kernels of ResampleWithQuality.
+// Resampling each chunk on its own restarts the interpolation at every chunk: the
+// fractional position is lost, so the stream drifts, and the filter sees an edge, so
+// it clicks. StreamResampler instead carries its position and the input frames its
+// filter still needs from one chunk to the next, so its output is the same however
+// the input is split. It holds back the last few input frames until the next chunk.
+type StreamResampler struct {
+	fromRate  int
+	toRate    int
+	channels  int
+	quality   ResampleQuality
+	factor    int       // Decimation factor for integer ResampleFast downsampling, 0 otherwise
+	cutoff    float64   // ResampleHQ filter cutoff relative to the input Nyquist rate
+	halfWidth float64   // ResampleHQ filter reach either side of an output frame, in input frames
+	pending   []float32 // Input from frame offset on, kept for output frames not yet produced
+	offset    int64     // Stream index of the first pending frame
+	produced  int64     // Output frames produced so far
+	weights   []float64
+	sums      []float64
+}
+
+// NewStreamResampler creates a resampler for one stream. Callers should check the
+// rates with ValidateResampleRates.
+func NewStreamResampler(fromRate, toRate, channels int, quality ResampleQuality) *StreamResampler {
+	s := &StreamResampler{
+		fromRate: fromRate,
+		toRate:   toRate,
+		channels: channels,
+		quality:  quality,
+		sums:     make([]float64, channels),
+	}
+	switch {
+	case quality == ResampleHQ:
+		s.cutoff = math.Min(1, float64(toRate)/float64(fromRate))
+		s.halfWidth = sincHalfTaps / s.cutoff
+	case fromRate > toRate && fromRate%toRate == 0:
+		s.factor = fromRate / toRate
+	}
+	return s
+}
+
+// Process converts the next chunk of the stream, returning the output frames that
+// the input so far completes
+func (s *StreamResampler) Process(samples []float32) []float32 {
+	if s.fromRate == s.toRate {
+		return samples
+	}
+	s.pending = append(s.pending, samples...)
+	end := s.offset + int64(len(s.pending)/s.channels)
+
+	var resampled []float32
+	for {
+		first, last, center := s.window(s.produced)
+		if last >= end {
+			break
+		}
+		resampled = s.appendFrame(resampled, first, last, center)
+		s.produced++
+	}
+
+	// Drop the input that no later output frame reaches back to
+	first, _, _ := s.window(s.produced)
+	if drop := min(first, end) - s.offset; drop > 0 {
+		s.pending = s.pending[:copy(s.pending, s.pending[drop*int64(s.channels):])]
+		s.offset += drop
+	}
+	return resampled
+}
+
+// window returns the input frames that output frame n is computed from, and the
+// stream position it is centered at
+func (s *StreamResampler) window(n int64) (first, last int64, center float64) {
+	center = float64(n*int64(s.fromRate)) / float64(s.toRate)
+	switch {
+	case s.quality == ResampleHQ:
+		first = max(0, int64(math.Ceil(center-s.halfWidth)))
+		last = int64(math.Floor(center + s.halfWidth))
+	case s.factor > 0:
+		first = n * int64(s.factor)
+		last = first + int64(s.factor) - 1
+	default:
+		first = int64(center)
+		last = first + 1
+	}
+	return first, last, center
+}
+
+// appendFrame computes one output frame from the pending input frames first to last
+func (s *StreamResampler) appendFrame(resampled []float32, first, last int64, center float64) []float32 {
+	frame := func(k int64) []float32 {
+		start := int((k - s.offset) * int64(s.channels))
+		return s.pending[start : start+s.channels]
+	}
+
+	switch {
+	case s.quality == ResampleHQ:
+		var total float64
+		s.weights, total = sincWeights(s.weights[:0], int(first), int(last), center, s.cutoff, s.halfWidth)
+		for ch := range s.sums {
+			s.sums[ch] = 0
+		}
+		for j, w := range s.weights {
+			for ch, sample := range frame(first + int64(j)) {
+				s.sums[ch] += w * float64(sample)
+			}
+		}
+		for ch := range s.sums {
+			if total == 0 {
+				resampled = append(resampled, 0)
+				continue
+			}
+			resampled = append(resampled, float32(s.sums[ch]/total))
+		}
+
+	case s.factor > 0:
+		for ch := 0; ch < s.channels; ch++ {
+			sum := float32(0)
+			for k := first; k <= last; k++ {
+				sum += frame(k)[ch]
+			}
+			resampled = append(resampled, sum/float32(s.factor))
+		}
+
+	default:
+		frac := float32(center - float64(first))
+		a, b := frame(first), frame(last)
+		for ch := 0; ch < s.channels; ch++ {
+			resampled = append(resampled, a[ch]+(b[ch]-a[ch])*frac)
+		}
+	}
+	return resampled
+}
@@ -0,0 +1,194 @@
+package audio
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestStreamResamplerChunking(t *testing.T) {
+	tests := []struct {
+		name     string
+		fromRate int
+		toRate   int
+		channels int
+		quality  ResampleQuality
+	}{
+		{"Linear", 44100, 48000, 1, ResampleFast},
+		{"LinearStereo", 48000, 44100, 2, ResampleFast},
+		{"Decimate", 48000, 16000, 1, ResampleFast},
+		{"Sinc", 44100, 48000, 2, ResampleHQ},
+		{"SincDown", 48000, 16000, 1, ResampleHQ},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := make([]float32, tc.fromRate/10*tc.channels)
+			for i := range input {
+				frame, ch := i/tc.channels, i%tc.channels
+				input[i] = float32(math.Sin(2 * math.Pi * float64(300+100*ch) * float64(frame) / float64(tc.fromRate)))
+			}
+
+			// One pass over everything is the reference for any split of the same input
+			whole := NewStreamResampler(tc.fromRate, tc.toRate, tc.channels, tc.quality).Process(input)
+			for _, chunkFrames := range []int{1, 7, 160, 511} {
+				resampler := NewStreamResampler(tc.fromRate, tc.toRate, tc.channels, tc.quality)
+				var chunked []float32
+				for start := 0; start < len(input); start += chunkFrames * tc.channels {
+					end := min(start+chunkFrames*tc.channels, len(input))
+					chunked = append(chunked, resampler.Process(input[start:end])...)
+				}
+				if !slices.Equal(chunked, whole) {
+					t.Errorf("%d-frame chunks gave %d samples differing from the %d of one pass",
+						chunkFrames, len(chunked), len(whole))
+				}
+			}
+
+			// Only the frames the filter still needs are held back
+			inFrames := len(input) / tc.channels
+			want := inFrames * tc.toRate / tc.fromRate
+			if got := len(whole) / tc.channels; got > want || got < want-2*sincHalfTaps*max(1, tc.fromRate/tc.toRate) {
+				t.Errorf("produced %d frames from %d, want about %d", got, inFrames, want)
+			}
+
+			// Away from the stream's start it matches resampling the block at once
+			block := ResampleWithQuality(input, tc.fromRate, tc.toRate, tc.channels, tc.quality)
+			for i := len(whole) / 2; i < len(whole); i++ {
+				if math.Abs(float64(whole[i]-block[i])) > 1e-5 {
+					t.Fatalf("sample %d = %v, block resampling gives %v", i, whole[i], block[i])
+				}
+			}
+		})
+	}
+}
+
+// tone returns seconds of a mono sine at frequency
+func tone(frequency float64, rate int, seconds float64) []float32 {
+	samples := make([]float32, int(seconds*float64(rate)))
+	for i := range samples {
+		samples[i] = float32(math.Sin(2 * math.Pi * frequency * float64(i) / float64(rate)))
+	}
+	return samples
+}
+
+func TestResampleLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		fromRate int
+		toRate   int
+		channels int
+		quality  ResampleQuality
+		inFrames int
+		want     int // Output frames
+	}{
+		{"Up44100To48000", 44100, 48000, 1, ResampleFast, 44100, 48000},
+		{"Up44100To48000HQ", 44100, 48000, 1, ResampleHQ, 44100, 48000},
+		{"Up44100To48000Stereo", 44100, 48000, 2, ResampleFast, 4410, 4800},
+		{"Down48000To16000", 48000, 16000, 1, ResampleFast, 48000, 16000},
+		{"Down48000To16000HQ", 48000, 16000, 1, ResampleHQ, 48000, 16000},
+		{"Down48000To16000Stereo", 48000, 16000, 2, ResampleFast, 4800, 1600},
+		// Partial output frames are dropped
+		{"Down48000To16000Uneven", 48000, 16000, 1, ResampleFast, 4801, 1600},
+		{"SameRate", 48000, 48000, 2, ResampleFast, 100, 100},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := make([]float32, tc.inFrames*tc.channels)
+			output := ResampleWithQuality(input, tc.fromRate, tc.toRate, tc.channels, tc.quality)
+			if frames := len(output) / tc.channels; frames != tc.want || len(output)%tc.channels != 0 {
+				t.Errorf("%d frames gave %d samples, want %d frames", tc.inFrames, len(output), tc.want)
+			}
+		})
+	}
+}
+
+func TestDecimateAliasing(t *testing.T) {
+	const rate, factor = 48000, 3
+	tests := []struct {
+		name      string
+		frequency float64
+		minRatio  float64 // Prefiltered level against dropping frames outright
+		maxRatio  float64
+	}{
+		// Well below the new Nyquist rate of 8 kHz the averaging barely changes the tone
+		{"Passband", 1000, 0.95, 1.01},
+		// Above it, dropping frames folds the tone back at full level; averaging cuts it
+		{"Alias14000", 14000, 0, 0.25},
+		{"Alias15000", 15000, 0, 0.1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := tone(tc.frequency, rate, 0.1)
+			naive := make([]float32, len(input)/factor)
+			for i := range naive {
+				naive[i] = input[i*factor]
+			}
+			prefiltered := Decimate(input, factor, 1)
+			if len(prefiltered) != len(naive) {
+				t.Fatalf("Decimate gave %d frames, want %d", len(prefiltered), len(naive))
+			}
+			if !slices.Equal(Resample(input, rate, rate/factor, 1), prefiltered) {
+				t.Error("Resample doesn't take the Decimate path for an integer ratio")
+			}
+
+			ratio := float64(RMSLevel(prefiltered) / RMSLevel(naive))
+			if ratio < tc.minRatio || ratio > tc.maxRatio {
+				t.Errorf("prefiltered level is %.3f of dropping frames, want %.2f-%.2f", ratio, tc.minRatio, tc.maxRatio)
+			}
+		})
+	}
+
+	// Stereo channels are averaged separately
+	if got := Decimate([]float32{1, -1, 3, -3, 5, -5, 7, -7}, 2, 2); !slices.Equal(got, []float32{2, -2, 6, -6}) {
+		t.Errorf("stereo Decimate = %v, want [2 -2 6 -6]", got)
+	}
+}
+
+func TestValidateResampleRates(t *testing.T) {
+	tests := []struct {
+		name     string
+		fromRate int
+		toRate   int
+		valid    bool
+	}{
+		{"Common", 44100, 48000, true},
+		{"MaxRatioUp", 8000, 64000, true},
+		{"MaxRatioDown", 192000, 24000, true},
+		{"Limits", MinResampleRate, MaxResampleRate / MaxResampleRatio, true},
+		{"Zero", 0, 48000, false},
+		{"BelowMinimum", 4000, 8000, false},
+		{"AboveMaximum", 384000, 192000, false},
+		{"RatioUp", 8000, 96000, false},
+		{"RatioDown", 192000, 16000, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateResampleRates(tc.fromRate, tc.toRate); (err == nil) != tc.valid {
+				t.Errorf("ValidateResampleRates(%d, %d) = %v, want valid %v", tc.fromRate, tc.toRate, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestResampleOutputCap(t *testing.T) {
+	tests := []struct {
+		name     string
+		inFrames int
+		fromRate int
+		toRate   int
+		channels int
+		want     int
+	}{
+		{"UnderCap", 48000, 8000, 64000, 8, 384000},
+		// Ten minutes of 8-channel audio raised eightfold is more than one call may return
+		{"Capped", 600 * 24000, 24000, 192000, 8, maxResampleOutput / 8},
+		{"MonoUnderCap", 400 * 24000, 24000, 192000, 1, 400 * 192000},
+		{"CappedMono", 600 * 24000, 24000, 192000, 1, maxResampleOutput},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := resampledFrames(tc.inFrames, tc.fromRate, tc.toRate, tc.channels); got != tc.want {
+				t.Errorf("resampledFrames = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/gen2brain/malgo"
+)
+
+// Full-scale factors between float samples and signed PCM of each width.
+// As with Int16FullScale, -1.0 maps to the most negative value and positive
+// values are clamped one step below +1.0.
+const (
+	Int24FullScale = 1 << 23
+	Int32FullScale = 1 << 31
+)
+
+// FormatBits returns the bits per sample of a device format, or 0 if it is unknown
+func FormatBits(format malgo.FormatType) int {
+	switch format {
+	case malgo.FormatU8:
+		return 8
+	case malgo.FormatS16:
+		return 16
+	case malgo.FormatS24:
+		return 24
+	case malgo.FormatS32, malgo.FormatF32:
+		return 32
+	}
+	return 0
+}
+
+// FormatName returns a short name for a device format, e.g. "s16" or "f32"
+func FormatName(format malgo.FormatType) string {
+	switch format {
+	case malgo.FormatU8:
+		return "u8"
+	case malgo.FormatS16:
+		return "s16"
+	case malgo.FormatS24:
+		return "s24"
+	case malgo.FormatS32:
+		return "s32"
+	case malgo.FormatF32:
+		return "f32"
+	}
+	return fmt.Sprintf("format(%d)", int(format))
+}
+
+// ValidateOutputBits checks that a PCM output depth is supported by EncodeSamples
+func ValidateOutputBits(bitsPerSample int) error {
+	switch bitsPerSample {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("unsupported bits per sample %d (use 16, 24 or 32)", bitsPerSample)
+}
+
+// DecodeSamples converts little-endian device samples of the given format to floats,
+// reusing dst when it is large enough. Missing trailing bytes decode as silence.
+func DecodeSamples(dst []float32, input []byte, format malgo.FormatType, sampleCount int) []float32 {
+	if format == malgo.FormatF32 {
+		return decodeFloat32(dst, input, sampleCount)
+	}
+
+	if cap(dst) < sampleCount {
+		dst = make([]float32, sampleCount)
+	}
+	samples := dst[:sampleCount]
+
+	width := FormatBits(format) / 8
+	for i := range samples {
+		if width == 0 || (i+1)*width > len(input) {
+			samples[i] = 0
+			continue
+		}
+		b := input[i*width : (i+1)*width]
+
+		switch format {
+		case malgo.FormatU8:
+			samples[i] = (float32(b[0]) - 128) / 128
+		case malgo.FormatS16:
+			samples[i] = Int16ToFloat(int16(binary.LittleEndian.Uint16(b)))
+		case malgo.FormatS24:
+			// Sign-extend the packed 3-byte value through the top of an int32
+			value := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			samples[i] = float32(float64(value) / Int24FullScale)
+		case malgo.FormatS32:
+			samples[i] = float32(float64(int32(binary.LittleEndian.Uint32(b))) / Int32FullScale)
+		}
+	}
+
+	return samples
+}
+
+// SanitizeSamples replaces NaN and infinite samples with silence in place and returns
+// how many it replaced. A glitching device can deliver them, and a single NaN would
+// otherwise spread through every filter and mix it reaches.
+func SanitizeSamples(samples []float32) (cleaned int) {
+	for i, sample := range samples {
+		if sample != sample || sample > math.MaxFloat32 || sample < -math.MaxFloat32 {
+			samples[i] = 0
+			cleaned++
+		}
+	}
+	return cleaned
+}
+
+// EncodeSamples converts float samples to little-endian signed PCM of the given depth,
+// rounding and clamping like FloatToInt16
+func EncodeSamples(samples []float32, bitsPerSample int) []byte {
+	width := bitsPerSample / 8
+	output := make([]byte, len(samples)*width)
+
+	for i, sample := range samples {
+		b := output[i*width : (i+1)*width]
+		switch bitsPerSample {
+		case 16:
+			binary.LittleEndian.PutUint16(b, uint16(FloatToInt16(sample)))
+		case 24:
+			value := uint32(quantize(sample, Int24FullScale))
+			b[0], b[1], b[2] = byte(value), byte(value>>8), byte(value>>16)
+		case 32:
+			binary.LittleEndian.PutUint32(b, uint32(quantize(sample, Int32FullScale)))
+		}
+	}
+
+	return output
+}
+
+// EncodeFloat32Samples converts float samples to little-endian IEEE float bytes without
+// any rounding or clamping
+func EncodeFloat32Samples(samples []float32) []byte {
+	output := make([]byte, len(samples)*4)
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint32(output[i*4:], math.Float32bits(sample))
+	}
+	return output
+}
+
+// quantize scales a float sample to a signed integer of the given full scale, clamping
+// to the representable range
+func quantize(sample float32, fullScale float64) int32 {
+	scaled := math.Round(float64(sample) * fullScale)
+	if scaled > fullScale-1 {
+		return int32(fullScale - 1)
+	}
+	if scaled < -fullScale {
+		return int32(-fullScale)
+	}
+	return int32(scaled)
+}
@@ -0,0 +1,79 @@
+package audio
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSanitizeSamples(t *testing.T) {
+	nan := float32(math.NaN())
+	inf := float32(math.Inf(1))
+
+	tests := []struct {
+		name    string
+		samples []float32
+		want    []float32
+		cleaned int
+	}{
+		{"Empty", nil, nil, 0},
+		{"Clean", []float32{0, 0.5, -1, 1.5}, []float32{0, 0.5, -1, 1.5}, 0},
+		{"NaN", []float32{0.25, nan, -0.25}, []float32{0.25, 0, -0.25}, 1},
+		{"PositiveInf", []float32{inf, 0.5}, []float32{0, 0.5}, 1},
+		{"NegativeInf", []float32{0.5, -inf}, []float32{0.5, 0}, 1},
+		{"All", []float32{nan, inf, -inf, nan}, []float32{0, 0, 0, 0}, 4},
+		// The largest finite values are loud, not invalid; the writer clamps them
+		{"MaxFloat", []float32{math.MaxFloat32, -math.MaxFloat32}, []float32{math.MaxFloat32, -math.MaxFloat32}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			samples := slices.Clone(tc.samples)
+			if cleaned := SanitizeSamples(samples); cleaned != tc.cleaned {
+				t.Errorf("SanitizeSamples cleaned %d, want %d", cleaned, tc.cleaned)
+			}
+			if !slices.Equal(samples, tc.want) {
+				t.Errorf("samples = %v, want %v", samples, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecorderSanitizesCapturedSamples(t *testing.T) {
+	recorder := newTestRecorder(t, nil)
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A glitch in the middle of otherwise clean audio
+	captured := slices.Repeat([]float32{0.25}, 8000)
+	captured[100] = float32(math.NaN())
+	captured[200] = float32(math.Inf(-1))
+	recorder.AddMicSamples(captured, time.Now())
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := recorder.NonFiniteSamples(); n != 2 {
+		t.Errorf("NonFiniteSamples = %d, want 2", n)
+	}
+	samples, _, err := ReadWAV(recorder.GetOutputFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != len(captured) {
+		t.Fatalf("file holds %d samples, want %d", len(samples), len(captured))
+	}
+	// Only the invalid samples became silence; their neighbours are untouched
+	for i, sample := range samples {
+		want := float32(0.25)
+		if i == 100 || i == 200 {
+			want = 0
+		}
+		if math.Abs(float64(sample-want)) > 1e-3 {
+			t.Errorf("sample %d = %v, want %v", i, sample, want)
+			break
+		}
+	}
+}
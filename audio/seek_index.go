@@ -0,0 +1,103 @@
+package audio
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IndexEntry maps a position in a recording to the byte offset of the frame at that
+// position in its WAV file
+type IndexEntry struct {
+	Position   time.Duration
+	ByteOffset int64
+}
+
+// WAVIndexPath returns the path of the seek index written next to a WAV file
+func WAVIndexPath(wavPath string) string {
+	return strings.TrimSuffix(wavPath, filepath.Ext(wavPath)) + ".idx"
+}
+
+// seekIndex writes the seek index sidecar of one output file as it grows. Each line
+// holds a position in seconds and the byte offset of its frame, so a player can jump
+// into a long file without scanning it. Entries are written as soon as the audio
+// they point at is in the file, so the index survives a crash along with the audio.
+type seekIndex struct {
+	file     *os.File
+	interval time.Duration
+	next     time.Duration // Position of the next entry to write
+}
+
+// newSeekIndex creates the index file at path with an entry every interval
+func newSeekIndex(path string, interval time.Duration) (*seekIndex, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &seekIndex{file: file, interval: interval}, nil
+}
+
+// update writes entries for every indexed position the writer's file now holds
+func (x *seekIndex) update(w *wavWriter, sampleRate int) error {
+	blockAlign := int64(w.channels * w.bitsPerSample / 8)
+	frames := (w.fileSize - int64(w.headerSize)) / blockAlign
+
+	for {
+		frame := int64(x.next) * int64(sampleRate) / int64(time.Second)
+		if frame >= frames {
+			return nil
+		}
+		offset := int64(w.headerSize) + frame*blockAlign
+		if _, err := fmt.Fprintf(x.file, "%.3f %d\n", x.next.Seconds(), offset); err != nil {
+			return err
+		}
+		x.next += x.interval
+	}
+}
+
+// close closes the index file
+func (x *seekIndex) close() error {
+	return x.file.Close()
+}
+
+// LoadWAVIndex reads the seek index written next to the WAV file at wavPath. Entries
+// are in order of position; seek to the last one at or before the wanted position
+// and skip forward whole frames from there.
+func LoadWAVIndex(wavPath string) ([]IndexEntry, error) {
+	file, err := os.Open(WAVIndexPath(wavPath))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []IndexEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("seek index line %d: expected position and offset", line)
+		}
+
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("seek index line %d: %w", line, err)
+		}
+		offset, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("seek index line %d: %w", line, err)
+		}
+		entries = append(entries, IndexEntry{
+			Position:   time.Duration(seconds * float64(time.Second)).Round(time.Millisecond),
+			ByteOffset: offset,
+		})
+	}
+
+	return entries, scanner.Err()
+}
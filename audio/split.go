@@ -0,0 +1,40 @@
+package audio
+
+import "fmt"
+
+// SplitChannelsToFiles writes each channel of a WAV file to its own mono WAV file named
+// <outputPrefix>_ch1.wav, <outputPrefix>_ch2.wav and so on, keeping the sample rate,
+// bit depth and any bext timecode. For a stereo-split recording this gives the
+// microphone and speaker sides as separate files. It returns the paths written.
+func SplitChannelsToFiles(inputPath, outputPrefix string) ([]string, error) {
+	samples, header, err := ReadWAV(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", inputPath, err)
+	}
+	if header.Channels < 1 {
+		return nil, fmt.Errorf("reading %s: invalid channel count %d", inputPath, header.Channels)
+	}
+
+	var paths []string
+	for ch, channelSamples := range DeinterleaveChannels(samples, header.Channels) {
+		path := fmt.Sprintf("%s_ch%d.wav", outputPrefix, ch+1)
+		output := wavWriter{filePath: path}
+		err := output.create(WAVHeader{
+			SampleRate:    header.SampleRate,
+			Channels:      1,
+			BitsPerSample: header.BitsPerSample,
+			Float:         header.Float,
+			Bext:          header.Bext,
+			Tags:          header.Tags,
+		})
+		if err == nil {
+			err = output.append(channelSamples)
+		}
+		if err != nil {
+			return paths, fmt.Errorf("writing %s: %w", path, err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
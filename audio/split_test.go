@@ -0,0 +1,94 @@
+package audio
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeWAV writes samples to a finished WAV file at path
+func writeWAV(t *testing.T, path string, header WAVHeader, samples []float32) {
+	t.Helper()
+	w := &wavWriter{filePath: path}
+	if err := w.create(header); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.append(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.finalize(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitChannelsToFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		header WAVHeader
+	}{
+		{"Mono", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16}},
+		{"Stereo16", WAVHeader{SampleRate: 44100, Channels: 2, BitsPerSample: 16}},
+		{"Quad24", WAVHeader{SampleRate: 48000, Channels: 4, BitsPerSample: 24}},
+		{"StereoFloat", WAVHeader{SampleRate: 16000, Channels: 2, BitsPerSample: 32, Float: true}},
+		{"Bext", WAVHeader{SampleRate: 48000, Channels: 2, BitsPerSample: 24,
+			Bext: &BextChunk{Description: "split", OriginationDate: "2024-03-10", TimeReference: 12345}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Each channel carries its own ramp, so a swapped or shifted channel shows
+			const frames = 100
+			samples := make([]float32, frames*tc.header.Channels)
+			for i := range samples {
+				frame, ch := i/tc.header.Channels, i%tc.header.Channels
+				samples[i] = float32(ch+1) * float32(frame-frames/2) / (frames * 5)
+			}
+			dir := t.TempDir()
+			input := filepath.Join(dir, "input.wav")
+			writeWAV(t, input, tc.header, samples)
+
+			paths, err := SplitChannelsToFiles(input, filepath.Join(dir, "split"))
+			if err != nil {
+				t.Fatalf("SplitChannelsToFiles: %v", err)
+			}
+			if len(paths) != tc.header.Channels {
+				t.Fatalf("wrote %d files, want one per channel (%d)", len(paths), tc.header.Channels)
+			}
+
+			// Each file holds its channel exactly as it was stored, in the input's format
+			stored, _, err := ReadWAV(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			channels := DeinterleaveChannels(stored, tc.header.Channels)
+			for ch, path := range paths {
+				if want := filepath.Join(dir, fmt.Sprintf("split_ch%d.wav", ch+1)); path != want {
+					t.Errorf("channel %d written to %s, want %s", ch+1, filepath.Base(path), filepath.Base(want))
+				}
+				got, header, err := ReadWAV(path)
+				if err != nil {
+					t.Fatalf("ReadWAV(%s): %v", filepath.Base(path), err)
+				}
+				if !slices.Equal(got, channels[ch]) {
+					t.Errorf("channel %d samples differ from the input's", ch+1)
+				}
+				if header.Channels != 1 || header.SampleRate != tc.header.SampleRate ||
+					header.BitsPerSample != tc.header.BitsPerSample || header.Float != tc.header.Float {
+					t.Errorf("channel %d format = %s, want mono %s", ch+1, describeFormat(header), describeFormat(tc.header))
+				}
+				if (header.Bext != nil) != (tc.header.Bext != nil) ||
+					header.Bext != nil && header.Bext.TimeReference != tc.header.Bext.TimeReference {
+					t.Errorf("channel %d bext = %+v, want %+v", ch+1, header.Bext, tc.header.Bext)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitChannelsToFilesMissingInput(t *testing.T) {
+	dir := t.TempDir()
+	paths, err := SplitChannelsToFiles(filepath.Join(dir, "missing.wav"), filepath.Join(dir, "split"))
+	if err == nil || len(paths) != 0 {
+		t.Errorf("SplitChannelsToFiles = %v, %v; want an error and no files", paths, err)
+	}
+}
@@ -32,14 +32,10 @@ func TimeSyncMixAudioSamples(samples1 []float32, timestamp1 time.Time,
 		laterTimestamp = timestamp1
 	}
 
-	// Calculate time offset in milliseconds
-	timeDiffMs := laterTimestamp.Sub(refTimestamp).Milliseconds()
+	// Offset the later stream by whole frames so channels stay aligned, as AlignStreams does
+	offsetSamples := int(laterTimestamp.Sub(refTimestamp).Seconds()*float64(sampleRate)) * channels
 
-	// Calculate offset in samples
-	samplesPerMs := float64(sampleRate*channels) / 1000.0
-	offsetSamples := int(float64(timeDiffMs) * samplesPerMs)
-
-	// For very small offsets (less than 1ms), just do a simple mix
+	// Within a frame of each other, just do a simple mix
 	if offsetSamples <= 0 {
 		return MixAudioSamples(samples1, samples2), refTimestamp
 	}
@@ -72,3 +68,268 @@ func TimeSyncMixAudioSamples(samples1 []float32, timestamp1 time.Time,
 
 	return mixed, refTimestamp
 }
+
+// AlignStreams places two sample arrays on a shared timeline starting at the earlier timestamp.
+// Both returned arrays have the same length, with silence where a stream has no data.
+func AlignStreams(samples1 []float32, timestamp1 time.Time,
+	samples2 []float32, timestamp2 time.Time,
+	sampleRate, channels int) ([]float32, []float32, time.Time) {
+	if len(samples1) == 0 && len(samples2) == 0 {
+		return nil, nil, time.Time{}
+	}
+	if len(samples1) == 0 {
+		return make([]float32, len(samples2)), samples2, timestamp2
+	}
+	if len(samples2) == 0 {
+		return samples1, make([]float32, len(samples1)), timestamp1
+	}
+
+	// Offset each stream by whole frames so channels stay aligned
+	startTime := timestamp1
+	if timestamp2.Before(startTime) {
+		startTime = timestamp2
+	}
+	offset1 := int(timestamp1.Sub(startTime).Seconds()*float64(sampleRate)) * channels
+	offset2 := int(timestamp2.Sub(startTime).Seconds()*float64(sampleRate)) * channels
+
+	totalLength := offset1 + len(samples1)
+	if offset2+len(samples2) > totalLength {
+		totalLength = offset2 + len(samples2)
+	}
+
+	aligned1 := make([]float32, totalLength)
+	aligned2 := make([]float32, totalLength)
+	copy(aligned1[offset1:], samples1)
+	copy(aligned2[offset2:], samples2)
+
+	return aligned1, aligned2, startTime
+}
+
+// TimeSyncMixWeighted mixes microphone and speaker samples using per-source weights
+func TimeSyncMixWeighted(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time,
+	sampleRate, channels int, micWeight, speakerWeight float32) ([]float32, time.Time) {
+	mic, speaker, timestamp := AlignStreams(micSamples, micTimestamp,
+		speakerSamples, speakerTimestamp, sampleRate, channels)
+
+	mixed := make([]float32, len(mic))
+	for i := range mixed {
+		mixed[i] = clampSample(mic[i]*micWeight + speaker[i]*speakerWeight)
+	}
+
+	return mixed, timestamp
+}
+
+// TimeSyncMixSumLimit sums microphone and speaker samples at full level and limits the result
+func TimeSyncMixSumLimit(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time,
+	sampleRate, channels int) ([]float32, time.Time) {
+	mic, speaker, timestamp := AlignStreams(micSamples, micTimestamp,
+		speakerSamples, speakerTimestamp, sampleRate, channels)
+
+	mixed := make([]float32, len(mic))
+	for i := range mixed {
+		mixed[i] = clampSample(mic[i] + speaker[i])
+	}
+
+	return mixed, timestamp
+}
+
+// TimeSyncStereoSplit places the microphone on the left channel and the speaker on the right.
+// Each source is downmixed to mono first, so the result always has two channels.
+func TimeSyncStereoSplit(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time,
+	sampleRate, channels int) ([]float32, time.Time) {
+	return TimeSyncStereoSplitWeighted(micSamples, micTimestamp, speakerSamples, speakerTimestamp,
+		sampleRate, channels, nil)
+}
+
+// TimeSyncStereoSplitWeighted is TimeSyncStereoSplit with each source downmixed using
+// per-channel weights (see DownmixWeighted); no weights averages the channels
+func TimeSyncStereoSplitWeighted(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time,
+	sampleRate, channels int, weights []float32) ([]float32, time.Time) {
+	mic, speaker, timestamp := AlignStreams(micSamples, micTimestamp,
+		speakerSamples, speakerTimestamp, sampleRate, channels)
+
+	return InterleaveStereo(downmix(mic, channels, weights), downmix(speaker, channels, weights)), timestamp
+}
+
+// TimeSyncChannelMap lays microphone and speaker out on the output channels given by
+// the channel map, downmixing each source to mono with the weights first (see
+// InterleaveMapped). The result has one channel per channel map entry.
+func TimeSyncChannelMap(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time,
+	sampleRate, channels int, weights []float32, channelMap []ChannelSource) ([]float32, time.Time) {
+	mic, speaker, timestamp := AlignStreams(micSamples, micTimestamp,
+		speakerSamples, speakerTimestamp, sampleRate, channels)
+
+	return InterleaveMapped(downmix(mic, channels, weights), downmix(speaker, channels, weights), channelMap), timestamp
+}
+
+// TimeSyncMultitrack lays microphone and speaker side by side without mixing them:
+// each output frame holds the microphone's channels followed by the speaker's, so
+// both keep their own stereo image (see InterleaveTracks). The result has twice
+// the input channels.
+func TimeSyncMultitrack(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time,
+	sampleRate, channels int) ([]float32, time.Time) {
+	mic, speaker, timestamp := AlignStreams(micSamples, micTimestamp,
+		speakerSamples, speakerTimestamp, sampleRate, channels)
+
+	return InterleaveTracks(mic, speaker, channels), timestamp
+}
+
+// duckWindowMs is the length of the window used to detect microphone activity when ducking
+const duckWindowMs = 10
+
+// TimeSyncMixDuck sums microphone and speaker samples, attenuating the speaker
+// to duckLevel wherever the microphone level exceeds threshold
+func TimeSyncMixDuck(micSamples []float32, micTimestamp time.Time,
+	speakerSamples []float32, speakerTimestamp time.Time,
+	sampleRate, channels int, threshold, duckLevel float32) ([]float32, time.Time) {
+	mic, speaker, timestamp := AlignStreams(micSamples, micTimestamp,
+		speakerSamples, speakerTimestamp, sampleRate, channels)
+
+	windowSize := sampleRate * duckWindowMs / 1000 * channels
+	if windowSize < channels {
+		windowSize = channels
+	}
+
+	mixed := make([]float32, len(mic))
+	gain := float32(1)
+	for start := 0; start < len(mic); start += windowSize {
+		end := start + windowSize
+		if end > len(mic) {
+			end = len(mic)
+		}
+
+		// Measure mic activity in this window
+		targetGain := float32(1)
+		if MeanAbsLevel(mic[start:end]) >= threshold {
+			targetGain = duckLevel
+		}
+
+		// Ramp the gain across the window to avoid clicks
+		step := (targetGain - gain) / float32(end-start)
+		for i := start; i < end; i++ {
+			gain += step
+			mixed[i] = clampSample(mic[i] + speaker[i]*gain)
+		}
+		gain = targetGain
+	}
+
+	return mixed, timestamp
+}
+
+// TimedStream is one input to TimeSyncMixN: samples captured starting at Timestamp,
+// scaled by Gain when mixed
+type TimedStream struct {
+	Samples   []float32
+	Timestamp time.Time
+	Gain      float32
+}
+
+// TimeSyncMixN places any number of streams on a shared timeline starting at the
+// earliest timestamp and sums them with their gains, limiting the result.
+// All streams must share the sample rate and channel layout.
+func TimeSyncMixN(streams []TimedStream, sampleRate, channels int) ([]float32, time.Time) {
+	offsets, totalLength, startTime := alignTimedStreams(streams, sampleRate, channels)
+	if totalLength == 0 {
+		return nil, startTime
+	}
+
+	mixed := make([]float32, totalLength)
+	for i, stream := range streams {
+		for j, sample := range stream.Samples {
+			mixed[offsets[i]+j] += sample * stream.Gain
+		}
+	}
+	for i := range mixed {
+		mixed[i] = clampSample(mixed[i])
+	}
+
+	return mixed, startTime
+}
+
+// TimeSyncMixNHighPrecision is TimeSyncMixN with the sum accumulated in float64 and
+// rounded to float32 once at the end. With many streams the float32 sum rounds after
+// every addition and the errors add up; this keeps the mix within one rounding of
+// the exact result, at the cost of a float64 buffer the length of the mix.
+func TimeSyncMixNHighPrecision(streams []TimedStream, sampleRate, channels int) ([]float32, time.Time) {
+	offsets, totalLength, startTime := alignTimedStreams(streams, sampleRate, channels)
+	if totalLength == 0 {
+		return nil, startTime
+	}
+
+	sums := make([]float64, totalLength)
+	for i, stream := range streams {
+		gain := float64(stream.Gain)
+		for j, sample := range stream.Samples {
+			sums[offsets[i]+j] += float64(sample) * gain
+		}
+	}
+	mixed := make([]float32, totalLength)
+	for i, sum := range sums {
+		mixed[i] = clampSample(float32(sum))
+	}
+
+	return mixed, startTime
+}
+
+// alignTimedStreams returns where each stream starts on the shared timeline of
+// TimeSyncMixN, in samples, the timeline's length and its start time.
+// The length is 0 when no stream has samples.
+func alignTimedStreams(streams []TimedStream, sampleRate, channels int) ([]int, int, time.Time) {
+	var startTime time.Time
+	active := 0
+	for _, stream := range streams {
+		if len(stream.Samples) == 0 {
+			continue
+		}
+		if active == 0 || stream.Timestamp.Before(startTime) {
+			startTime = stream.Timestamp
+		}
+		active++
+	}
+	if active == 0 {
+		return nil, 0, time.Time{}
+	}
+
+	// Offset each stream by whole frames so channels stay aligned
+	offsets := make([]int, len(streams))
+	totalLength := 0
+	for i, stream := range streams {
+		if len(stream.Samples) == 0 {
+			continue
+		}
+		offsets[i] = int(stream.Timestamp.Sub(startTime).Seconds()*float64(sampleRate)) * channels
+		if end := offsets[i] + len(stream.Samples); end > totalLength {
+			totalLength = end
+		}
+	}
+
+	return offsets, totalLength, startTime
+}
+
+// LevelToCeiling returns a mix scaled so full scale becomes ceiling, with anything
+// louder limited to the ceiling. A single full-scale source and a sum of several that
+// went past full scale both peak at exactly the ceiling.
+func LevelToCeiling(samples []float32, ceiling float32) []float32 {
+	leveled := make([]float32, len(samples))
+	for i, sample := range samples {
+		leveled[i] = clampSample(sample) * ceiling
+	}
+	return leveled
+}
+
+// clampSample limits a sample to the valid [-1, 1] range
+func clampSample(sample float32) float32 {
+	if sample > 1 {
+		return 1
+	}
+	if sample < -1 {
+		return -1
+	}
+	return sample
+}
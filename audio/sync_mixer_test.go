@@ -0,0 +1,389 @@
+package audio
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testRate = 8000
+
+// sine returns a second of a mono sine tone; frequencies that divide testRate give
+// whole cycles, so tones of different frequencies are uncorrelated
+func sine(frequency float64, amplitude float32) []float32 {
+	samples := make([]float32, testRate)
+	NewToneGenerator(frequency, amplitude, testRate, 1).Fill(samples)
+	return samples
+}
+
+// sineRMS is the RMS level of a sine tone with the given peak
+func sineRMS(amplitude float64) float64 {
+	return amplitude / math.Sqrt2
+}
+
+// near reports whether a level is within 1% of the expected one
+func near(got float32, want float64) bool {
+	return math.Abs(float64(got)-want) <= 0.01*want+1e-6
+}
+
+// assertRMSNear fails the test unless the RMS level of samples is within 1% of want,
+// naming what was measured, and reports whether it was
+func assertRMSNear(t *testing.T, what string, samples []float32, want float64) bool {
+	t.Helper()
+	if level := RMSLevel(samples); !near(level, want) {
+		t.Errorf("%s RMS = %.4f, want %.4f", what, level, want)
+		return false
+	}
+	return true
+}
+
+func TestMixModesEnergy(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	mic := sine(1000, 0.5)
+	speaker := sine(250, 0.5)
+
+	// Uncorrelated tones add in power: a*mic + b*speaker has RMS sqrt(a²+b²)·sineRMS(0.5)
+	combined := func(a, b float64) float64 { return math.Sqrt(a*a+b*b) * sineRMS(0.5) }
+
+	tests := []struct {
+		name string
+		mix  func() ([]float32, time.Time)
+		want float64
+	}{
+		{"Average", func() ([]float32, time.Time) {
+			return TimeSyncMixAudioSamples(mic, start, speaker, start, testRate, 1)
+		}, combined(0.5, 0.5)},
+		{"SumLimit", func() ([]float32, time.Time) {
+			return TimeSyncMixSumLimit(mic, start, speaker, start, testRate, 1)
+		}, combined(1, 1)},
+		{"Weighted", func() ([]float32, time.Time) {
+			return TimeSyncMixWeighted(mic, start, speaker, start, testRate, 1, 0.75, 0.25)
+		}, combined(0.75, 0.25)},
+		{"DuckActive", func() ([]float32, time.Time) {
+			return TimeSyncMixDuck(mic, start, speaker, start, testRate, 1, 0.1, 0.25)
+		}, combined(1, 0.25)},
+		{"DuckSilent", func() ([]float32, time.Time) {
+			return TimeSyncMixDuck(make([]float32, len(mic)), start, speaker, start, testRate, 1, 0.1, 0.25)
+		}, combined(0, 1)},
+		{"MixN", func() ([]float32, time.Time) {
+			return TimeSyncMixN([]TimedStream{
+				{Samples: mic, Timestamp: start, Gain: 0.5},
+				{Samples: speaker, Timestamp: start, Gain: 1},
+				{Samples: sine(2000, 0.5), Timestamp: start, Gain: 0.5},
+			}, testRate, 1)
+		}, math.Sqrt(0.25+1+0.25) * sineRMS(0.5)},
+		{"MixNHighPrecision", func() ([]float32, time.Time) {
+			return TimeSyncMixNHighPrecision([]TimedStream{
+				{Samples: mic, Timestamp: start, Gain: 1},
+				{Samples: speaker, Timestamp: start, Gain: 1},
+			}, testRate, 1)
+		}, combined(1, 1)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mixed, timestamp := tc.mix()
+			if len(mixed) != len(mic) {
+				t.Fatalf("mix has %d samples, want %d", len(mixed), len(mic))
+			}
+			if !timestamp.Equal(start) {
+				t.Errorf("timestamp = %v, want %v", timestamp, start)
+			}
+			assertRMSNear(t, "mix", mixed, tc.want)
+		})
+	}
+}
+
+func TestMixLimitsToFullScale(t *testing.T) {
+	start := time.Now()
+	loud := sine(1000, 0.8)
+
+	tests := []struct {
+		name string
+		mix  func() ([]float32, time.Time)
+	}{
+		{"SumLimit", func() ([]float32, time.Time) {
+			return TimeSyncMixSumLimit(loud, start, loud, start, testRate, 1)
+		}},
+		{"Weighted", func() ([]float32, time.Time) {
+			return TimeSyncMixWeighted(loud, start, loud, start, testRate, 1, 1, 1)
+		}},
+		{"MixN", func() ([]float32, time.Time) {
+			return TimeSyncMixN([]TimedStream{{loud, start, 1}, {loud, start, 1}}, testRate, 1)
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mixed, _ := tc.mix()
+			if peak := PerChannelPeak(mixed, 1)[0]; peak != 1 {
+				t.Errorf("peak = %v, want the sum limited to 1", peak)
+			}
+		})
+	}
+}
+
+func TestStereoSplitEnergy(t *testing.T) {
+	start := time.Now()
+	mic := sine(1000, 0.5)
+	speaker := sine(250, 0.25)
+
+	mixed, _ := TimeSyncStereoSplit(mic, start, speaker, start, testRate, 1)
+	if len(mixed) != 2*len(mic) {
+		t.Fatalf("split has %d samples, want %d", len(mixed), 2*len(mic))
+	}
+	channels := DeinterleaveChannels(mixed, 2)
+	assertRMSNear(t, "left channel", channels[0], sineRMS(0.5))
+	assertRMSNear(t, "right channel", channels[1], sineRMS(0.25))
+	if !slices.Equal(channels[0], mic) || !slices.Equal(channels[1], speaker) {
+		t.Error("split does not carry the microphone left and the speaker right unchanged")
+	}
+}
+
+func TestMixOffsetAlignment(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	mic := sine(1000, 0.5)
+	speaker := sine(250, 0.5)
+
+	tests := []struct {
+		name     string
+		offset   time.Duration
+		channels int
+		want     int // Samples the later stream is shifted by
+	}{
+		{"Mono", 100 * time.Millisecond, 1, 800},
+		{"Stereo", 100 * time.Millisecond, 2, 1600},
+		// Part of a frame is dropped so channels stay aligned
+		{"FractionalFrame", 1500 * time.Microsecond / 8, 2, 2},
+		{"SubFrame", 100 * time.Microsecond, 2, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			later := start.Add(tc.offset)
+			mixers := map[string]struct {
+				mix  func() ([]float32, time.Time)
+				gain float64 // Weight of each stream where they overlap
+			}{
+				"Average": {func() ([]float32, time.Time) {
+					return TimeSyncMixAudioSamples(mic, start, speaker, later, testRate, tc.channels)
+				}, 0.5},
+				"SumLimit": {func() ([]float32, time.Time) {
+					return TimeSyncMixSumLimit(mic, start, speaker, later, testRate, tc.channels)
+				}, 1},
+				// The later stream first swaps order: it must still be shifted
+				"SumLimitSwapped": {func() ([]float32, time.Time) {
+					return TimeSyncMixSumLimit(speaker, later, mic, start, testRate, tc.channels)
+				}, 1},
+				"MixN": {func() ([]float32, time.Time) {
+					return TimeSyncMixN([]TimedStream{{speaker, later, 1}, {mic, start, 1}}, testRate, tc.channels)
+				}, 1},
+			}
+			for name, mixer := range mixers {
+				mixed, timestamp := mixer.mix()
+				if !timestamp.Equal(start) {
+					t.Errorf("%s: timestamp = %v, want the earlier start %v", name, timestamp, start)
+				}
+				if len(mixed) != len(speaker)+tc.want {
+					t.Errorf("%s: mix has %d samples, want %d", name, len(mixed), len(speaker)+tc.want)
+					continue
+				}
+				// Before the later stream starts, the mix is the earlier stream alone
+				if !slices.Equal(mixed[:tc.want], mic[:tc.want]) {
+					t.Errorf("%s: mix before the offset is not the earlier stream", name)
+				}
+				// After the earlier stream ends, it is the later stream alone
+				if !slices.Equal(mixed[len(mic):], speaker[len(speaker)-tc.want:]) {
+					t.Errorf("%s: mix after the earlier stream is not the later stream", name)
+				}
+				// Where they overlap, both streams are there at the mode's weight: a stream
+				// shifted by the wrong amount or dropped would change the level
+				assertRMSNear(t, name+" overlap", mixed[tc.want:len(mic)],
+					math.Sqrt2*mixer.gain*sineRMS(0.5))
+			}
+		})
+	}
+}
+
+func TestMixEmptyStream(t *testing.T) {
+	start := time.Now()
+	mic := sine(1000, 0.5)
+
+	mixed, timestamp := TimeSyncMixAudioSamples(mic, start, nil, start.Add(-time.Second), testRate, 1)
+	if !slices.Equal(mixed, mic) || !timestamp.Equal(start) {
+		t.Error("mixing with an empty stream does not return the other stream unchanged")
+	}
+	if mixed, _ := TimeSyncMixN([]TimedStream{{nil, start, 1}}, testRate, 1); mixed != nil {
+		t.Errorf("mixing only empty streams gave %d samples", len(mixed))
+	}
+}
+
+func TestMixNHighPrecision(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Sixteen microphones at awkward gains, as a large panel would be mixed, each
+	// starting a little later than the one before
+	var streams []TimedStream
+	for i := range 16 {
+		samples := make([]float32, testRate)
+		for j := range samples {
+			samples[j] = float32(0.9 * math.Sin(float64(j)*0.01*float64(i+1)+float64(i)))
+		}
+		streams = append(streams, TimedStream{
+			Samples:   samples,
+			Timestamp: start.Add(time.Duration(i) * time.Second / testRate),
+			Gain:      float32(1) / float32(i+7),
+		})
+	}
+
+	// The reference sums in float64 from the same float32 inputs and gains
+	offsets, length, _ := alignTimedStreams(streams, testRate, 1)
+	reference := make([]float64, length)
+	for i, stream := range streams {
+		for j, sample := range stream.Samples {
+			reference[offsets[i]+j] += float64(sample) * float64(stream.Gain)
+		}
+	}
+
+	// totalError sums how far a mix strays from the reference
+	totalError := func(mixed []float32) float64 {
+		if len(mixed) != length {
+			t.Fatalf("mix has %d samples, want %d", len(mixed), length)
+		}
+		total := 0.0
+		for i, sample := range mixed {
+			total += math.Abs(float64(sample) - reference[i])
+		}
+		return total
+	}
+
+	precise, _ := TimeSyncMixNHighPrecision(streams, testRate, 1)
+	plain, _ := TimeSyncMixN(streams, testRate, 1)
+
+	// The high precision mix rounds once, so each sample is the reference rounded to
+	// float32; the plain mix rounds after every addition and strays further
+	for i, sample := range precise {
+		if sample != float32(reference[i]) {
+			t.Fatalf("high precision sample %d = %v, want the reference rounded once, %v",
+				i, sample, float32(reference[i]))
+		}
+	}
+	preciseError, plainError := totalError(precise), totalError(plain)
+	if preciseError >= plainError {
+		t.Errorf("high precision error %g is not below the float32 error %g", preciseError, plainError)
+	}
+}
+
+// benchmarkMix runs mix over one second of 48 kHz stereo from each source, the
+// speaker starting 5ms after the microphone so the streams need aligning
+func benchmarkMix(b *testing.B, mix func(mic, speaker []float32, micStart, speakerStart time.Time)) {
+	const rate, channels = 48000, 2
+	mic := make([]float32, rate*channels)
+	NewToneGenerator(1000, 0.5, rate, channels).Fill(mic)
+	speaker := make([]float32, rate*channels)
+	NewToneGenerator(250, 0.5, rate, channels).Fill(speaker)
+	micStart := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	speakerStart := micStart.Add(5 * time.Millisecond)
+
+	b.SetBytes(int64(len(mic)+len(speaker)) * 4)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		mix(mic, speaker, micStart, speakerStart)
+	}
+}
+
+func BenchmarkTimeSyncMixAudioSamples(b *testing.B) {
+	benchmarkMix(b, func(mic, speaker []float32, micStart, speakerStart time.Time) {
+		TimeSyncMixAudioSamples(mic, micStart, speaker, speakerStart, 48000, 2)
+	})
+}
+
+func BenchmarkTimeSyncMixWeighted(b *testing.B) {
+	benchmarkMix(b, func(mic, speaker []float32, micStart, speakerStart time.Time) {
+		TimeSyncMixWeighted(mic, micStart, speaker, speakerStart, 48000, 2, 0.75, 0.25)
+	})
+}
+
+func BenchmarkTimeSyncMixDuck(b *testing.B) {
+	benchmarkMix(b, func(mic, speaker []float32, micStart, speakerStart time.Time) {
+		TimeSyncMixDuck(mic, micStart, speaker, speakerStart, 48000, 2, 0.1, 0.25)
+	})
+}
+
+func BenchmarkTimeSyncMixN(b *testing.B) {
+	for _, tc := range []struct {
+		name    string
+		precise bool
+	}{{"Float32", false}, {"Float64", true}} {
+		b.Run(tc.name, func(b *testing.B) {
+			benchmarkMix(b, func(mic, speaker []float32, micStart, speakerStart time.Time) {
+				streams := []TimedStream{
+					{Samples: mic, Timestamp: micStart, Gain: 0.5},
+					{Samples: speaker, Timestamp: speakerStart, Gain: 0.5},
+				}
+				if tc.precise {
+					TimeSyncMixNHighPrecision(streams, 48000, 2)
+				} else {
+					TimeSyncMixN(streams, 48000, 2)
+				}
+			})
+		})
+	}
+}
+
+func TestMixTargetPeak(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       float32
+		headroomDB   float32
+		speaker      bool
+		micLevel     float32 // Constant level of each source
+		speakerLevel float32
+		wantPeak     float64
+	}{
+		{"SingleFullScale", 0.5, 0, false, 1, 0, 0.5},
+		{"SingleHalfScale", 0.5, 0, false, 0.5, 0, 0.25},
+		{"DualFullScale", 0.5, 0, true, 1, 1, 0.5},
+		{"DualSumLimited", 0.5, 0, true, 0.75, 0.75, 0.5},
+		{"DualBelowFullScale", 0.5, 0, true, 0.25, 0.5, 0.375},
+		{"Headroom", 1, 6, true, 1, 1, math.Pow(10, -6.0/20)},
+		{"TargetAndHeadroom", 0.8, 20, false, 1, 0, 0.08},
+		// At full scale the default average applies, halving overlapping sources
+		{"FullScaleAverage", 0, 0, true, 1, 1, 1},
+		{"FullScaleAverageHalves", 0, 0, true, 0.5, 0.25, 0.375},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder(t, func(config *RecordingConfig) {
+				config.MixTargetPeak = tc.target
+				config.MixHeadroomDB = tc.headroomDB
+				config.FloatWAV = true
+				config.BitsPerSample = 32
+			})
+			if !tc.speaker {
+				recorder.DisableSpeaker()
+			}
+			if err := recorder.StartRecording(); err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			recorder.AddMicSamples(slices.Repeat([]float32{tc.micLevel}, testRate), start)
+			if tc.speaker {
+				recorder.AddSpeakerSamples(slices.Repeat([]float32{tc.speakerLevel}, testRate), start)
+			}
+			if err := recorder.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			samples, _, err := ReadWAV(recorder.GetOutputFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(samples) != testRate {
+				t.Fatalf("file holds %d samples, want %d", len(samples), testRate)
+			}
+			if peak := PerChannelPeak(samples, 1)[0]; math.Abs(float64(peak)-tc.wantPeak) > 1e-6 {
+				t.Errorf("output peak = %v, want %v", peak, tc.wantPeak)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ValidateTags checks that tags can be stored and read back: keys must be non-empty
+// and free of '=', and neither keys nor values may contain line breaks or NUL bytes.
+func ValidateTags(tags map[string]string) error {
+	for key, value := range tags {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("tag with empty key")
+		}
+		if strings.ContainsAny(key, "=\r\n\x00") {
+			return fmt.Errorf("tag key %q must not contain '=', line breaks or NUL", key)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("tag %q value must not contain line breaks or NUL", key)
+		}
+	}
+	return nil
+}
+
+// FormatTags returns the tags as key=value lines sorted by key
+func FormatTags(tags map[string]string) string {
+	var text strings.Builder
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		fmt.Fprintf(&text, "%s=%s\n", key, tags[key])
+	}
+	return text.String()
+}
+
+// ParseTags reads key=value lines written by FormatTags. Lines without '=' are skipped.
+func ParseTags(text string) map[string]string {
+	tags := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if found && key != "" {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// infoChunk encodes tags as a complete LIST/INFO chunk, or returns nil without tags.
+// The tags go in the comment (ICMT) field as FormatTags lines, which common tools show
+// as the file's comment.
+func infoChunk(tags map[string]string) []byte {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	// The comment is NUL-terminated and padded to an even size
+	comment := []byte(FormatTags(tags) + "\x00")
+	if len(comment)%2 == 1 {
+		comment = append(comment, 0)
+	}
+
+	var chunk bytes.Buffer
+	chunk.WriteString("LIST")
+	binary.Write(&chunk, binary.LittleEndian, uint32(4+chunkHeaderSize+len(comment)))
+	chunk.WriteString("INFO")
+	chunk.WriteString("ICMT")
+	binary.Write(&chunk, binary.LittleEndian, uint32(len(comment)))
+	chunk.Write(comment)
+	return chunk.Bytes()
+}
+
+// parseInfoChunk reads the tags from the body of a LIST chunk. Lists other than INFO,
+// and INFO lists without a comment, have no tags.
+func parseInfoChunk(chunk []byte) map[string]string {
+	if len(chunk) < 4 || string(chunk[0:4]) != "INFO" {
+		return nil
+	}
+
+	for offset := 4; offset+chunkHeaderSize <= len(chunk); {
+		id := string(chunk[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(chunk[offset+4 : offset+8]))
+		start := offset + chunkHeaderSize
+		if start+size > len(chunk) {
+			return nil
+		}
+		if id == "ICMT" {
+			return ParseTags(string(bytes.TrimRight(chunk[start:start+size], "\x00")))
+		}
+		offset = start + size + size%2
+	}
+	return nil
+}
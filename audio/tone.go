@@ -0,0 +1,130 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// ToneGenerator produces a continuous sine tone, keeping its phase between calls
+// so consecutive blocks join without clicks
+type ToneGenerator struct {
+	Frequency  float64 // Tone frequency in Hz
+	Amplitude  float32 // Peak level (0-1)
+	sampleRate int
+	channels   int
+	phase      float64
+}
+
+// NewToneGenerator creates a tone generator for interleaved output in the given format
+func NewToneGenerator(frequency float64, amplitude float32, sampleRate, channels int) *ToneGenerator {
+	return &ToneGenerator{
+		Frequency:  frequency,
+		Amplitude:  amplitude,
+		sampleRate: sampleRate,
+		channels:   channels,
+	}
+}
+
+// Fill writes the next frames of the tone into samples, the same value on every channel
+func (g *ToneGenerator) Fill(samples []float32) {
+	step := 2 * math.Pi * g.Frequency / float64(g.sampleRate)
+	for frame := 0; frame < len(samples)/g.channels; frame++ {
+		value := g.Amplitude * float32(math.Sin(g.phase))
+		for ch := 0; ch < g.channels; ch++ {
+			samples[frame*g.channels+ch] = value
+		}
+		g.phase = math.Mod(g.phase+step, 2*math.Pi)
+	}
+}
+
+// toneFade is how long a tone burst fades in and out, so it starts and ends without a click
+const toneFade = 5 * time.Millisecond
+
+// ToneBurst returns a tone of the given length as interleaved samples, faded in and
+// out so its edges don't click
+func ToneBurst(frequency float64, amplitude float32, duration time.Duration, sampleRate, channels int) []float32 {
+	frames := int(int64(duration) * int64(sampleRate) / int64(time.Second))
+	samples := make([]float32, frames*channels)
+	NewToneGenerator(frequency, amplitude, sampleRate, channels).Fill(samples)
+
+	fadeFrames := min(int(int64(toneFade)*int64(sampleRate)/int64(time.Second)), frames/2)
+	for i := 0; i < fadeFrames; i++ {
+		gain := float32(i) / float32(fadeFrames)
+		for ch := 0; ch < channels; ch++ {
+			samples[i*channels+ch] *= gain
+			samples[(frames-1-i)*channels+ch] *= gain
+		}
+	}
+
+	return samples
+}
+
+// TonePlayer plays a tone on a playback device until stopped
+type TonePlayer struct {
+	device    *malgo.Device
+	generator *ToneGenerator
+	samples   []float32
+	mutex     sync.Mutex
+}
+
+// NewTonePlayer initializes the default playback device to play the generator's tone
+func NewTonePlayer(ctx malgo.Context, generator *ToneGenerator) (*TonePlayer, error) {
+	p := &TonePlayer{generator: generator}
+
+	deviceConfig := malgo.DeviceConfig{
+		DeviceType: malgo.Playback,
+		SampleRate: uint32(generator.sampleRate),
+		Playback: malgo.SubConfig{
+			Format:   malgo.FormatF32,
+			Channels: uint32(generator.channels),
+		},
+	}
+
+	device, err := malgo.InitDevice(ctx, deviceConfig, malgo.DeviceCallbacks{
+		Data: p.dataCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.device = device
+
+	return p, nil
+}
+
+// Start starts playing the tone
+func (p *TonePlayer) Start() error {
+	return p.device.Start()
+}
+
+// Stop stops playback
+func (p *TonePlayer) Stop() error {
+	return p.device.Stop()
+}
+
+// Uninit releases the underlying device
+func (p *TonePlayer) Uninit() {
+	p.device.Uninit()
+}
+
+// dataCallback fills the device output with the next block of the tone
+func (p *TonePlayer) dataCallback(output, input []byte, frameCount uint32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	count := int(frameCount) * p.generator.channels
+	if cap(p.samples) < count {
+		p.samples = make([]float32, count)
+	}
+	samples := p.samples[:count]
+	p.generator.Fill(samples)
+
+	for i, sample := range samples {
+		if i*4+3 < len(output) {
+			binary.LittleEndian.PutUint32(output[i*4:i*4+4], math.Float32bits(sample))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package audio
+
+import "time"
+
+// speechWindow is the length of the windows SpeechDetector measures the level of
+const speechWindow = 20 * time.Millisecond
+
+// Region is a stretch of detected speech, as offsets from the start of the analyzed audio
+type Region struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// SpeechEdge is a change between silence and speech found by SpeechDetector
+type SpeechEdge struct {
+	Speech bool      // True where speech starts, false where it ends
+	Time   time.Time // Capture time of the first voiced frame, or just past the last one
+}
+
+// SpeechDetector is a lightweight energy-based voice activity detector for a stream.
+// It measures the RMS level of short windows: windows at or above the threshold are
+// voiced, and speech ends once minSilence passes without a voiced window, so pauses
+// between words don't split a sentence. It tells speech from quiet, not from other
+// loud sounds.
+type SpeechDetector struct {
+	channels     int
+	windowFrames int
+	threshold    float32
+	minSilence   time.Duration
+	sampleRate   int
+	pending      []float32 // Start of a window that the next Feed completes
+	pendingTime  time.Time // Capture time of the first pending frame
+	speaking     bool
+	lastVoiced   time.Time // End of the latest voiced window
+}
+
+// NewSpeechDetector creates a detector for interleaved audio in the given format
+func NewSpeechDetector(sampleRate, channels int, threshold float32, minSilence time.Duration) *SpeechDetector {
+	return &SpeechDetector{
+		channels:     channels,
+		windowFrames: max(int(int64(speechWindow)*int64(sampleRate)/int64(time.Second)), 1),
+		threshold:    threshold,
+		minSilence:   minSilence,
+		sampleRate:   sampleRate,
+	}
+}
+
+// Feed analyzes the next samples of the stream, captured starting at timestamp, and
+// returns the edges they complete in order. A trailing partial window is kept and
+// completed by the next call, whose samples are taken to follow on directly.
+func (d *SpeechDetector) Feed(samples []float32, timestamp time.Time) []SpeechEdge {
+	if len(d.pending) == 0 {
+		d.pendingTime = timestamp
+	}
+	d.pending = append(d.pending, samples...)
+
+	var edges []SpeechEdge
+	windowSamples := d.windowFrames * d.channels
+	windowDuration := time.Duration(d.windowFrames) * time.Second / time.Duration(d.sampleRate)
+	consumed := 0
+	for len(d.pending)-consumed >= windowSamples {
+		window := d.pending[consumed : consumed+windowSamples]
+		start := d.pendingTime
+		end := start.Add(windowDuration)
+
+		if RMSLevel(window) >= d.threshold {
+			if !d.speaking {
+				d.speaking = true
+				edges = append(edges, SpeechEdge{Speech: true, Time: start})
+			}
+			d.lastVoiced = end
+		} else if d.speaking && end.Sub(d.lastVoiced) >= d.minSilence {
+			d.speaking = false
+			edges = append(edges, SpeechEdge{Speech: false, Time: d.lastVoiced})
+		}
+
+		consumed += windowSamples
+		d.pendingTime = end
+	}
+	d.pending = append(d.pending[:0], d.pending[consumed:]...)
+
+	return edges
+}
+
+// Flush ends speech that is still going at the end of the stream
+func (d *SpeechDetector) Flush() []SpeechEdge {
+	d.pending = d.pending[:0]
+	if !d.speaking {
+		return nil
+	}
+	d.speaking = false
+	return []SpeechEdge{{Speech: false, Time: d.lastVoiced}}
+}
+
+// DetectSpeechRegions returns the stretches of speech in interleaved samples using a
+// SpeechDetector. Windows with an RMS level at or above threshold count as speech,
+// and silences shorter than minSilenceMs are bridged.
+func DetectSpeechRegions(samples []float32, sampleRate, channels int, threshold float32, minSilenceMs int) []Region {
+	var origin time.Time
+	detector := NewSpeechDetector(sampleRate, channels, threshold, time.Duration(minSilenceMs)*time.Millisecond)
+	edges := append(detector.Feed(samples, origin), detector.Flush()...)
+
+	var regions []Region
+	for _, edge := range edges {
+		if edge.Speech {
+			regions = append(regions, Region{Start: edge.Time.Sub(origin)})
+		} else {
+			regions[len(regions)-1].End = edge.Time.Sub(origin)
+		}
+	}
+	return regions
+}
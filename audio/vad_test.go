@@ -0,0 +1,106 @@
+package audio
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// segment is a stretch of constant level, whose RMS is the level itself
+type segment struct {
+	duration time.Duration
+	level    float32
+}
+
+// segments builds mono audio at testRate from consecutive segments
+func segments(parts ...segment) []float32 {
+	var samples []float32
+	for _, part := range parts {
+		frames := int(part.duration * testRate / time.Second)
+		samples = append(samples, slices.Repeat([]float32{part.level}, frames)...)
+	}
+	return samples
+}
+
+func TestDetectSpeechRegions(t *testing.T) {
+	const ms = time.Millisecond
+	const quiet, loud = 0.001, 0.1
+
+	tests := []struct {
+		name  string
+		parts []segment
+		want  []Region
+	}{
+		{"Empty", nil, nil},
+		{"Silence", []segment{{time.Second, quiet}}, nil},
+		{"Single", []segment{{100 * ms, quiet}, {200 * ms, loud}, {500 * ms, quiet}},
+			[]Region{{100 * ms, 300 * ms}}},
+		{"FromStart", []segment{{100 * ms, loud}, {500 * ms, quiet}},
+			[]Region{{0, 100 * ms}}},
+		// Speech still going at the end is closed after its last voiced window
+		{"UntilEnd", []segment{{200 * ms, quiet}, {300 * ms, loud}},
+			[]Region{{200 * ms, 500 * ms}}},
+		// A pause shorter than the minimum silence is bridged
+		{"ShortPause", []segment{{200 * ms, loud}, {100 * ms, quiet}, {200 * ms, loud}, {500 * ms, quiet}},
+			[]Region{{0, 500 * ms}}},
+		{"PauseOfMinSilence", []segment{{200 * ms, loud}, {200 * ms, quiet}, {200 * ms, loud}, {500 * ms, quiet}},
+			[]Region{{0, 200 * ms}, {400 * ms, 600 * ms}}},
+		// A window exactly at the threshold counts as speech
+		{"AtThreshold", []segment{{100 * ms, quiet}, {100 * ms, 0.02}, {300 * ms, quiet}},
+			[]Region{{100 * ms, 200 * ms}}},
+		{"BelowThreshold", []segment{{100 * ms, quiet}, {100 * ms, 0.0199}, {300 * ms, quiet}}, nil},
+		// A burst shorter than a window shows as the whole window it falls in
+		{"Click", []segment{{100 * ms, quiet}, {10 * ms, loud}, {300 * ms, quiet}},
+			[]Region{{100 * ms, 120 * ms}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := DetectSpeechRegions(segments(tc.parts...), testRate, 1, 0.02, 200)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("regions = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDetectSpeechRegionsStereo(t *testing.T) {
+	// Speech on the left channel only still counts, at its level across both channels
+	mono := segments(segment{100 * time.Millisecond, 0}, segment{200 * time.Millisecond, 0.1})
+	stereo := InterleaveStereo(mono, make([]float32, len(mono)))
+
+	want := []Region{{100 * time.Millisecond, 300 * time.Millisecond}}
+	if got := DetectSpeechRegions(stereo, testRate, 2, 0.05, 200); !slices.Equal(got, want) {
+		t.Errorf("regions = %v, want %v", got, want)
+	}
+	// The silent right channel halves the power, so a threshold above 0.1/√2 misses it
+	if got := DetectSpeechRegions(stereo, testRate, 2, 0.08, 200); got != nil {
+		t.Errorf("regions above the two-channel level = %v, want none", got)
+	}
+}
+
+func TestSpeechDetectorChunked(t *testing.T) {
+	start := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	samples := segments(
+		segment{90 * time.Millisecond, 0}, segment{230 * time.Millisecond, 0.1},
+		segment{400 * time.Millisecond, 0}, segment{110 * time.Millisecond, 0.1},
+	)
+	whole := NewSpeechDetector(testRate, 1, 0.02, 200*time.Millisecond)
+	want := append(whole.Feed(samples, start), whole.Flush()...)
+	if len(want) != 4 {
+		t.Fatalf("found %d edges in the whole stream, want 4: %v", len(want), want)
+	}
+
+	// Chunks that don't line up with the windows find the same edges at the same times
+	for _, chunkFrames := range []int{1, 77, 160, 1000} {
+		detector := NewSpeechDetector(testRate, 1, 0.02, 200*time.Millisecond)
+		var got []SpeechEdge
+		for offset := 0; offset < len(samples); offset += chunkFrames {
+			chunk := samples[offset:min(offset+chunkFrames, len(samples))]
+			got = append(got, detector.Feed(chunk, start.Add(time.Duration(offset)*time.Second/testRate))...)
+		}
+		got = append(got, detector.Flush()...)
+		if !slices.Equal(got, want) {
+			t.Errorf("%d-frame chunks found %v, want %v", chunkFrames, got, want)
+		}
+	}
+}
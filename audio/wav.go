@@ -2,8 +2,11 @@ package audio
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"time"
 )
 
 // WAVHeader holds information for a WAV file
@@ -11,36 +14,143 @@ type WAVHeader struct {
 	SampleRate    int
 	Channels      int
 	BitsPerSample int
+	Float         bool // 32-bit IEEE float samples (format code 3) instead of PCM
 	DataSize      int
+	Bext          *BextChunk        // Optional Broadcast Wave extension chunk
+	Tags          map[string]string // Optional tags, stored in a LIST/INFO chunk
 }
 
-// WriteWAVHeader writes a WAV header to the file
-func WriteWAVHeader(file *os.File, header WAVHeader) error {
+// StandardWAVHeaderSize is the size of a canonical PCM WAV header: the RIFF header,
+// a 16-byte fmt chunk and the data chunk header, with no optional chunks
+const StandardWAVHeaderSize = 44
+
+// Sizes of the RIFF header and of every chunk header: an ID and a 32-bit size. The
+// RIFF size counts the file after its own header.
+const (
+	riffHeaderSize  = 8
+	chunkHeaderSize = 8
+)
+
+// WAV format codes
+const (
+	wavFormatPCM   = 1
+	wavFormatFloat = 3
+)
+
+// Layout of the fact chunk that float files carry after an 18-byte fmt chunk: its ID
+// and the offset of the frame count that UpdateWAVHeader keeps current
+const (
+	factChunkID     = 38
+	factFrameOffset = 46
+)
+
+// BextChunk holds the Broadcast Wave Format (EBU Tech 3285) extension fields
+type BextChunk struct {
+	Description         string // Free text description (max 256 characters)
+	Originator          string // Name of the originator (max 32 characters)
+	OriginatorReference string // Unique reference of the originator (max 32 characters)
+	OriginationDate     string // Creation date as yyyy-mm-dd
+	OriginationTime     string // Creation time as hh:mm:ss
+	TimeReference       uint64 // First sample count since midnight
+}
+
+// bextChunkSize is the size of the fixed bext fields, without coding history
+const bextChunkSize = 602
+
+// NewBextChunk creates a bext chunk whose timecode marks the given recording start.
+// The timecode counts the time actually elapsed since local midnight, so it stays
+// correct on days when daylight saving time begins or ends.
+func NewBextChunk(start time.Time, sampleRate int, description string) *BextChunk {
+	midnight := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	elapsed := start.Sub(midnight)
+
+	return &BextChunk{
+		Description:     description,
+		Originator:      "AudioRecorder",
+		OriginationDate: start.Format("2006-01-02"),
+		OriginationTime: start.Format("15:04:05"),
+		TimeReference:   uint64(int64(elapsed) * int64(sampleRate) / int64(time.Second)),
+	}
+}
+
+// HeaderSize returns the number of bytes WriteWAVHeader writes before the audio data,
+// including optional chunks
+func HeaderSize(h WAVHeader) int {
+	size := StandardWAVHeaderSize
+	if h.Float {
+		size += 2 + chunkHeaderSize + 4 // fmt extension size field and fact chunk
+	}
+	if h.Bext != nil {
+		size += chunkHeaderSize + bextChunkSize
+	}
+	size += len(infoChunk(h.Tags))
+	return size
+}
+
+// EstimateSize returns the size in bytes of a plain PCM WAV file holding the given
+// length of audio, e.g. 115,200,044 for an hour of 16 kHz mono 16-bit audio. Optional
+// chunks add a little; see HeaderSize.
+func EstimateSize(durationSeconds float64, sampleRate, channels, bitsPerSample int) int64 {
+	frames := int64(math.Round(max(durationSeconds, 0) * float64(sampleRate)))
+	return StandardWAVHeaderSize + frames*int64(channels*bitsPerSample/8)
+}
+
+// writeBextChunk writes the bext chunk with its fixed-width fields
+func writeBextChunk(file io.Writer, bext *BextChunk) error {
+	if _, err := io.WriteString(file, "bext"); err != nil {
+		return err
+	}
+	if err := binary.Write(file, binary.LittleEndian, uint32(bextChunkSize)); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, bextChunkSize)
+	copy(chunk[0:256], bext.Description)
+	copy(chunk[256:288], bext.Originator)
+	copy(chunk[288:320], bext.OriginatorReference)
+	copy(chunk[320:330], bext.OriginationDate)
+	copy(chunk[330:338], bext.OriginationTime)
+	binary.LittleEndian.PutUint32(chunk[338:342], uint32(bext.TimeReference))
+	binary.LittleEndian.PutUint32(chunk[342:346], uint32(bext.TimeReference>>32))
+	binary.LittleEndian.PutUint16(chunk[346:348], 1) // BWF version 1
+	// UMID, loudness and reserved fields are left zeroed
+
+	_, err := file.Write(chunk)
+	return err
+}
+
+// WriteWAVHeader writes a WAV header to the file, or any other writer
+func WriteWAVHeader(file io.Writer, header WAVHeader) error {
 	// RIFF header
-	if _, err := file.WriteString("RIFF"); err != nil {
+	if _, err := io.WriteString(file, "RIFF"); err != nil {
 		return err
 	}
 
-	// File size (minus 8 bytes for "RIFF" and size)
-	fileSize := 36 + header.DataSize
+	// File size after the RIFF header
+	fileSize := HeaderSize(header) - riffHeaderSize + header.DataSize
 	if err := binary.Write(file, binary.LittleEndian, uint32(fileSize)); err != nil {
 		return err
 	}
 
-	if _, err := file.WriteString("WAVE"); err != nil {
+	if _, err := io.WriteString(file, "WAVE"); err != nil {
 		return err
 	}
 
 	// Format chunk
-	if _, err := file.WriteString("fmt "); err != nil {
+	if _, err := io.WriteString(file, "fmt "); err != nil {
 		return err
 	}
 
-	if err := binary.Write(file, binary.LittleEndian, uint32(16)); err != nil { // Format chunk size
+	// Non-PCM formats carry an (empty) extension size field
+	formatSize, formatCode := uint32(16), uint16(wavFormatPCM)
+	if header.Float {
+		formatSize, formatCode = 18, wavFormatFloat
+	}
+	if err := binary.Write(file, binary.LittleEndian, formatSize); err != nil { // Format chunk size
 		return err
 	}
 
-	if err := binary.Write(file, binary.LittleEndian, uint16(1)); err != nil { // PCM format
+	if err := binary.Write(file, binary.LittleEndian, formatCode); err != nil {
 		return err
 	}
 
@@ -69,8 +179,36 @@ func WriteWAVHeader(file *os.File, header WAVHeader) error {
 		return err
 	}
 
+	// Float files need a fact chunk with the number of frames
+	if header.Float {
+		if err := binary.Write(file, binary.LittleEndian, uint16(0)); err != nil { // Extension size
+			return err
+		}
+		if _, err := io.WriteString(file, "fact"); err != nil {
+			return err
+		}
+		if err := binary.Write(file, binary.LittleEndian, uint32(4)); err != nil {
+			return err
+		}
+		if err := binary.Write(file, binary.LittleEndian, uint32(header.DataSize/max(blockAlign, 1))); err != nil {
+			return err
+		}
+	}
+
+	// Broadcast Wave extension chunk
+	if header.Bext != nil {
+		if err := writeBextChunk(file, header.Bext); err != nil {
+			return err
+		}
+	}
+
+	// Tags
+	if _, err := file.Write(infoChunk(header.Tags)); err != nil {
+		return err
+	}
+
 	// Data chunk
-	if _, err := file.WriteString("data"); err != nil {
+	if _, err := io.WriteString(file, "data"); err != nil {
 		return err
 	}
 
@@ -81,49 +219,134 @@ func WriteWAVHeader(file *os.File, header WAVHeader) error {
 	return nil
 }
 
-// UpdateWAVHeader updates the size information in the WAV header
-func UpdateWAVHeader(file *os.File, dataSize int) error {
-	// Update the RIFF chunk size (file size - 8)
-	fileSize := 36 + dataSize
-	file.Seek(4, io.SeekStart)
-	if err := binary.Write(file, binary.LittleEndian, uint32(fileSize)); err != nil {
+// UpdateWAVHeader updates the size information in the WAV header of a file or any other
+// seekable sink. It finds the data chunk from the header itself, so files with optional
+// chunks are handled too, which needs a sink that can also be read back.
+func UpdateWAVHeader(file io.WriteSeeker, dataSize int) error {
+	reader, ok := file.(io.ReadSeeker)
+	if !ok {
+		return fmt.Errorf("updating a WAV header needs a sink that can be read back")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
-
-	// Update the data chunk size
-	file.Seek(40, io.SeekStart)
-	if err := binary.Write(file, binary.LittleEndian, uint32(dataSize)); err != nil {
+	_, dataOffset, _, err := readWAVLayout(reader)
+	if err != nil {
 		return err
 	}
+	return updateWAVHeader(file, int(dataOffset), dataSize)
+}
 
-	return nil
+// updateWAVHeader updates the size information in a WAV header of headerSize bytes.
+// Files written with a float header also get their fact chunk's frame count updated,
+// when the writer can read back the header to find it (as files can).
+func updateWAVHeader(file io.WriteSeeker, headerSize, dataSize int) error {
+	if err := updateWAVSizes(file, headerSize, dataSize); err != nil {
+		return err
+	}
+	return updateFactChunk(file, dataSize)
 }
 
-// WriteFloatSamples writes float32 samples as 16-bit PCM to a WAV file
-func WriteFloatSamples(file *os.File, samples []float32) (int, error) {
-	bytesWritten := 0
+// updateWAVSizes sets the RIFF and data chunk sizes of a WAV header of headerSize bytes
+func updateWAVSizes(file io.WriteSeeker, headerSize, dataSize int) error {
+	// Update the RIFF chunk size (file size after the RIFF header)
+	fileSize := headerSize - riffHeaderSize + dataSize
+	if _, err := file.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(file, binary.LittleEndian, uint32(fileSize)); err != nil {
+		return err
+	}
+
+	// Update the data chunk size, the last field before the audio data
+	if _, err := file.Seek(int64(headerSize-4), io.SeekStart); err != nil {
+		return err
+	}
+	return binary.Write(file, binary.LittleEndian, uint32(dataSize))
+}
 
-	for _, sample := range samples {
-		// Convert float32 (-1.0 to 1.0) to int16 range
-		int16Sample := int16(sample * 32767)
-		err := binary.Write(file, binary.LittleEndian, int16Sample)
-		if err != nil {
-			return bytesWritten, err
+// updateFactChunk sets the frame count of the fact chunk in files laid out by
+// WriteWAVHeader with a float header. Other files, and writers that can't be read
+// back, are left alone.
+func updateFactChunk(file io.WriteSeeker, dataSize int) error {
+	format := make([]byte, factFrameOffset)
+	switch reader := file.(type) {
+	case io.ReaderAt:
+		if _, err := reader.ReadAt(format, 0); err != nil {
+			return nil // Too short to hold a fact chunk
+		}
+	case io.ReadSeeker:
+		if _, err := reader.Seek(0, io.SeekStart); err != nil {
+			return err
 		}
-		bytesWritten += 2 // 2 bytes per sample (16-bit)
+		if _, err := io.ReadFull(reader, format); err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	if binary.LittleEndian.Uint16(format[20:22]) != wavFormatFloat || string(format[factChunkID:factChunkID+4]) != "fact" {
+		return nil
 	}
 
-	return bytesWritten, nil
+	blockAlign := int(binary.LittleEndian.Uint16(format[32:34]))
+	if blockAlign == 0 {
+		return nil
+	}
+	return writeFactFrames(file, dataSize/blockAlign)
 }
 
-// InitializeWAVFile creates a new WAV file with header
-func InitializeWAVFile(filePath string, sampleRate, channels int) error {
-	file, err := os.Create(filePath)
-	if err != nil {
+// writeFactFrames sets the frame count of the fact chunk that WriteWAVHeader writes
+// for a float header
+func writeFactFrames(file io.WriteSeeker, frames int) error {
+	if _, err := file.Seek(factFrameOffset, io.SeekStart); err != nil {
 		return err
 	}
-	defer file.Close()
+	return binary.Write(file, binary.LittleEndian, uint32(frames))
+}
+
+// Int16FullScale is the scaling factor between float samples and 16-bit PCM.
+// A float of -1.0 maps to -32768; positive values are clamped at 32767, so +1.0
+// is the one value that loses a step. FloatToInt16 and Int16ToFloat are inverses
+// for every 16-bit value.
+const Int16FullScale = 32768
+
+// FloatToInt16 converts a float sample (-1.0 to 1.0) to 16-bit PCM, clamping out-of-range values
+func FloatToInt16(sample float32) int16 {
+	scaled := math.Round(float64(sample) * Int16FullScale)
+	if scaled > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if scaled < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(scaled)
+}
+
+// Int16ToFloat converts a 16-bit PCM sample to a float sample (-1.0 to just under 1.0)
+func Int16ToFloat(sample int16) float32 {
+	return float32(sample) / Int16FullScale
+}
+
+// WriteFloatSamples writes float32 samples as 16-bit PCM to a WAV file.
+// It does not know the file's channel count; check the samples with ValidateFrames.
+func WriteFloatSamples(file io.Writer, samples []float32) (int, error) {
+	return WritePCMSamples(file, samples, 16)
+}
+
+// WritePCMSamples writes float32 samples as PCM of the given depth (16, 24 or 32 bits)
+func WritePCMSamples(file io.Writer, samples []float32, bitsPerSample int) (int, error) {
+	return file.Write(EncodeSamples(samples, bitsPerSample))
+}
+
+// WriteIEEEFloatSamples writes float32 samples unchanged to a float WAV file, so they
+// read back bit-identical. Like WriteFloatSamples it does not check whole frames.
+func WriteIEEEFloatSamples(file io.Writer, samples []float32) (int, error) {
+	return file.Write(EncodeFloat32Samples(samples))
+}
 
+// InitializeWAVFile creates a new WAV file with header
+func InitializeWAVFile(filePath string, sampleRate, channels int) error {
 	header := WAVHeader{
 		SampleRate:    sampleRate,
 		Channels:      channels,
@@ -131,9 +354,95 @@ func InitializeWAVFile(filePath string, sampleRate, channels int) error {
 		DataSize:      0, // Initial data size is zero
 	}
 
+	return CreateWAVFile(filePath, header)
+}
+
+// RepairWAVHeader rewrites the size fields of a WAV file from its actual length,
+// dropping a trailing partial frame. It recovers files whose writer stopped between
+// writing audio and updating the header.
+func RepairWAVHeader(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, dataOffset, _, err := readWAVLayout(file)
+	if err != nil {
+		return err
+	}
+	blockAlign := int64(header.Channels * header.BitsPerSample / 8)
+	if blockAlign == 0 {
+		return fmt.Errorf("invalid format: %d channels, %d bits", header.Channels, header.BitsPerSample)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	dataSize := (info.Size() - dataOffset) / blockAlign * blockAlign
+	if err := file.Truncate(dataOffset + dataSize); err != nil {
+		return err
+	}
+
+	return updateWAVHeader(file, int(dataOffset), int(dataSize))
+}
+
+// MaxChannels is the largest channel count accepted for WAV files
+const MaxChannels = 8
+
+// ValidateChannels checks that a channel count is between 1 and MaxChannels
+func ValidateChannels(channels int) error {
+	if channels < 1 || channels > MaxChannels {
+		return fmt.Errorf("channel count must be between 1 and %d, got %d", MaxChannels, channels)
+	}
+	return nil
+}
+
+// ValidateFrames checks that interleaved samples hold only whole frames of the given channel count
+func ValidateFrames(samples []float32, channels int) error {
+	if err := ValidateChannels(channels); err != nil {
+		return err
+	}
+	if len(samples)%channels != 0 {
+		return fmt.Errorf("%d samples is not a whole number of %d-channel frames", len(samples), channels)
+	}
+	return nil
+}
+
+// CreateWAVFile creates a new WAV file with the given header, including any optional chunks
+func CreateWAVFile(filePath string, header WAVHeader) error {
+	if err := validateHeader(header); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
 	return WriteWAVHeader(file, header)
 }
 
+// validateHeader checks that a header can be written and read back
+func validateHeader(header WAVHeader) error {
+	if err := ValidateChannels(header.Channels); err != nil {
+		return err
+	}
+	if header.Float && header.BitsPerSample != 32 {
+		return fmt.Errorf("float WAV files must be 32-bit, got %d bits", header.BitsPerSample)
+	}
+	if err := ValidateTags(header.Tags); err != nil {
+		return err
+	}
+	if header.Bext != nil && (len(header.Bext.OriginationDate) > 10 || len(header.Bext.OriginationTime) > 8) {
+		return fmt.Errorf("bext origination date/time too long: %q %q",
+			header.Bext.OriginationDate, header.Bext.OriginationTime)
+	}
+	return nil
+}
+
 // MixAudioSamples mixes two float32 sample arrays with a simple 50/50 mix
 func MixAudioSamples(samples1, samples2 []float32) []float32 {
 	// If one array is empty, return the other
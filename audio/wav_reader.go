@@ -0,0 +1,259 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// ProbeWAV reads the header and chunk layout of a WAV file without loading its audio.
+// Unknown chunks are skipped. The returned data size is clamped to the bytes actually
+// present, so a truncated file never reports more audio than it holds.
+func ProbeWAV(path string) (WAVHeader, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return WAVHeader{}, 0, err
+	}
+	defer file.Close()
+
+	header, _, dataBytes, err := readWAVLayout(file)
+	return header, dataBytes, err
+}
+
+// ReadWAV loads the audio of a 16, 24 or 32-bit PCM or 32-bit float WAV file as float
+// samples. Samples are converted with DecodeSamples, the inverse of the scaling used
+// when writing; float files read back exactly as written.
+func ReadWAV(path string) ([]float32, WAVHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, WAVHeader{}, err
+	}
+	defer file.Close()
+
+	header, dataOffset, dataBytes, err := readWAVLayout(file)
+	if err != nil {
+		return nil, header, err
+	}
+	format, err := sampleFormat(header)
+	if err != nil {
+		return nil, header, err
+	}
+	width := int64(header.BitsPerSample / 8)
+
+	if _, err := file.Seek(dataOffset, io.SeekStart); err != nil {
+		return nil, header, err
+	}
+	data := make([]byte, dataBytes/width*width)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, header, err
+	}
+
+	samples := DecodeSamples(nil, data, format, len(data)/int(width))
+
+	return samples, header, nil
+}
+
+// ReadRawPCM loads a headerless file of little-endian samples, such as the output of
+// ffmpeg -f s16le, as float samples. The format, rate and channel count can't be read
+// from the file, so they are given; the returned header describes the audio as if it
+// had come from a WAV file. The file must hold whole frames.
+func ReadRawPCM(path string, sampleRate, channels int, format malgo.FormatType) ([]float32, WAVHeader, error) {
+	width := FormatBits(format) / 8
+	if width == 0 {
+		return nil, WAVHeader{}, fmt.Errorf("unsupported sample format %s", FormatName(format))
+	}
+	if err := ValidateChannels(channels); err != nil {
+		return nil, WAVHeader{}, err
+	}
+	if sampleRate <= 0 {
+		return nil, WAVHeader{}, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, WAVHeader{}, err
+	}
+	if frameSize := width * channels; len(data)%frameSize != 0 {
+		return nil, WAVHeader{}, fmt.Errorf("%s: %d bytes is not a whole number of %d-byte frames",
+			path, len(data), frameSize)
+	}
+
+	header := WAVHeader{
+		SampleRate:    sampleRate,
+		Channels:      channels,
+		BitsPerSample: width * 8,
+		Float:         format == malgo.FormatF32,
+		DataSize:      len(data),
+	}
+	return DecodeSamples(nil, data, format, len(data)/width), header, nil
+}
+
+// sampleFormat returns the format of the samples in a WAV file with the given header
+func sampleFormat(header WAVHeader) (malgo.FormatType, error) {
+	switch header.BitsPerSample {
+	case 16:
+		return malgo.FormatS16, nil
+	case 24:
+		return malgo.FormatS24, nil
+	case 32:
+		if header.Float {
+			return malgo.FormatF32, nil
+		}
+		return malgo.FormatS32, nil
+	}
+	return malgo.FormatUnknown, fmt.Errorf("unsupported bits per sample: %d", header.BitsPerSample)
+}
+
+// readWAVLayout parses the RIFF chunks of a WAV file, read from its current position,
+// up to the start of its data chunk. It returns the header, the byte offset where audio
+// data begins and its length.
+func readWAVLayout(file io.ReadSeeker) (WAVHeader, int64, int64, error) {
+	var header WAVHeader
+
+	size, err := streamSize(file)
+	if err != nil {
+		return header, 0, 0, err
+	}
+
+	// RIFF header
+	riff := make([]byte, 12)
+	if _, err := io.ReadFull(file, riff); err != nil {
+		return header, 0, 0, fmt.Errorf("reading RIFF header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return header, 0, 0, fmt.Errorf("not a WAV file")
+	}
+
+	foundFormat := false
+	offset := int64(12)
+	for {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(file, chunkHeader); err != nil {
+			return header, 0, 0, fmt.Errorf("no data chunk found: %w", err)
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		offset += 8
+
+		// A chunk size past the end of the file is corrupt; reading it would allocate
+		// up to 4 GiB. Only the data chunk may be cut short, by an interrupted recording.
+		if chunkID != "data" && chunkSize > size-offset {
+			return header, 0, 0, fmt.Errorf("%q chunk of %d bytes runs past the end of the file", chunkID, chunkSize)
+		}
+
+		switch chunkID {
+		case "fmt ":
+			format := make([]byte, chunkSize)
+			if _, err := io.ReadFull(file, format); err != nil || chunkSize < 16 {
+				return header, 0, 0, fmt.Errorf("invalid fmt chunk")
+			}
+			header.Float = formatCode(format) == wavFormatFloat
+			header.Channels = int(binary.LittleEndian.Uint16(format[2:4]))
+			header.SampleRate = int(binary.LittleEndian.Uint32(format[4:8]))
+			header.BitsPerSample = int(binary.LittleEndian.Uint16(format[14:16]))
+			foundFormat = true
+
+		case "bext":
+			chunk := make([]byte, chunkSize)
+			if _, err := io.ReadFull(file, chunk); err != nil || chunkSize < 348 {
+				return header, 0, 0, fmt.Errorf("invalid bext chunk")
+			}
+			header.Bext = parseBextChunk(chunk)
+
+		case "LIST":
+			chunk := make([]byte, chunkSize)
+			if _, err := io.ReadFull(file, chunk); err != nil {
+				return header, 0, 0, fmt.Errorf("invalid LIST chunk")
+			}
+			if tags := parseInfoChunk(chunk); tags != nil {
+				header.Tags = tags
+			}
+
+		case "data":
+			if !foundFormat {
+				return header, 0, 0, fmt.Errorf("data chunk before fmt chunk")
+			}
+			dataBytes := chunkSize
+			if available := size - offset; dataBytes > available {
+				dataBytes = available
+			}
+			header.DataSize = int(dataBytes)
+			return header, offset, dataBytes, nil
+
+		default:
+			// Skip unknown chunks
+			if _, err := file.Seek(chunkSize, io.SeekCurrent); err != nil {
+				return header, 0, 0, err
+			}
+		}
+
+		// Chunks are padded to an even size
+		offset += chunkSize
+		if chunkSize%2 == 1 {
+			if _, err := file.Seek(1, io.SeekCurrent); err != nil {
+				return header, 0, 0, err
+			}
+			offset++
+		}
+	}
+}
+
+// streamSize returns the length of a seekable stream, leaving its position unchanged
+func streamSize(stream io.Seeker) (int64, error) {
+	position, err := stream.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	size, err := stream.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := stream.Seek(position, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// wavFormatExtensible is the format code of WAVE_FORMAT_EXTENSIBLE fmt chunks, which
+// carry the actual format code at the start of their subformat GUID
+const wavFormatExtensible = 0xFFFE
+
+// formatCode returns the sample format code of a fmt chunk
+func formatCode(format []byte) uint16 {
+	code := binary.LittleEndian.Uint16(format[0:2])
+	if code == wavFormatExtensible && len(format) >= 26 {
+		code = binary.LittleEndian.Uint16(format[24:26])
+	}
+	return code
+}
+
+// parseBextChunk decodes the fixed-width fields of a bext chunk
+func parseBextChunk(chunk []byte) *BextChunk {
+	text := func(field []byte) string {
+		return string(bytes.TrimRight(field, "\x00"))
+	}
+
+	return &BextChunk{
+		Description:         text(chunk[0:256]),
+		Originator:          text(chunk[256:288]),
+		OriginatorReference: text(chunk[288:320]),
+		OriginationDate:     text(chunk[320:330]),
+		OriginationTime:     text(chunk[330:338]),
+		TimeReference: uint64(binary.LittleEndian.Uint32(chunk[338:342])) |
+			uint64(binary.LittleEndian.Uint32(chunk[342:346]))<<32,
+	}
+}
+
+// Duration returns the playing time of the audio described by the header
+func (h WAVHeader) Duration() time.Duration {
+	bytesPerSecond := h.SampleRate * h.Channels * h.BitsPerSample / 8
+	if bytesPerSecond == 0 {
+		return 0
+	}
+	return time.Duration(float64(h.DataSize) / float64(bytesPerSecond) * float64(time.Second))
+}
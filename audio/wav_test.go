@@ -0,0 +1,521 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// goldenBext is the bext chunk of the bext golden header: its chunk header and the
+// fixed fields, with the timecode 0x0102030405 and version 1
+func goldenBext() []byte {
+	chunk := make([]byte, chunkHeaderSize+bextChunkSize)
+	copy(chunk, "bext\x5a\x02\x00\x00") // 602 bytes
+	fields := chunk[chunkHeaderSize:]
+	copy(fields[0:], "Interview")
+	copy(fields[256:], "AudioRecorder")
+	copy(fields[320:], "2024-03-10")
+	copy(fields[330:], "09:30:00")
+	copy(fields[338:], []byte{0x05, 0x04, 0x03, 0x02, 0x01, 0x00, 0x00, 0x00})
+	copy(fields[346:], []byte{0x01, 0x00})
+	return chunk
+}
+
+// wavHeaderCases are headers with the exact bytes WriteWAVHeader must produce for them
+var wavHeaderCases = []struct {
+	name   string
+	header WAVHeader
+	golden []byte
+}{
+	{
+		name:   "PCM16",
+		header: WAVHeader{SampleRate: 44100, Channels: 2, BitsPerSample: 16, DataSize: 400},
+		golden: []byte{
+			'R', 'I', 'F', 'F', 0xb4, 0x01, 0x00, 0x00, 'W', 'A', 'V', 'E',
+			'f', 'm', 't', ' ', 0x10, 0x00, 0x00, 0x00,
+			0x01, 0x00, 0x02, 0x00, // PCM, 2 channels
+			0x44, 0xac, 0x00, 0x00, // 44100 Hz
+			0x10, 0xb1, 0x02, 0x00, // 176400 bytes per second
+			0x04, 0x00, 0x10, 0x00, // Block align 4, 16 bits
+			'd', 'a', 't', 'a', 0x90, 0x01, 0x00, 0x00,
+		},
+	},
+	{
+		name:   "PCM24",
+		header: WAVHeader{SampleRate: 48000, Channels: 1, BitsPerSample: 24, DataSize: 0},
+		golden: []byte{
+			'R', 'I', 'F', 'F', 0x24, 0x00, 0x00, 0x00, 'W', 'A', 'V', 'E',
+			'f', 'm', 't', ' ', 0x10, 0x00, 0x00, 0x00,
+			0x01, 0x00, 0x01, 0x00, // PCM, 1 channel
+			0x80, 0xbb, 0x00, 0x00, // 48000 Hz
+			0x80, 0x32, 0x02, 0x00, // 144000 bytes per second
+			0x03, 0x00, 0x18, 0x00, // Block align 3, 24 bits
+			'd', 'a', 't', 'a', 0x00, 0x00, 0x00, 0x00,
+		},
+	},
+	{
+		name:   "Float",
+		header: WAVHeader{SampleRate: 16000, Channels: 2, BitsPerSample: 32, Float: true, DataSize: 800},
+		golden: []byte{
+			'R', 'I', 'F', 'F', 0x52, 0x03, 0x00, 0x00, 'W', 'A', 'V', 'E',
+			'f', 'm', 't', ' ', 0x12, 0x00, 0x00, 0x00,
+			0x03, 0x00, 0x02, 0x00, // IEEE float, 2 channels
+			0x80, 0x3e, 0x00, 0x00, // 16000 Hz
+			0x00, 0xf4, 0x01, 0x00, // 128000 bytes per second
+			0x08, 0x00, 0x20, 0x00, // Block align 8, 32 bits
+			0x00, 0x00, // Extension size
+			'f', 'a', 'c', 't', 0x04, 0x00, 0x00, 0x00,
+			0x64, 0x00, 0x00, 0x00, // 100 frames
+			'd', 'a', 't', 'a', 0x20, 0x03, 0x00, 0x00,
+		},
+	},
+	{
+		name: "Bext",
+		header: WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16, Bext: &BextChunk{
+			Description:     "Interview",
+			Originator:      "AudioRecorder",
+			OriginationDate: "2024-03-10",
+			OriginationTime: "09:30:00",
+			TimeReference:   0x0102030405,
+		}},
+		golden: slices.Concat(
+			[]byte{
+				'R', 'I', 'F', 'F', 0x86, 0x02, 0x00, 0x00, 'W', 'A', 'V', 'E',
+				'f', 'm', 't', ' ', 0x10, 0x00, 0x00, 0x00,
+				0x01, 0x00, 0x01, 0x00, // PCM, 1 channel
+				0x40, 0x1f, 0x00, 0x00, // 8000 Hz
+				0x80, 0x3e, 0x00, 0x00, // 16000 bytes per second
+				0x02, 0x00, 0x10, 0x00, // Block align 2, 16 bits
+			},
+			goldenBext(),
+			[]byte{'d', 'a', 't', 'a', 0x00, 0x00, 0x00, 0x00},
+		),
+	},
+}
+
+func TestWriteWAVHeaderGolden(t *testing.T) {
+	for _, tc := range wavHeaderCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var written bytes.Buffer
+			if err := WriteWAVHeader(&written, tc.header); err != nil {
+				t.Fatalf("WriteWAVHeader: %v", err)
+			}
+			if !bytes.Equal(written.Bytes(), tc.golden) {
+				t.Errorf("header bytes differ\n got %x\nwant %x", written.Bytes(), tc.golden)
+			}
+			if size := HeaderSize(tc.header); size != len(tc.golden) {
+				t.Errorf("HeaderSize = %d, want %d", size, len(tc.golden))
+			}
+		})
+	}
+}
+
+func TestWAVHeaderRoundTrip(t *testing.T) {
+	for _, tc := range wavHeaderCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Follow the header with the audio it announces
+			path := filepath.Join(t.TempDir(), "header.wav")
+			data := append(bytes.Clone(tc.golden), make([]byte, tc.header.DataSize)...)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			header, dataOffset, dataBytes, err := readWAVLayout(file)
+			if err != nil {
+				t.Fatalf("readWAVLayout: %v", err)
+			}
+			if !reflect.DeepEqual(header, tc.header) {
+				t.Errorf("header = %+v, want %+v", header, tc.header)
+			}
+			if dataOffset != int64(len(tc.golden)) || dataBytes != int64(tc.header.DataSize) {
+				t.Errorf("data at %d with %d bytes, want %d with %d bytes",
+					dataOffset, dataBytes, len(tc.golden), tc.header.DataSize)
+			}
+		})
+	}
+}
+
+func TestWAVByteRateAndBlockAlign(t *testing.T) {
+	tests := []struct {
+		name        string
+		sampleRate  int
+		channels    int
+		bits        int
+		bytesPerSec uint32
+		blockAlign  uint16
+	}{
+		{"Mono16", 16000, 1, 16, 32000, 2},
+		{"Stereo16", 44100, 2, 16, 176400, 4},
+		{"Mono24", 48000, 1, 24, 144000, 3},
+		{"Stereo24", 48000, 2, 24, 288000, 6},
+		{"Stereo24At96k", 96000, 2, 24, 576000, 6},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var written bytes.Buffer
+			header := WAVHeader{SampleRate: tc.sampleRate, Channels: tc.channels, BitsPerSample: tc.bits}
+			if err := WriteWAVHeader(&written, header); err != nil {
+				t.Fatalf("WriteWAVHeader: %v", err)
+			}
+
+			// The byte rate and block align follow the sample rate in the fmt chunk
+			format := written.Bytes()[20:36]
+			if got := binary.LittleEndian.Uint32(format[8:12]); got != tc.bytesPerSec {
+				t.Errorf("bytes per second = %d, want %d", got, tc.bytesPerSec)
+			}
+			if got := binary.LittleEndian.Uint16(format[12:14]); got != tc.blockAlign {
+				t.Errorf("block align = %d, want %d", got, tc.blockAlign)
+			}
+			if got := binary.LittleEndian.Uint16(format[14:16]); int(got) != tc.bits {
+				t.Errorf("bits per sample = %d, want %d", got, tc.bits)
+			}
+		})
+	}
+}
+
+func TestNewBextChunkTimeReference(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		start time.Time
+		want  uint64
+	}{
+		{"UTC", time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), 12 * 3600 * 48000},
+		{"Fraction", time.Date(2024, 3, 10, 0, 0, 1, 500_000_000, time.UTC), 72000},
+		// Clocks skip 02:00-03:00, so only 11 hours have passed at noon
+		{"DSTStart", time.Date(2024, 3, 10, 12, 0, 0, 0, newYork), 11 * 3600 * 48000},
+		// Clocks repeat 01:00-02:00, so 13 hours have passed at noon
+		{"DSTEnd", time.Date(2024, 11, 3, 12, 0, 0, 0, newYork), 13 * 3600 * 48000},
+		{"Normal", time.Date(2024, 7, 1, 12, 0, 0, 0, newYork), 12 * 3600 * 48000},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Strip the monotonic reading, as a time restored from disk would be
+			bext := NewBextChunk(tc.start.Round(0), 48000, "")
+			if bext.TimeReference != tc.want {
+				t.Errorf("TimeReference = %d, want %d", bext.TimeReference, tc.want)
+			}
+			if bext.OriginationTime != tc.start.Format("15:04:05") {
+				t.Errorf("OriginationTime = %q, want wall clock time", bext.OriginationTime)
+			}
+		})
+	}
+}
+
+func TestReadWAVLayoutChunkBounds(t *testing.T) {
+	// chunk returns a chunk header announcing size bytes, followed by body
+	chunk := func(id string, size uint32, body []byte) []byte {
+		return slices.Concat([]byte(id), binary.LittleEndian.AppendUint32(nil, size), body)
+	}
+	format := wavHeaderCases[0].golden[12:36] // The PCM16 fmt chunk
+	huge := uint32(0xfffffff0)
+
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   int64 // Data bytes found, or -1 for an error
+	}{
+		{"Fmt", [][]byte{chunk("fmt ", huge, format[8:])}, -1},
+		{"Bext", [][]byte{format, chunk("bext", huge, make([]byte, 348))}, -1},
+		{"List", [][]byte{format, chunk("LIST", huge, []byte("INFO"))}, -1},
+		{"Unknown", [][]byte{format, chunk("junk", huge, nil)}, -1},
+		// An interrupted recording leaves the data chunk short of its size
+		{"DataCutShort", [][]byte{format, chunk("data", huge, make([]byte, 8))}, 8},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body := slices.Concat(append([][]byte{[]byte("WAVE")}, tc.chunks...)...)
+			path := filepath.Join(t.TempDir(), "corrupt.wav")
+			if err := os.WriteFile(path, chunk("RIFF", uint32(len(body)), body), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, dataBytes, err := ProbeWAV(path)
+			if tc.want < 0 {
+				if err == nil {
+					t.Errorf("ProbeWAV accepted a chunk larger than the file")
+				}
+				return
+			}
+			if err != nil || dataBytes != tc.want {
+				t.Errorf("ProbeWAV = %d bytes, %v; want %d bytes", dataBytes, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestUpdateWAVHeaderInMemory(t *testing.T) {
+	for _, tc := range wavHeaderCases {
+		t.Run(tc.name, func(t *testing.T) {
+			empty := tc.header
+			empty.DataSize = 0
+			sink := &memSink{}
+			if err := WriteWAVHeader(sink, empty); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := sink.Write(make([]byte, tc.header.DataSize)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := UpdateWAVHeader(sink, tc.header.DataSize); err != nil {
+				t.Fatalf("UpdateWAVHeader: %v", err)
+			}
+			if got := sink.data[:len(tc.golden)]; !bytes.Equal(got, tc.golden) {
+				t.Errorf("header bytes differ\n got %x\nwant %x", got, tc.golden)
+			}
+		})
+	}
+
+	// A sink that can't be read back gives no way to find the data chunk
+	t.Run("WriteOnly", func(t *testing.T) {
+		sink := &memSink{}
+		if err := WriteWAVHeader(sink, wavHeaderCases[0].header); err != nil {
+			t.Fatal(err)
+		}
+		writeOnly := struct{ io.WriteSeeker }{sink}
+		if err := UpdateWAVHeader(writeOnly, 0); err == nil {
+			t.Error("UpdateWAVHeader accepted a sink it can't read")
+		}
+	})
+}
+
+func TestValidateChannelsAndFrames(t *testing.T) {
+	tests := []struct {
+		channels   int
+		samples    int
+		channelsOK bool
+		framesOK   bool
+	}{
+		{0, 0, false, false},
+		{-1, 2, false, false},
+		{1, 7, true, true},
+		{2, 8, true, true},
+		{2, 7, true, false},
+		{6, 12, true, true},
+		{6, 9, true, false},
+		{8, 16, true, true},
+		{8, 12, true, false},
+		{9, 18, false, false},
+	}
+	for _, tc := range tests {
+		if err := ValidateChannels(tc.channels); (err == nil) != tc.channelsOK {
+			t.Errorf("ValidateChannels(%d) = %v, want valid %v", tc.channels, err, tc.channelsOK)
+		}
+		if err := ValidateFrames(make([]float32, tc.samples), tc.channels); (err == nil) != tc.framesOK {
+			t.Errorf("ValidateFrames(%d samples, %d channels) = %v, want valid %v",
+				tc.samples, tc.channels, err, tc.framesOK)
+		}
+	}
+}
+
+func TestMultichannelWAV(t *testing.T) {
+	dir := t.TempDir()
+
+	// Files with more channels than the header allows are never created
+	for _, channels := range []int{0, 9} {
+		path := filepath.Join(dir, "invalid.wav")
+		if err := InitializeWAVFile(path, 8000, channels); err == nil {
+			t.Errorf("InitializeWAVFile with %d channels succeeded", channels)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("InitializeWAVFile with %d channels left a file behind", channels)
+		}
+	}
+
+	// Six channels of whole frames round trip, each sample on its own channel
+	header := WAVHeader{SampleRate: 8000, Channels: 6, BitsPerSample: 16}
+	path := filepath.Join(dir, "six.wav")
+	w := &wavWriter{filePath: path}
+	if err := w.create(header); err != nil {
+		t.Fatal(err)
+	}
+	frames := make([]float32, 4*header.Channels)
+	for i := range frames {
+		frames[i] = float32(i%header.Channels) / 8
+	}
+	if err := w.append(frames); err != nil {
+		t.Fatalf("append of whole frames: %v", err)
+	}
+
+	// A partial frame is refused and leaves the file as it was
+	size := w.fileSize
+	if err := w.append(make([]float32, header.Channels+1)); err == nil {
+		t.Error("append of a partial frame succeeded")
+	}
+	if w.fileSize != size {
+		t.Errorf("refused append changed the file size from %d to %d", size, w.fileSize)
+	}
+	if err := w.finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	samples, got, err := ReadWAV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Channels != 6 || !slices.Equal(samples, frames) {
+		t.Errorf("read %d channels, %v; want 6 channels, %v", got.Channels, samples, frames)
+	}
+}
+
+func TestReadRawPCM(t *testing.T) {
+	// Eighths are exact in every format, so the samples read back unchanged
+	want := make([]float32, 48)
+	for i := range want {
+		want[i] = float32(i%16-8) / 8
+	}
+	encode := func(format malgo.FormatType) []byte {
+		switch format {
+		case malgo.FormatU8:
+			data := make([]byte, len(want))
+			for i, sample := range want {
+				data[i] = byte(128 + sample*128)
+			}
+			return data
+		case malgo.FormatF32:
+			return EncodeFloat32Samples(want)
+		}
+		return EncodeSamples(want, FormatBits(format))
+	}
+
+	tests := []struct {
+		name     string
+		format   malgo.FormatType
+		channels int
+		trim     int // Bytes cut from the end, leaving a partial frame unless 0
+		wantErr  string
+	}{
+		{"U8", malgo.FormatU8, 1, 0, ""},
+		{"S16Mono", malgo.FormatS16, 1, 0, ""},
+		{"S16Stereo", malgo.FormatS16, 2, 0, ""},
+		{"S24Stereo", malgo.FormatS24, 2, 0, ""},
+		{"S32Quad", malgo.FormatS32, 4, 0, ""},
+		{"F32Stereo", malgo.FormatF32, 2, 0, ""},
+		{"S16MonoOddByte", malgo.FormatS16, 1, 1, "not a whole number of 2-byte frames"},
+		{"S16StereoHalfFrame", malgo.FormatS16, 2, 2, "not a whole number of 4-byte frames"},
+		{"S24StereoPartialSample", malgo.FormatS24, 2, 4, "not a whole number of 6-byte frames"},
+		{"F32QuadThreeSamples", malgo.FormatF32, 4, 4, "not a whole number of 16-byte frames"},
+		// 48 samples hold no whole number of 5-channel frames
+		{"FiveChannels", malgo.FormatS16, 5, 0, "not a whole number of 10-byte frames"},
+		{"UnknownFormat", malgo.FormatUnknown, 1, 0, "unsupported sample format"},
+		{"NoChannels", malgo.FormatS16, 0, 0, "channel"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var data []byte
+			if tc.format != malgo.FormatUnknown {
+				data = encode(tc.format)
+			}
+			data = data[:len(data)-tc.trim]
+			path := filepath.Join(t.TempDir(), "raw.pcm")
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			samples, header, err := ReadRawPCM(path, 8000, tc.channels, tc.format)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("ReadRawPCM = %d samples, %v; want an error containing %q", len(samples), err, tc.wantErr)
+				}
+				if samples != nil {
+					t.Errorf("ReadRawPCM returned %d samples with its error", len(samples))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadRawPCM: %v", err)
+			}
+			if !slices.Equal(samples, want) {
+				t.Errorf("samples = %v, want %v", samples, want)
+			}
+			wantHeader := WAVHeader{
+				SampleRate:    8000,
+				Channels:      tc.channels,
+				BitsPerSample: FormatBits(tc.format),
+				Float:         tc.format == malgo.FormatF32,
+				DataSize:      len(data),
+			}
+			if !reflect.DeepEqual(header, wantHeader) {
+				t.Errorf("header = %+v, want %+v", header, wantHeader)
+			}
+		})
+	}
+
+	if _, _, err := ReadRawPCM(filepath.Join(t.TempDir(), "missing.pcm"), 8000, 1, malgo.FormatS16); !os.IsNotExist(err) {
+		t.Errorf("ReadRawPCM of a missing file = %v, want a not-exist error", err)
+	}
+	if _, _, err := ReadRawPCM(filepath.Join(t.TempDir(), "missing.pcm"), 0, 1, malgo.FormatS16); err == nil {
+		t.Error("ReadRawPCM accepted a sample rate of 0")
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		seconds       float64
+		sampleRate    int
+		channels      int
+		bitsPerSample int
+		want          int64
+	}{
+		// 115.2 MB of audio after the 44-byte header
+		{"HourMono16k", 3600, 16000, 1, 16, 115_200_000 + StandardWAVHeaderSize},
+		{"HourStereo48k", 3600, 48000, 2, 16, 691_200_000 + StandardWAVHeaderSize},
+		{"TwoHoursStereo48k24", 7200, 48000, 2, 24, 2_073_600_000 + StandardWAVHeaderSize},
+		{"MinuteFloat44k", 60, 44100, 2, 32, 21_168_000 + StandardWAVHeaderSize},
+		// Durations round to whole frames
+		{"FractionalFrame", 0.00011, 8000, 1, 16, 2 + StandardWAVHeaderSize},
+		{"Zero", 0, 16000, 1, 16, StandardWAVHeaderSize},
+		{"Negative", -10, 16000, 1, 16, StandardWAVHeaderSize},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := EstimateSize(tc.seconds, tc.sampleRate, tc.channels, tc.bitsPerSample); got != tc.want {
+				t.Errorf("EstimateSize = %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	// The config estimate counts the channels and depth actually written
+	config := RecordingConfig{SampleRate: 16000, Channels: 1, MixMode: MixStereoSplit, FloatWAV: true}
+	if got, want := config.EstimateSize(3600), int64(460_800_000+StandardWAVHeaderSize); got != want {
+		t.Errorf("stereo-split float config estimate = %d, want %d", got, want)
+	}
+
+	// and matches the file a recording of that length leaves
+	recorder := newTestRecorder(t, nil)
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.AddMicSamples(make([]float32, 12000), time.Now())
+	if err := recorder.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(recorder.GetOutputFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := recorder.config.EstimateSize(1.5); info.Size() != want {
+		t.Errorf("1.5 s recording is %d bytes, estimate %d", info.Size(), want)
+	}
+}
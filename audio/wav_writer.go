@@ -0,0 +1,315 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// wavWriter appends audio to a WAV file, or a sink, and keeps its header sizes current
+type wavWriter struct {
+	filePath      string
+	sink          io.WriteSeeker // Written instead of a file; filePath is then empty
+	partial       bool           // Write to filePath+".partial" until complete renames it
+	fileSize      int64
+	headerSize    int
+	channels      int
+	bitsPerSample int
+	float         bool          // Write IEEE float samples instead of PCM
+	factChunk     bool          // The header has the fact chunk WriteWAVHeader writes for float
+	resumed       bool          // Continues an existing file rather than one it created
+	fsyncInterval time.Duration // Sync to disk on appends at least this far apart (0 never)
+	lastSync      time.Time
+
+	// headerInterval patches the header sizes on appends at least this far apart
+	// (0 on every append); fileSize stays authoritative in between
+	headerInterval   time.Duration
+	lastHeaderUpdate time.Time
+	headerStale      bool
+
+	// minBlock holds appends back until this much audio is ready (0 never);
+	// pending is the held audio, which finalize writes
+	minBlock        time.Duration
+	minBlockSamples int
+	pending         []float32
+}
+
+// partialSuffix marks files that are still being written
+const partialSuffix = ".partial"
+
+// writePath returns the path the writer is writing to: the final path, or the
+// partial file until the writer is complete
+func (w *wavWriter) writePath() string {
+	if w.partial {
+		return w.filePath + partialSuffix
+	}
+	return w.filePath
+}
+
+// complete renames a partial file to its final path, so anything watching for the
+// final name only ever sees finished files. Call it after finalize.
+func (w *wavWriter) complete() error {
+	if !w.partial {
+		return nil
+	}
+	if err := os.Rename(w.writePath(), w.filePath); err != nil {
+		return err
+	}
+	w.partial = false
+	return nil
+}
+
+// create writes a fresh WAV header and records the initial file size
+func (w *wavWriter) create(header WAVHeader) error {
+	if w.sink != nil {
+		return w.createSink(header)
+	}
+
+	err := CreateWAVFile(w.writePath(), header)
+	if err != nil {
+		return err
+	}
+
+	// Get initial file size
+	info, err := os.Stat(w.writePath())
+	if err != nil {
+		return err
+	}
+	w.fileSize = info.Size()
+	w.setFormat(header)
+	return nil
+}
+
+// createSink writes a fresh WAV header at the start of the sink
+func (w *wavWriter) createSink(header WAVHeader) error {
+	if err := validateHeader(header); err != nil {
+		return err
+	}
+	if _, err := w.sink.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := WriteWAVHeader(w.sink, header); err != nil {
+		return err
+	}
+	w.fileSize = int64(HeaderSize(header))
+	w.setFormat(header)
+	return nil
+}
+
+// resume continues an existing WAV file, e.g. one left behind when the process
+// stopped mid-recording: it repairs the header, checks that the file has the format
+// of header and appends after its audio. The file keeps its own header chunks.
+func (w *wavWriter) resume(header WAVHeader) error {
+	path := w.writePath()
+	if err := RepairWAVHeader(path); err != nil {
+		return fmt.Errorf("repairing %s: %w", path, err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	existing, dataOffset, dataBytes, err := readWAVLayout(file)
+	if err != nil {
+		return err
+	}
+	if !sameFormat(existing, header) {
+		return fmt.Errorf("%s is %s, not %s", path, describeFormat(existing), describeFormat(header))
+	}
+
+	w.setFormat(header)
+	w.headerSize = int(dataOffset)
+	w.fileSize = dataOffset + dataBytes
+
+	// Only files laid out by WriteWAVHeader have the fact chunk where it is updated
+	fact := make([]byte, 4)
+	_, err = file.ReadAt(fact, factChunkID)
+	w.factChunk = header.Float && err == nil && string(fact) == "fact"
+	return nil
+}
+
+// sameFormat returns whether two WAV headers describe the same sample format
+func sameFormat(a, b WAVHeader) bool {
+	return a.SampleRate == b.SampleRate && a.Channels == b.Channels &&
+		a.BitsPerSample == b.BitsPerSample && a.Float == b.Float
+}
+
+// describeFormat names the sample format of a WAV header for messages
+func describeFormat(header WAVHeader) string {
+	kind := "PCM"
+	if header.Float {
+		kind = "float"
+	}
+	return fmt.Sprintf("%d Hz %d-channel %d-bit %s", header.SampleRate, header.Channels, header.BitsPerSample, kind)
+}
+
+// setFormat records the layout of a created file for the appends that follow
+func (w *wavWriter) setFormat(header WAVHeader) {
+	w.headerSize = HeaderSize(header)
+	w.channels = header.Channels
+	w.bitsPerSample = header.BitsPerSample
+	w.float = header.Float
+	w.factChunk = header.Float
+	w.minBlockSamples = int(int64(w.minBlock)*int64(header.SampleRate)/int64(time.Second)) * header.Channels
+	w.lastHeaderUpdate = time.Now()
+}
+
+// open returns where the audio goes, the sink or the file opened for writing, and
+// the function that closes it again
+func (w *wavWriter) open() (io.WriteSeeker, func() error, error) {
+	if w.sink != nil {
+		return w.sink, func() error { return nil }, nil
+	}
+	file, err := os.OpenFile(w.writePath(), os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file.Close, nil
+}
+
+// append safely appends audio data to the WAV file. With a minimum block the audio
+// is held until a whole block is ready; fileSize only counts what is in the file.
+func (w *wavWriter) append(samples []float32) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	// A partial frame would shift every following sample to the wrong channel
+	if err := ValidateFrames(samples, w.channels); err != nil {
+		return err
+	}
+
+	if w.minBlockSamples > 0 {
+		w.pending = append(w.pending, samples...)
+		if len(w.pending) < w.minBlockSamples {
+			return nil
+		}
+		samples = w.pending
+		defer func() { w.pending = w.pending[:0] }()
+	}
+
+	return w.write(samples)
+}
+
+// write appends samples to the file and updates the header and sync as configured
+func (w *wavWriter) write(samples []float32) error {
+	// Open file for appending
+	file, closeFile, err := w.open()
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+
+	// Seek to the end of the audio written so far
+	_, err = file.Seek(w.fileSize, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// Write audio data
+	var bytesWritten int
+	if w.float {
+		bytesWritten, err = WriteIEEEFloatSamples(file, samples)
+	} else {
+		bytesWritten, err = WritePCMSamples(file, samples, w.bitsPerSample)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Update file size
+	w.fileSize += int64(bytesWritten)
+
+	// Update the WAV header with new size, or leave it for a later append or finalize
+	w.headerStale = true
+	if w.headerInterval <= 0 || time.Since(w.lastHeaderUpdate) >= w.headerInterval {
+		if err := w.updateHeader(file); err != nil {
+			return err
+		}
+	}
+
+	// Push the data and header to disk once the sync interval has passed
+	if w.fsyncInterval > 0 && time.Since(w.lastSync) >= w.fsyncInterval {
+		if err := syncWriter(file); err != nil {
+			return err
+		}
+		w.lastSync = time.Now()
+	}
+
+	return nil
+}
+
+// updateHeader writes the current sizes into the open file's header
+func (w *wavWriter) updateHeader(file io.WriteSeeker) error {
+	dataSize := int(w.fileSize) - w.headerSize
+	if err := updateWAVSizes(file, w.headerSize, dataSize); err != nil {
+		return err
+	}
+	if w.factChunk {
+		if err := writeFactFrames(file, dataSize/(w.channels*4)); err != nil {
+			return err
+		}
+	}
+	w.headerStale = false
+	w.lastHeaderUpdate = time.Now()
+	return nil
+}
+
+// hasAudio returns whether any audio was appended, written or still held for a block
+func (w *wavWriter) hasAudio() bool {
+	return w.fileSize > int64(w.headerSize) || len(w.pending) > 0
+}
+
+// flushPending writes the audio held back for a minimum block
+func (w *wavWriter) flushPending() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	defer func() { w.pending = w.pending[:0] }()
+	return w.write(w.pending)
+}
+
+// finalize writes held audio and patches a header that appends left out of date
+func (w *wavWriter) finalize() error {
+	if err := w.flushPending(); err != nil {
+		return err
+	}
+	if !w.headerStale {
+		return nil
+	}
+
+	file, closeFile, err := w.open()
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+
+	return w.updateHeader(file)
+}
+
+// sync flushes a created file to disk regardless of the interval, if syncing is enabled
+func (w *wavWriter) sync() error {
+	if w.fsyncInterval <= 0 || w.fileSize == 0 {
+		return nil
+	}
+
+	file, closeFile, err := w.open()
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+
+	w.lastSync = time.Now()
+	return syncWriter(file)
+}
+
+// syncWriter flushes a writer that can be synced, such as a file, to storage
+func syncWriter(file io.WriteSeeker) error {
+	if syncer, ok := file.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memSink is an in-memory io.ReadWriteSeeker, like a buffer a caller records into
+type memSink struct {
+	data   []byte
+	offset int64
+}
+
+func (m *memSink) Write(p []byte) (int, error) {
+	if end := int(m.offset) + len(p); end > len(m.data) {
+		m.data = append(m.data, make([]byte, end-len(m.data))...)
+	}
+	n := copy(m.data[m.offset:], p)
+	m.offset += int64(n)
+	return n, nil
+}
+
+func (m *memSink) Read(p []byte) (int, error) {
+	if m.offset >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[m.offset:])
+	m.offset += int64(n)
+	return n, nil
+}
+
+func (m *memSink) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += m.offset
+	case io.SeekEnd:
+		offset += int64(len(m.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	m.offset = offset
+	return offset, nil
+}
+
+// readWAVBytes parses a WAV file held in memory
+func readWAVBytes(t *testing.T, data []byte) ([]float32, WAVHeader) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sink.wav")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	samples, header, err := ReadWAV(path)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	return samples, header
+}
+
+func TestWAVWriterSink(t *testing.T) {
+	tests := []struct {
+		name   string
+		header WAVHeader
+	}{
+		{"PCM16", WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 16}},
+		{"PCM24", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 24}},
+		{"Float", WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 32, Float: true}},
+		{"Bext", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16, Bext: &BextChunk{Description: "sink"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &memSink{}
+			w := &wavWriter{sink: sink}
+			if err := w.createSink(tc.header); err != nil {
+				t.Fatalf("createSink: %v", err)
+			}
+			if len(sink.data) != HeaderSize(tc.header) {
+				t.Fatalf("sink holds %d bytes after the header, want %d", len(sink.data), HeaderSize(tc.header))
+			}
+
+			first := []float32{0.5, -0.5, 0.25, -0.25}
+			second := []float32{0, 0.125, -1, 0.75}
+			for _, samples := range [][]float32{first, second} {
+				if err := w.append(samples); err != nil {
+					t.Fatalf("append: %v", err)
+				}
+			}
+			if err := w.finalize(); err != nil {
+				t.Fatalf("finalize: %v", err)
+			}
+
+			samples, header := readWAVBytes(t, sink.data)
+			if want := slices.Concat(first, second); !slices.Equal(samples, want) {
+				t.Errorf("samples = %v, want %v", samples, want)
+			}
+			if want := len(sink.data) - HeaderSize(tc.header); header.DataSize != want {
+				t.Errorf("header DataSize = %d, want %d", header.DataSize, want)
+			}
+			if tc.header.Float {
+				frames := int(sink.data[factFrameOffset]) | int(sink.data[factFrameOffset+1])<<8
+				if want := len(samples) / tc.header.Channels; frames != want {
+					t.Errorf("fact chunk counts %d frames, want %d", frames, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWAVWriterSinkRewrite(t *testing.T) {
+	// A sink holding older content is written from its start
+	sink := &memSink{data: bytes.Repeat([]byte{0xff}, 16), offset: 16}
+	w := &wavWriter{sink: sink}
+	header := WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16}
+	if err := w.createSink(header); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sink.data, []byte("RIFF")) {
+		t.Errorf("sink starts with %x, want the RIFF header", sink.data[:4])
+	}
+	if err := w.createSink(WAVHeader{SampleRate: 8000, Channels: 0, BitsPerSample: 16}); err == nil {
+		t.Error("createSink accepted 0 channels")
+	}
+}
+
+func TestWAVWriterResume(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  WAVHeader
+		trailer int // Bytes of a torn final frame left in the file
+	}{
+		{"PCM16", WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 16}, 0},
+		{"TornFrame", WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 16}, 3},
+		{"Float", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 32, Float: true}, 2},
+		{"Bext", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 24, Bext: &BextChunk{Description: "resume"}}, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "resume.wav")
+			frame := tc.header.Channels
+			first := make([]float32, 4*frame)
+			for i := range first {
+				first[i] = float32(i) / 16
+			}
+
+			// Record, then leave the header stale and a torn frame behind as a crash would
+			w := &wavWriter{filePath: path, headerInterval: 1 << 62}
+			if err := w.create(tc.header); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.append(first); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := file.Write(make([]byte, tc.trailer)); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			resumed := &wavWriter{filePath: path}
+			if err := resumed.resume(tc.header); err != nil {
+				t.Fatalf("resume: %v", err)
+			}
+			second := make([]float32, 2*frame)
+			for i := range second {
+				second[i] = -float32(i+1) / 8
+			}
+			if err := resumed.append(second); err != nil {
+				t.Fatal(err)
+			}
+			if err := resumed.finalize(); err != nil {
+				t.Fatal(err)
+			}
+
+			samples, header, err := ReadWAV(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := slices.Concat(first, second); !slices.Equal(samples, want) {
+				t.Errorf("samples = %v, want %v", samples, want)
+			}
+			if (header.Bext != nil) != (tc.header.Bext != nil) {
+				t.Errorf("resumed file lost or gained its bext chunk")
+			}
+		})
+	}
+}
+
+func TestWAVWriterResumeFormatMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mono.wav")
+	if err := CreateWAVFile(path, WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &wavWriter{filePath: path}
+	err := w.resume(WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 16})
+	if err == nil {
+		t.Fatal("resume accepted a file with a different channel count")
+	}
+	if want := "8000 Hz 1-channel 16-bit PCM"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not name the file's format %q", err, want)
+	}
+}
+
+func TestUpdateWAVHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header WAVHeader
+	}{
+		{"Standard", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16}},
+		{"Float", WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 32, Float: true}},
+		{"Tags", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16, Tags: map[string]string{"ICMT": "note"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "update.wav")
+			if err := CreateWAVFile(path, tc.header); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.OpenFile(path, os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			const dataSize = 64
+			if _, err := file.Seek(0, io.SeekEnd); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := file.Write(make([]byte, dataSize)); err != nil {
+				t.Fatal(err)
+			}
+			if err := UpdateWAVHeader(file, dataSize); err != nil {
+				t.Fatalf("UpdateWAVHeader: %v", err)
+			}
+
+			want := tc.header
+			want.DataSize = dataSize
+			var golden bytes.Buffer
+			if err := WriteWAVHeader(&golden, want); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, golden.Len())
+			if _, err := file.ReadAt(got, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, golden.Bytes()) {
+				t.Errorf("header bytes differ\n got %x\nwant %x", got, golden.Bytes())
+			}
+		})
+	}
+}
+
+func TestHeaderUpdateInterval(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    WAVHeader
+		interval  time.Duration
+		wantStale bool // Header still empty after the appends, before finalize
+	}{
+		{"EveryAppend", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16}, 0, false},
+		{"Skipped", WAVHeader{SampleRate: 8000, Channels: 1, BitsPerSample: 16}, time.Hour, true},
+		{"SkippedFloat", WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 32, Float: true}, time.Hour, true},
+		{"SkippedBext", WAVHeader{SampleRate: 8000, Channels: 2, BitsPerSample: 24, Bext: &BextChunk{Description: "interval"}},
+			time.Hour, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "interval.wav")
+			w := &wavWriter{filePath: path, headerInterval: tc.interval}
+			if err := w.create(tc.header); err != nil {
+				t.Fatal(err)
+			}
+			samples := make([]float32, 100*tc.header.Channels)
+			for range 3 {
+				if err := w.append(samples); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dataSize := 3 * len(samples) * tc.header.BitsPerSample / 8
+
+			// headerBytes returns the header as the file holds it and as it should read
+			// with the given data size
+			headerBytes := func(size int) (got, want []byte) {
+				t.Helper()
+				expected := tc.header
+				expected.DataSize = size
+				var golden bytes.Buffer
+				if err := WriteWAVHeader(&golden, expected); err != nil {
+					t.Fatal(err)
+				}
+				return mustReadFile(t, path)[:golden.Len()], golden.Bytes()
+			}
+
+			staleSize := dataSize
+			if tc.wantStale {
+				staleSize = 0
+			}
+			if got, want := headerBytes(staleSize); !bytes.Equal(got, want) {
+				t.Errorf("header before finalize differs\n got %x\nwant %x", got, want)
+			}
+
+			// Finishing the file always leaves the header correct
+			if err := w.finalize(); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := headerBytes(dataSize); !bytes.Equal(got, want) {
+				t.Errorf("final header differs\n got %x\nwant %x", got, want)
+			}
+			if read, _ := readWAVBytes(t, mustReadFile(t, path)); len(read) != 3*len(samples) {
+				t.Errorf("read %d samples, want %d", len(read), 3*len(samples))
+			}
+		})
+	}
+}
+
+// mustReadFile returns the contents of a file
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMinWriteBlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		channels    int
+		minBlock    time.Duration
+		appends     []int // Frames per append
+		wantWritten []int // Frames in the file after each append
+	}{
+		{"Off", 1, 0, []int{300, 300, 300, 300}, []int{300, 600, 900, 1200}},
+		// 100 ms is 800 frames at 8 kHz; appends wait until a block is ready
+		{"Coalesced", 1, 100 * time.Millisecond, []int{300, 300, 300, 300}, []int{0, 0, 900, 900}},
+		{"ExactBlock", 1, 100 * time.Millisecond, []int{400, 400, 400, 400}, []int{0, 800, 800, 1600}},
+		{"LargeAppend", 1, 100 * time.Millisecond, []int{100, 2000, 100}, []int{0, 2100, 2100}},
+		{"Stereo", 2, 100 * time.Millisecond, []int{500, 500, 500}, []int{0, 1000, 1000}},
+		// A block longer than the recording leaves everything to finalize
+		{"NeverFull", 1, time.Hour, []int{300, 300}, []int{0, 0}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			header := WAVHeader{SampleRate: 8000, Channels: tc.channels, BitsPerSample: 16}
+			sink := &memSink{}
+			w := &wavWriter{sink: sink, minBlock: tc.minBlock}
+			if err := w.createSink(header); err != nil {
+				t.Fatal(err)
+			}
+			frameBytes := tc.channels * 2
+
+			var want []float32
+			for i, frames := range tc.appends {
+				samples := ramp(len(want), frames*tc.channels)
+				for j := range samples {
+					samples[j] /= 1 << 15 // Exact in 16-bit PCM
+				}
+				want = append(want, samples...)
+				if err := w.append(samples); err != nil {
+					t.Fatal(err)
+				}
+				if written := (len(sink.data) - HeaderSize(header)) / frameBytes; written != tc.wantWritten[i] {
+					t.Errorf("after append %d the sink holds %d frames, want %d", i, written, tc.wantWritten[i])
+				}
+			}
+
+			// Finalize writes whatever is held, so every frame arrives once and in order
+			if err := w.finalize(); err != nil {
+				t.Fatal(err)
+			}
+			samples, read := readWAVBytes(t, sink.data)
+			if !slices.Equal(samples, want) {
+				t.Errorf("file holds %d samples, want the %d appended in order", len(samples), len(want))
+			}
+			if wantSize := len(want) * 2; read.DataSize != wantSize || len(sink.data)-HeaderSize(header) != wantSize {
+				t.Errorf("DataSize = %d with %d bytes of audio, want %d",
+					read.DataSize, len(sink.data)-HeaderSize(header), wantSize)
+			}
+			if len(w.pending) != 0 {
+				t.Errorf("%d samples still held after finalize", len(w.pending))
+			}
+		})
+	}
+}
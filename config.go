@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/galfthan/audiorecorder/audio"
+)
+
+// Settings holds the resolved command line tool configuration
+type Settings struct {
+	RecordingName       string
+	OutputFolder        string
+	SessionFolders      bool
+	ChunkDuration       int
+	MicIndex            int
+	MicName             string
+	Mics                []int
+	PreciseMix          bool
+	SampleRate          int
+	Channels            int
+	BitsPerSample       int
+	FloatWAV            bool
+	MixMode             audio.MixMode
+	MixTargetPeak       float32
+	MixHeadroomDB       float32
+	DownmixWeights      []float32
+	ChannelMap          []audio.ChannelSource
+	SilenceTimeout      int
+	TranscriptionOutput bool
+	ResampleQuality     audio.ResampleQuality
+	BroadcastWave       bool
+	StartTone           bool
+	RecordSeconds       int
+	FsyncSeconds        int
+	HeaderSeconds       int
+	WriteBlockSeconds   int
+	SeekIndexSeconds    int
+	PartialFiles        bool
+	PartSeconds         int
+	ResumePath          string
+	ArchiveFormat       string
+	VerifyLoopback      bool
+	Backend             string
+	ListBackends        bool
+	Extract             string
+	Segment             []float64
+	Monitor             string
+	MonitorLatencyMs    int
+	Control             string
+	EventLog            bool
+	SpeechEvents        bool
+	PrintConfig         bool
+	Tags                map[string]string
+	TagWAV              bool
+	Manifest            bool
+
+	explicit map[string]bool // Options given by a flag, env var or config file
+}
+
+// IsSet returns whether an option was given explicitly rather than left at its default.
+// Options that were not set are still asked for interactively.
+func (s Settings) IsSet(name string) bool {
+	return s.explicit[name]
+}
+
+// option describes one setting that can come from a flag, env var or config file
+type option struct {
+	name   string // Flag name and config file key
+	env    string // Environment variable name
+	usage  string
+	isBool bool
+	apply  func(s *Settings, value string) error
+}
+
+// options lists every setting in the order they are documented
+var options = []option{
+	{"name", "AUDIOREC_NAME", "base name for recordings", false, func(s *Settings, v string) error {
+		s.RecordingName = strings.ReplaceAll(v, " ", "_")
+		return nil
+	}},
+	{"out", "AUDIOREC_OUT", "folder recordings are saved to", false, func(s *Settings, v string) error {
+		s.OutputFolder = v
+		return nil
+	}},
+	{"session-folders", "AUDIOREC_SESSION_FOLDERS", "put each recording's files in their own <name>_<timestamp> folder", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.SessionFolders)
+	}},
+	{"duration", "AUDIOREC_DURATION", "seconds between saves (minimum 5)", false, func(s *Settings, v string) error {
+		return parseInt(v, 5, &s.ChunkDuration)
+	}},
+	{"mic", "AUDIOREC_MIC", "microphone device number", false, func(s *Settings, v string) error {
+		return parseInt(v, 0, &s.MicIndex)
+	}},
+	{"mic-name", "AUDIOREC_MIC_NAME", "use the microphone whose name contains this text (case-insensitive)", false, func(s *Settings, v string) error {
+		s.MicName = v
+		return nil
+	}},
+	{"mics", "AUDIOREC_MICS", "comma separated microphone numbers to record together, e.g. 0,2,3", false, func(s *Settings, v string) error {
+		return parseIntList(v, 0, &s.Mics)
+	}},
+	{"precise-mix", "AUDIOREC_PRECISE_MIX", "sum several microphones at float64 precision", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.PreciseMix)
+	}},
+	{"rate", "AUDIOREC_RATE", "sample rate in Hz, or native to record at the microphone's own rate", false, func(s *Settings, v string) error {
+		if strings.EqualFold(strings.TrimSpace(v), "native") {
+			s.SampleRate = 0
+			return nil
+		}
+		return parseInt(v, 8000, &s.SampleRate)
+	}},
+	{"channels", "AUDIOREC_CHANNELS", "number of capture channels", false, func(s *Settings, v string) error {
+		return parseInt(v, 1, &s.Channels)
+	}},
+	{"bits", "AUDIOREC_BITS", "bits per sample of the recording (16, 24 or 32)", false, func(s *Settings, v string) error {
+		if err := parseInt(v, 16, &s.BitsPerSample); err != nil {
+			return err
+		}
+		return audio.ValidateOutputBits(s.BitsPerSample)
+	}},
+	{"float-wav", "AUDIOREC_FLOAT_WAV", "write 32-bit float WAV files that keep the captured samples exactly", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.FloatWAV)
+	}},
+	{"mix", "AUDIOREC_MIX", "mix mode (average, weighted, sumlimit, stereo, duck, multitrack)", false, func(s *Settings, v string) error {
+		mode, err := audio.ParseMixMode(v)
+		s.MixMode = mode
+		return err
+	}},
+	{"mix-peak", "AUDIOREC_MIX_PEAK", "highest level of the mix as a fraction of full scale; below 1 sources are summed at full level and limited there", false, func(s *Settings, v string) error {
+		return parseFloat(v, 0.01, 1, &s.MixTargetPeak)
+	}},
+	{"mix-headroom", "AUDIOREC_MIX_HEADROOM", "dB of headroom to keep below the mix peak", false, func(s *Settings, v string) error {
+		return parseFloat(v, 0, 60, &s.MixHeadroomDB)
+	}},
+	{"downmix", "AUDIOREC_DOWNMIX", "comma separated per-channel weights for mono downmixes, e.g. 1,0 for left only (default average)", false, func(s *Settings, v string) error {
+		return parseFloatList(v, &s.DownmixWeights)
+	}},
+	{"channel-map", "AUDIOREC_CHANNEL_MAP", "comma separated source of each output channel (mic, speaker, mix), e.g. speaker,mic; implies -mix stereo", false, func(s *Settings, v string) error {
+		channelMap, err := audio.ParseChannelMap(v)
+		s.ChannelMap = channelMap
+		return err
+	}},
+	{"silence-stop", "AUDIOREC_SILENCE_STOP", "stop after this many seconds of silence (0 never)", false, func(s *Settings, v string) error {
+		return parseInt(v, 0, &s.SilenceTimeout)
+	}},
+	{"transcription-copy", "AUDIOREC_TRANSCRIPTION_COPY", "also save a 16kHz mono copy for transcription", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.TranscriptionOutput)
+	}},
+	{"resample", "AUDIOREC_RESAMPLE", "resampling quality for the transcription copy (fast, hq)", false, func(s *Settings, v string) error {
+		quality, err := audio.ParseResampleQuality(v)
+		s.ResampleQuality = quality
+		return err
+	}},
+	{"bwf", "AUDIOREC_BWF", "write Broadcast Wave (BWF) timecode", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.BroadcastWave)
+	}},
+	{"start-tone", "AUDIOREC_START_TONE", "begin the recording with a short beep for lining it up with video", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.StartTone)
+	}},
+	{"record-seconds", "AUDIOREC_RECORD_SECONDS", "record this many seconds, then save and exit (0 until stopped)", false, func(s *Settings, v string) error {
+		return parseInt(v, 0, &s.RecordSeconds)
+	}},
+	{"fsync", "AUDIOREC_FSYNC", "sync audio to disk on saves at least this many seconds apart (0 leaves it to the OS)", false, func(s *Settings, v string) error {
+		return parseInt(v, 0, &s.FsyncSeconds)
+	}},
+	{"header-seconds", "AUDIOREC_HEADER_SECONDS", "update the WAV header sizes on saves at least this many seconds apart (0 every save)", false, func(s *Settings, v string) error {
+		return parseInt(v, 0, &s.HeaderSeconds)
+	}},
+	{"write-block", "AUDIOREC_WRITE_BLOCK", "append audio to the file in blocks of at least this many seconds (0 on every save)", false, func(s *Settings, v string) error {
+		return parseInt(v, 0, &s.WriteBlockSeconds)
+	}},
+	{"seek-index", "AUDIOREC_SEEK_INDEX", "write a .idx seek index with the byte offset of every this many seconds (0 none)", false, func(s *Settings, v string) error {
+		return parseInt(v, 0, &s.SeekIndexSeconds)
+	}},
+	{"partial", "AUDIOREC_PARTIAL", "write files as .partial and rename them once finished", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.PartialFiles)
+	}},
+	{"part-seconds", "AUDIOREC_PART_SECONDS", "split the recording into files of this many seconds (0 one file; 600 with -archive)", false, func(s *Settings, v string) error {
+		return parseInt(v, 0, &s.PartSeconds)
+	}},
+	{"resume", "AUDIOREC_RESUME", "continue an interrupted recording in this WAV file; format options must match it", false, func(s *Settings, v string) error {
+		s.ResumePath = v
+		return nil
+	}},
+	{"archive", "AUDIOREC_ARCHIVE", "compress finished parts with ffmpeg to this format (flac, mp3, opus) and delete the WAV", false, func(s *Settings, v string) error {
+		s.ArchiveFormat = strings.TrimPrefix(strings.ToLower(v), ".")
+		return nil
+	}},
+	{"verify-loopback", "AUDIOREC_VERIFY_LOOPBACK", "play a test tone, check the speaker loopback hears it, then exit", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.VerifyLoopback)
+	}},
+	{"backend", "AUDIOREC_BACKEND", "audio backend to use instead of the platform default, e.g. dsound or alsa (see -list-backends)", false, func(s *Settings, v string) error {
+		if _, err := audio.ParseBackend(v); err != nil {
+			return err
+		}
+		s.Backend = strings.ToLower(strings.TrimSpace(v))
+		return nil
+	}},
+	{"list-backends", "AUDIOREC_LIST_BACKENDS", "list the audio backends of this platform and whether each works, then exit", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.ListBackends)
+	}},
+	{"extract", "AUDIOREC_EXTRACT", "save the -segment of this WAV recording as a clip next to it, then exit", false, func(s *Settings, v string) error {
+		s.Extract = v
+		return nil
+	}},
+	{"segment", "AUDIOREC_SEGMENT", "start and end in seconds of the segment to -extract, e.g. 12.5,20", false, func(s *Settings, v string) error {
+		return parseSegment(v, &s.Segment)
+	}},
+	{"monitor", "AUDIOREC_MONITOR", "play the mix of microphones and speaker on this output device (by name) for monitoring; not the default output, which the speaker recording captures", false, func(s *Settings, v string) error {
+		s.Monitor = v
+		return nil
+	}},
+	{"monitor-latency", "AUDIOREC_MONITOR_LATENCY", "most audio in milliseconds queued for the monitor before it is dropped", false, func(s *Settings, v string) error {
+		return parseInt(v, 10, &s.MonitorLatencyMs)
+	}},
+	{"control", "AUDIOREC_CONTROL", "serve an HTTP control API on this address (e.g. :9000) instead of recording right away", false, func(s *Settings, v string) error {
+		s.Control = v
+		return nil
+	}},
+	{"event-log", "AUDIOREC_EVENT_LOG", "write start, rotation, marker, clip, device and stop events to <name>_<timestamp>.log", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.EventLog)
+	}},
+	{"speech-events", "AUDIOREC_SPEECH_EVENTS", "log when speech starts and stops (with -event-log)", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.SpeechEvents)
+	}},
+	{"tag", "AUDIOREC_TAG", "label the recording with key=value, stored in its manifest; repeat or separate with commas for more", false, func(s *Settings, v string) error {
+		return parseTags(v, &s.Tags)
+	}},
+	{"tag-wav", "AUDIOREC_TAG_WAV", "also store the tags in a LIST/INFO chunk of each WAV file", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.TagWAV)
+	}},
+	{"manifest", "AUDIOREC_MANIFEST", "write <name>_<timestamp>.json listing the recording's files, format and tags (always written with -tag)", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.Manifest)
+	}},
+	{"print-config", "AUDIOREC_PRINT_CONFIG", "print the resolved configuration and negotiated device formats as JSON to stdout when recording starts", true, func(s *Settings, v string) error {
+		return parseBool(v, &s.PrintConfig)
+	}},
+}
+
+// flagValue captures the raw text of a flag so it can be applied like the other sources.
+// A flag given more than once keeps every value, applied in order.
+type flagValue struct {
+	values []string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if len(f.values) == 0 {
+		return ""
+	}
+	return f.values[len(f.values)-1]
+}
+
+func (f *flagValue) Set(v string) error { f.values = append(f.values, v); return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+
+// ResolveConfig builds the settings from defaults, a config file, environment variables
+// and command line flags, in increasing order of precedence. The config file is taken
+// from -config, then AUDIOREC_CONFIG, then ~/.audiorecorder.conf if it exists.
+// A positional argument is used as the recording name; flags may come before or
+// after it, e.g. "audiorecorder meeting -duration 5".
+func ResolveConfig(args []string, getenv func(string) string) (Settings, error) {
+	homeDir, _ := os.UserHomeDir()
+	settings := Settings{
+		RecordingName:    "recording",
+		OutputFolder:     filepath.Join(homeDir, "AudioRecordings"),
+		ChunkDuration:    30,
+		SampleRate:       16000,
+		Channels:         1,
+		BitsPerSample:    16,
+		MixMode:          audio.MixAverage,
+		MixTargetPeak:    1,
+		MonitorLatencyMs: 100,
+		explicit:         make(map[string]bool),
+	}
+
+	// Parse flags first so -config is known, but apply them last
+	flags := flag.NewFlagSet("audiorecorder", flag.ContinueOnError)
+	configPath := flags.String("config", "", "config file with key = value lines (env AUDIOREC_CONFIG)")
+	values := make(map[string]*flagValue)
+	for _, opt := range options {
+		values[opt.name] = &flagValue{isBool: opt.isBool}
+		flags.Var(values[opt.name], opt.name, fmt.Sprintf("%s (env %s)", opt.usage, opt.env))
+	}
+
+	// The flag package stops at the first non-flag argument, so parse again after the name
+	var names []string
+	for remaining := args; ; remaining = flags.Args()[1:] {
+		if err := flags.Parse(remaining); err != nil {
+			return settings, err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		if len(names) > 0 {
+			return settings, fmt.Errorf("unexpected argument %q after recording name %q", flags.Arg(0), names[0])
+		}
+		names = append(names, flags.Arg(0))
+	}
+
+	// Config file
+	path := *configPath
+	if path == "" {
+		path = getenv("AUDIOREC_CONFIG")
+	}
+	if path == "" {
+		defaultPath := filepath.Join(homeDir, ".audiorecorder.conf")
+		if _, err := os.Stat(defaultPath); err == nil {
+			path = defaultPath
+		}
+	}
+	if path != "" {
+		fileValues, err := readConfigFile(path)
+		if err != nil {
+			return settings, err
+		}
+		for _, opt := range options {
+			if value, ok := fileValues[opt.name]; ok {
+				if err := settings.apply(opt, value, "config file "+path); err != nil {
+					return settings, err
+				}
+			}
+		}
+	}
+
+	// Environment variables
+	for _, opt := range options {
+		if value := getenv(opt.env); value != "" {
+			if err := settings.apply(opt, value, "environment variable "+opt.env); err != nil {
+				return settings, err
+			}
+		}
+	}
+
+	// Command line flags, then the positional recording name
+	setFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	for _, opt := range options {
+		if setFlags[opt.name] {
+			for _, value := range values[opt.name].values {
+				if err := settings.apply(opt, value, "flag -"+opt.name); err != nil {
+					return settings, err
+				}
+			}
+		}
+	}
+	if len(names) > 0 {
+		nameOption := options[0]
+		if err := settings.apply(nameOption, names[0], "command line"); err != nil {
+			return settings, err
+		}
+	}
+
+	return settings, nil
+}
+
+// apply sets one option and marks it as explicitly configured
+func (s *Settings) apply(opt option, value, source string) error {
+	if err := opt.apply(s, strings.TrimSpace(value)); err != nil {
+		return fmt.Errorf("invalid %s from %s: %v", opt.name, source, err)
+	}
+	s.explicit[opt.name] = true
+	return nil
+}
+
+// readConfigFile reads key = value lines, ignoring blank lines and # comments
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNumber)
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return values, scanner.Err()
+}
+
+// parseInt parses an integer that must be at least minimum
+func parseInt(value string, minimum int, target *int) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if n < minimum {
+		return fmt.Errorf("must be at least %d, got %d", minimum, n)
+	}
+	*target = n
+	return nil
+}
+
+// parseIntList parses a comma separated list of integers that must each be at least minimum
+func parseIntList(value string, minimum int, target *[]int) error {
+	var list []int
+	for _, field := range strings.Split(value, ",") {
+		var n int
+		if err := parseInt(strings.TrimSpace(field), minimum, &n); err != nil {
+			return err
+		}
+		list = append(list, n)
+	}
+	*target = list
+	return nil
+}
+
+// parseFloat parses a number in the range [minimum, maximum]
+func parseFloat(value string, minimum, maximum float32, target *float32) error {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 32)
+	if err != nil {
+		return err
+	}
+	if float32(f) < minimum || float32(f) > maximum {
+		return fmt.Errorf("must be between %g and %g, got %g", minimum, maximum, f)
+	}
+	*target = float32(f)
+	return nil
+}
+
+// parseFloatList parses a comma separated list of numbers
+func parseFloatList(value string, target *[]float32) error {
+	var list []float32
+	for _, field := range strings.Split(value, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+		if err != nil {
+			return err
+		}
+		list = append(list, float32(f))
+	}
+	*target = list
+	return nil
+}
+
+// parseSegment parses a start and end time in seconds separated by a comma
+func parseSegment(value string, target *[]float64) error {
+	fields := strings.Split(value, ",")
+	if len(fields) != 2 {
+		return fmt.Errorf("expected start,end in seconds, got %q", value)
+	}
+	segment := make([]float64, 2)
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return err
+		}
+		segment[i] = f
+	}
+	if segment[1] < segment[0] {
+		return fmt.Errorf("segment ends before it starts: %q", value)
+	}
+	*target = segment
+	return nil
+}
+
+// parseTags adds comma separated key=value tags to target, replacing tags with the same key
+func parseTags(value string, target *map[string]string) error {
+	tags := maps.Clone(*target)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	for _, field := range strings.Split(value, ",") {
+		key, tagValue, found := strings.Cut(field, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return fmt.Errorf("expected key=value, got %q", field)
+		}
+		tags[key] = strings.TrimSpace(tagValue)
+	}
+	if err := audio.ValidateTags(tags); err != nil {
+		return err
+	}
+	*target = tags
+	return nil
+}
+
+// parseBool parses a boolean such as true/false, 1/0 or y/n
+func parseBool(value string, target *bool) error {
+	switch strings.ToLower(value) {
+	case "y", "yes":
+		*target = true
+		return nil
+	case "n", "no":
+		*target = false
+		return nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*target = b
+	return nil
+}
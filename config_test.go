@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// resolveTestConfig resolves settings from args, a config file with the given lines
+// (none when empty) and environment variables, isolated from the user's home
+func resolveTestConfig(t *testing.T, args []string, file string, env map[string]string) (Settings, error) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	if file != "" {
+		path := filepath.Join(t.TempDir(), "audiorecorder.conf")
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", path}, args...)
+	}
+	return ResolveConfig(args, func(name string) string { return env[name] })
+}
+
+func TestResolveConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		file      string
+		env       map[string]string
+		wantChunk int
+		wantRate  int
+		wantName  string
+		wantSet   bool // Whether duration counts as explicitly set
+	}{
+		{"Defaults", nil, "", nil, 30, 16000, "recording", false},
+		{"ConfigFile", nil, "duration = 10\nrate = 48000\n", nil, 10, 48000, "recording", true},
+		{"EnvOverFile", nil, "duration = 10\nrate = 48000\n",
+			map[string]string{"AUDIOREC_DURATION": "20"}, 20, 48000, "recording", true},
+		{"FlagOverEnv", []string{"-duration", "5"}, "",
+			map[string]string{"AUDIOREC_DURATION": "20"}, 5, 16000, "recording", true},
+		{"FlagOverEnvAndFile", []string{"-duration=5"}, "duration = 10\nname = filed\n",
+			map[string]string{"AUDIOREC_DURATION": "20", "AUDIOREC_RATE": "44100"}, 5, 44100, "filed", true},
+		{"LastFlagWins", []string{"-duration", "5", "-duration", "7"}, "", nil, 7, 16000, "recording", true},
+		{"PositionalName", []string{"meeting"}, "name = filed\n",
+			map[string]string{"AUDIOREC_NAME": "env"}, 30, 16000, "meeting", false},
+		{"PositionalNameOverFlag", []string{"-name", "flagged", "meeting"}, "", nil, 30, 16000, "meeting", false},
+		{"FlagsAfterName", []string{"meeting", "-duration", "5", "-rate", "8000"}, "", nil, 5, 8000, "meeting", true},
+		{"FlagsAroundName", []string{"-rate", "8000", "meeting", "-duration", "5"}, "", nil, 5, 8000, "meeting", true},
+		{"FlagTerminator", []string{"--", "-meeting"}, "", nil, 30, 16000, "-meeting", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := resolveTestConfig(t, tc.args, tc.file, tc.env)
+			if err != nil {
+				t.Fatalf("ResolveConfig: %v", err)
+			}
+			if settings.ChunkDuration != tc.wantChunk {
+				t.Errorf("ChunkDuration = %d, want %d", settings.ChunkDuration, tc.wantChunk)
+			}
+			if settings.SampleRate != tc.wantRate {
+				t.Errorf("SampleRate = %d, want %d", settings.SampleRate, tc.wantRate)
+			}
+			if settings.RecordingName != tc.wantName {
+				t.Errorf("RecordingName = %q, want %q", settings.RecordingName, tc.wantName)
+			}
+			if settings.IsSet("duration") != tc.wantSet {
+				t.Errorf("IsSet(duration) = %v, want %v", settings.IsSet("duration"), tc.wantSet)
+			}
+		})
+	}
+}
+
+func TestResolveConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"SecondName", []string{"meeting", "notes"}, "", nil, `unexpected argument "notes"`},
+		{"SecondNameAfterFlags", []string{"meeting", "-duration", "5", "notes"}, "", nil, `unexpected argument "notes"`},
+		{"UnknownFlagAfterName", []string{"meeting", "-bogus"}, "", nil, "bogus"},
+		{"InvalidFlag", []string{"-duration", "soon"}, "", nil, "flag -duration"},
+		{"InvalidEnv", nil, "", map[string]string{"AUDIOREC_RATE": "fast"}, "environment variable AUDIOREC_RATE"},
+		{"InvalidFile", nil, "channels = many\n", nil, "config file"},
+		{"MalformedFile", nil, "duration 10\n", nil, "expected key = value"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := resolveTestConfig(t, tc.args, tc.file, tc.env)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveConfigFileLocation(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	write := func(path, content string) string {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write(filepath.Join(home, ".audiorecorder.conf"), "name = home\n")
+	envPath := write(filepath.Join(t.TempDir(), "env.conf"), "name = env\n")
+	flagPath := write(filepath.Join(t.TempDir(), "flag.conf"), "name = flag\n")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"Home", nil, nil, "home"},
+		{"Env", nil, map[string]string{"AUDIOREC_CONFIG": envPath}, "env"},
+		{"Flag", []string{"-config", flagPath}, map[string]string{"AUDIOREC_CONFIG": envPath}, "flag"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := ResolveConfig(tc.args, func(name string) string { return tc.env[name] })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings.RecordingName != tc.want {
+				t.Errorf("RecordingName = %q from the %s config, want %q", settings.RecordingName, tc.name, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// controlServer drives recordings from HTTP requests. It owns at most one session
+// at a time and serializes commands, so conflicting requests are rejected rather
+// than racing each other.
+type controlServer struct {
+	start   func() (*session, error)
+	current *session // Latest session, kept after it stops so its status stays readable
+	mutex   sync.Mutex
+}
+
+// controlStatus is the JSON body describing the recorder state
+type controlStatus struct {
+	Recording       bool    `json:"recording"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	File            string  `json:"file,omitempty"`
+	MicLevel        float32 `json:"mic_level"`
+	SpeakerLevel    float32 `json:"speaker_level"`
+	ClipCount       int64   `json:"clip_count"`
+	SamplesWritten  int64   `json:"samples_written"`
+	NonFinite       int64   `json:"non_finite_samples"`
+	Markers         int     `json:"markers"`
+	MicRates        []int   `json:"mic_rates,omitempty"`    // Rate each microphone delivers at, 0 before it has
+	SpeakerRate     int     `json:"speaker_rate,omitempty"` // Rate the speaker delivers at, 0 before it has
+}
+
+// controlMarker is the JSON body describing a marker that was added
+type controlMarker struct {
+	Label         string  `json:"label"`
+	OffsetSeconds float64 `json:"offset_seconds"`
+}
+
+// newControlServer creates a control server that calls start for each new recording
+func newControlServer(start func() (*session, error)) *controlServer {
+	return &controlServer{start: start}
+}
+
+// Handler returns the HTTP routes of the control API
+func (c *controlServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /start", c.handleStart)
+	mux.HandleFunc("POST /stop", c.handleStop)
+	mux.HandleFunc("GET /status", c.handleStatus)
+	mux.HandleFunc("POST /marker", c.handleMarker)
+	mux.HandleFunc("POST /mic", c.handleMic)
+	return mux
+}
+
+// handleStart starts a new recording unless one is already running
+func (c *controlServer) handleStart(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.recording() {
+		writeControlError(w, http.StatusConflict, "already recording")
+		return
+	}
+
+	s, err := c.start()
+	if err != nil {
+		writeControlError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.current = s
+
+	// Release the devices when the recorder stops itself after a long silence
+	go func() {
+		<-s.recorder.Done()
+		s.stop()
+	}()
+
+	writeControlJSON(w, http.StatusOK, c.status())
+}
+
+// handleStop stops the running recording and saves it
+func (c *controlServer) handleStop(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.recording() {
+		writeControlError(w, http.StatusConflict, "not recording")
+		return
+	}
+
+	status := c.status()
+	c.current.stop()
+	status.Recording = false
+
+	writeControlJSON(w, http.StatusOK, status)
+}
+
+// handleStatus reports the state of the current or last recording
+func (c *controlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	writeControlJSON(w, http.StatusOK, c.status())
+}
+
+// handleMarker labels the current moment of the running recording.
+// The label comes from the "label" query or form value.
+func (c *controlServer) handleMarker(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.recording() {
+		writeControlError(w, http.StatusConflict, "not recording")
+		return
+	}
+
+	marker := c.current.recorder.AddMarker(r.FormValue("label"))
+	writeControlJSON(w, http.StatusCreated, controlMarker{
+		Label:         marker.Label,
+		OffsetSeconds: marker.Offset.Seconds(),
+	})
+}
+
+// handleMic switches a microphone of the running recording to another capture
+// device. The "device" value is part of the new device's name and the optional
+// "mic" value picks which microphone to switch, counting from 1.
+func (c *controlServer) handleMic(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.recording() {
+		writeControlError(w, http.StatusConflict, "not recording")
+		return
+	}
+
+	mic := 1
+	if value := r.FormValue("mic"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, "invalid mic: "+value)
+			return
+		}
+		mic = n
+	}
+	device := r.FormValue("device")
+	if device == "" {
+		writeControlError(w, http.StatusBadRequest, "missing device")
+		return
+	}
+
+	if err := c.current.switchMicrophone(mic-1, device); err != nil {
+		writeControlError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeControlJSON(w, http.StatusOK, c.status())
+}
+
+// recording returns whether the current session is still recording
+func (c *controlServer) recording() bool {
+	return c.current != nil && c.current.recorder.IsRecording()
+}
+
+// status describes the current session; levels and duration are only reported while recording
+func (c *controlServer) status() controlStatus {
+	if c.current == nil {
+		return controlStatus{}
+	}
+
+	recorder := c.current.recorder
+	stats := recorder.Stats()
+	status := controlStatus{
+		Recording:      stats.Recording,
+		File:           stats.File,
+		ClipCount:      stats.ClipCount,
+		SamplesWritten: stats.SamplesWritten,
+		NonFinite:      stats.NonFiniteSamples,
+		Markers:        len(recorder.Markers()),
+		MicRates:       stats.MicRates,
+		SpeakerRate:    stats.SpeakerRate,
+	}
+	if status.Recording {
+		status.DurationSeconds = stats.Duration.Seconds()
+		status.MicLevel = slices.Max(append(stats.MicLevels, 0))
+		status.SpeakerLevel = stats.SpeakerLevel
+	}
+	return status
+}
+
+// stopCurrent stops the running recording, if any
+func (c *controlServer) stopCurrent() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.current != nil {
+		c.current.stop()
+	}
+}
+
+// writeControlJSON writes a JSON response with the given status code
+func writeControlJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeControlError writes a JSON error response
+func writeControlError(w http.ResponseWriter, code int, message string) {
+	writeControlJSON(w, code, map[string]string{"error": message})
+}
+
+// serveControl runs the control API on address until Ctrl+C, then stops any
+// recording in progress. It returns an error if the server cannot listen.
+func serveControl(address string, start func() (*session, error)) error {
+	control := newControlServer(start)
+	server := &http.Server{Addr: address, Handler: control.Handler()}
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- server.ListenAndServe()
+	}()
+	fmt.Fprintln(os.Stderr, "Control API listening on", address)
+	fmt.Fprintln(os.Stderr, "Press Ctrl+C to stop recording and exit...")
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	var err error
+	select {
+	case err = <-serverErrors:
+	case <-c:
+		// Let requests in flight finish before the recording is finalized
+		shutdownContext, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		server.Shutdown(shutdownContext)
+		cancel()
+	}
+
+	control.stopCurrent()
+	return err
+}
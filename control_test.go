@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/galfthan/audiorecorder/audio"
+)
+
+// newTestSession starts a recording into folder without audio devices: the test
+// feeds the recorder itself, as the capture callbacks would
+func newTestSession(folder string) (*session, error) {
+	config := audio.RecordingConfig{
+		ChunkDurationSeconds: 1,
+		OutputFolder:         folder,
+		RecordingName:        "control",
+		SampleRate:           8000,
+		Channels:             1,
+	}
+	recorder, err := audio.NewRecorderE(config)
+	if err != nil {
+		return nil, err
+	}
+	recorder.DisableSpeaker()
+	if err := recorder.StartRecording(); err != nil {
+		return nil, fmt.Errorf("starting recording: %w", err)
+	}
+	return &session{config: config, recorder: recorder}, nil
+}
+
+// serveControlRequest sends one request to the control API and decodes its JSON body
+func serveControlRequest(t *testing.T, handler http.Handler, method, target string) (int, map[string]any) {
+	t.Helper()
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+
+	var body map[string]any
+	if response.Code != http.StatusMethodNotAllowed {
+		if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, target, err)
+		}
+	}
+	return response.Code, body
+}
+
+func TestControlServer(t *testing.T) {
+	tests := []struct {
+		name      string
+		recording bool // Start a recording before the request
+		method    string
+		target    string
+		wantCode  int
+		wantBody  map[string]any // Fields the response must have
+		wantAfter bool           // Whether a recording runs after the request
+	}{
+		{"StatusIdle", false, "GET", "/status", http.StatusOK,
+			map[string]any{"recording": false}, false},
+		{"StatusRecording", true, "GET", "/status", http.StatusOK,
+			map[string]any{"recording": true}, true},
+		{"Start", false, "POST", "/start", http.StatusOK,
+			map[string]any{"recording": true}, true},
+		{"StartWhileRecording", true, "POST", "/start", http.StatusConflict,
+			map[string]any{"error": "already recording"}, true},
+		{"Stop", true, "POST", "/stop", http.StatusOK,
+			map[string]any{"recording": false}, false},
+		{"StopWhileIdle", false, "POST", "/stop", http.StatusConflict,
+			map[string]any{"error": "not recording"}, false},
+		{"Marker", true, "POST", "/marker?label=intro", http.StatusCreated,
+			map[string]any{"label": "intro"}, true},
+		{"MarkerWhileIdle", false, "POST", "/marker?label=intro", http.StatusConflict,
+			map[string]any{"error": "not recording"}, false},
+		{"MicMissingDevice", true, "POST", "/mic", http.StatusBadRequest,
+			map[string]any{"error": "missing device"}, true},
+		{"MicInvalidIndex", true, "POST", "/mic?mic=first&device=usb", http.StatusBadRequest,
+			map[string]any{"error": "invalid mic: first"}, true},
+		{"MicUnknownIndex", true, "POST", "/mic?mic=2&device=usb", http.StatusBadRequest,
+			map[string]any{"error": "no microphone 2"}, true},
+		{"MicWhileIdle", false, "POST", "/mic?device=usb", http.StatusConflict,
+			map[string]any{"error": "not recording"}, false},
+		{"StartWrongMethod", false, "GET", "/start", http.StatusMethodNotAllowed, nil, false},
+		{"StopWrongMethod", true, "GET", "/stop", http.StatusMethodNotAllowed, nil, true},
+		{"StatusWrongMethod", false, "POST", "/status", http.StatusMethodNotAllowed, nil, false},
+		{"MarkerWrongMethod", true, "GET", "/marker", http.StatusMethodNotAllowed, nil, true},
+		{"MicWrongMethod", true, "PUT", "/mic", http.StatusMethodNotAllowed, nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			control := newControlServer(func() (*session, error) { return newTestSession(t.TempDir()) })
+			t.Cleanup(control.stopCurrent)
+			handler := control.Handler()
+
+			if tc.recording {
+				if code, body := serveControlRequest(t, handler, "POST", "/start"); code != http.StatusOK {
+					t.Fatalf("starting the recording: %d %v", code, body)
+				}
+			}
+
+			code, body := serveControlRequest(t, handler, tc.method, tc.target)
+			if code != tc.wantCode {
+				t.Errorf("status code = %d, want %d (%v)", code, tc.wantCode, body)
+			}
+			for field, want := range tc.wantBody {
+				if body[field] != want {
+					t.Errorf("%s = %v, want %v", field, body[field], want)
+				}
+			}
+
+			control.mutex.Lock()
+			recording := control.recording()
+			control.mutex.Unlock()
+			if recording != tc.wantAfter {
+				t.Errorf("recording after the request = %v, want %v", recording, tc.wantAfter)
+			}
+		})
+	}
+}
+
+func TestControlServerStartFailure(t *testing.T) {
+	// A file where the output folder should be, so the recording can't be created
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		start     func() (*session, error)
+		wantError string // Prefix of the reported error
+	}{
+		{"NoDevice", func() (*session, error) { return nil, errors.New("no microphone found") },
+			"no microphone found"},
+		{"OutputUnwritable", func() (*session, error) { return newTestSession(blocked) },
+			"starting recording: initializing WAV file"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			control := newControlServer(tc.start)
+			code, body := serveControlRequest(t, control.Handler(), "POST", "/start")
+			message, _ := body["error"].(string)
+			if code != http.StatusInternalServerError || !strings.HasPrefix(message, tc.wantError) {
+				t.Errorf("start failure = %d %v, want 500 with %q", code, body, tc.wantError)
+			}
+			if control.recording() {
+				t.Error("a failed start left a recording running")
+			}
+		})
+	}
+}
+
+func TestControlServerRestart(t *testing.T) {
+	control := newControlServer(func() (*session, error) { return newTestSession(t.TempDir()) })
+	t.Cleanup(control.stopCurrent)
+	handler := control.Handler()
+
+	var files []any
+	for range 2 {
+		if code, body := serveControlRequest(t, handler, "POST", "/start"); code != http.StatusOK {
+			t.Fatalf("start = %d %v", code, body)
+		}
+		serveControlRequest(t, handler, "POST", "/marker?label=cue")
+		code, body := serveControlRequest(t, handler, "POST", "/stop")
+		if code != http.StatusOK || body["markers"] != float64(1) {
+			t.Fatalf("stop = %d %v, want 200 with the marker counted", code, body)
+		}
+		files = append(files, body["file"])
+	}
+
+	// The last recording stays readable after it stopped
+	_, status := serveControlRequest(t, handler, "GET", "/status")
+	if status["recording"] != false || status["file"] != files[1] {
+		t.Errorf("status after stop = %v, want the stopped recording %v", status, files[1])
+	}
+	if files[0] == files[1] {
+		t.Errorf("both recordings wrote %v", files[0])
+	}
+}
@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
@@ -17,309 +15,631 @@ import (
 )
 
 func main() {
-	// Get custom filename from command line arguments
-	recordingName := "recording" // Default name
-	if len(os.Args) > 1 {
-		// Use the first argument as the recording name
-		recordingName = os.Args[1]
-		// Replace spaces with underscores for filename
-		recordingName = strings.ReplaceAll(recordingName, " ", "_")
-	}
-
-	// Create output folder in user's home directory
-	homeDir, _ := os.UserHomeDir()
-	outputFolder := filepath.Join(homeDir, "AudioRecordings")
+	// Resolve settings from flags, environment variables and config file
+	settings, err := ResolveConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		waitForExit()
+		return
+	}
+
+	// Cut a clip out of an existing recording instead of recording
+	if settings.Extract != "" {
+		if !extractClip(settings.Extract, settings.Segment) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	recordingName := settings.RecordingName
+	outputFolder := settings.OutputFolder
+
+	// Create output folder
 	os.MkdirAll(outputFolder, 0755)
 
-	// Initialize audio context
-	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, func(message string) {
-		fmt.Println("AUDIO:", message)
+	// Show which backends work instead of recording
+	if settings.ListBackends {
+		listBackends()
+		return
+	}
+
+	// Initialize audio context, with the chosen backend or the platform's default order
+	var backends []malgo.Backend
+	if settings.Backend != "" {
+		backend, _ := audio.ParseBackend(settings.Backend)
+		backends = []malgo.Backend{backend}
+	}
+	ctx, err := malgo.InitContext(backends, malgo.ContextConfig{}, func(message string) {
+		fmt.Fprintln(os.Stderr, "AUDIO:", message)
 	})
+	if err != nil && settings.Backend != "" {
+		fmt.Fprintf(os.Stderr, "Audio backend %s is not available: %v\n", settings.Backend, err)
+		fmt.Fprintln(os.Stderr, "Run with -list-backends to see the backends that work here.")
+		waitForExit()
+		return
+	}
 	if err != nil {
-		fmt.Println("Failed to initialize audio context:", err)
-		fmt.Println("Press Enter to exit...")
-		fmt.Scanln()
+		fmt.Fprintln(os.Stderr, "Failed to initialize audio context:", err)
+		waitForExit()
 		return
 	}
 	defer ctx.Free()
 
-	fmt.Println("Continuous Audio Recorder")
-	fmt.Println("----------------------------------------")
+	fmt.Fprintln(os.Stderr, "Continuous Audio Recorder")
+	fmt.Fprintln(os.Stderr, "----------------------------------------")
 
 	// List available audio devices
-	fmt.Println("\nAVAILABLE MICROPHONES:")
+	fmt.Fprintln(os.Stderr, "\nAVAILABLE MICROPHONES:")
 	captureDevices, err := ctx.Devices(malgo.Capture)
 	if err != nil {
-		fmt.Println("Error listing capture devices:", err)
+		fmt.Fprintln(os.Stderr, "Error listing capture devices:", err)
 	} else if len(captureDevices) == 0 {
-		fmt.Println("No capture devices found!")
+		fmt.Fprintln(os.Stderr, "No capture devices found!")
 	} else {
 		for i, device := range captureDevices {
-			fmt.Printf("%d: %s\n", i, device.Name())
+			fmt.Fprintf(os.Stderr, "%d: %s\n", i, device.Name())
 		}
 	}
 
-	fmt.Println("\nAVAILABLE SPEAKERS (LOOPBACK):")
+	fmt.Fprintln(os.Stderr, "\nAVAILABLE SPEAKERS (LOOPBACK):")
 	loopbackDevices, err := ctx.Devices(malgo.Loopback)
 	if err != nil {
-		fmt.Println("Error listing loopback devices:", err)
+		fmt.Fprintln(os.Stderr, "Error listing loopback devices:", err)
 	} else if len(loopbackDevices) == 0 {
-		fmt.Println("No loopback devices found!")
+		fmt.Fprintln(os.Stderr, "No loopback devices found!")
 	} else {
 		for i, device := range loopbackDevices {
-			fmt.Printf("%d: %s\n", i, device.Name())
+			fmt.Fprintf(os.Stderr, "%d: %s\n", i, device.Name())
 		}
 	}
 
+	// Run the loopback self-test instead of recording when asked
+	if settings.VerifyLoopback {
+		loopbackRate := settings.SampleRate
+		if loopbackRate == 0 {
+			loopbackRate, err = audio.NativeSampleRate(ctx.Context, malgo.Loopback, nil)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Could not read the speaker's native sample rate:", err)
+				ctx.Free()
+				os.Exit(1)
+			}
+		}
+		if !checkLoopback(ctx.Context, loopbackRate, settings.Channels) {
+			ctx.Free()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Nothing to record from; say so before asking about anything else
+	if len(captureDevices) == 0 {
+		fmt.Fprintln(os.Stderr, "\nNo capture devices to record from; connect a microphone and try again.")
+		waitForExit()
+		return
+	}
+
 	// Show current recording name
-	fmt.Printf("\nRecording name: %s\n", recordingName)
+	fmt.Fprintf(os.Stderr, "\nRecording name: %s\n", recordingName)
 
-	// Ask user for continuous recording settings
-	fmt.Print("\nEnter duration between saves (in seconds, default 30): ")
-	chunkDuration := 30 // Default 30 seconds
+	// Ask user for any settings not given on the command line, environment or config file.
+	// A control API service runs unattended, so it uses the defaults instead.
+	interactive := settings.Control == ""
 	var input string
-	fmt.Scanln(&input)
-	if input != "" {
-		fmt.Sscanf(input, "%d", &chunkDuration)
-		if chunkDuration < 5 {
-			fmt.Println("Duration too short, using minimum of 5 seconds.")
-			chunkDuration = 5
+	chunkDuration := settings.ChunkDuration
+	if interactive && !settings.IsSet("duration") {
+		fmt.Fprintf(os.Stderr, "\nEnter duration between saves (in seconds, default %d): ", chunkDuration)
+		fmt.Scanln(&input)
+		if input != "" {
+			fmt.Sscanf(input, "%d", &chunkDuration)
+			if chunkDuration < 5 {
+				fmt.Fprintln(os.Stderr, "Duration too short, using minimum of 5 seconds.")
+				chunkDuration = 5
+			}
 		}
 	}
 
 	// Ask user to select microphone device
-	var micDeviceIndex int
-	if len(captureDevices) > 1 {
-		fmt.Print("\nSelect microphone by number (or press Enter for default): ")
+	var micChoice string
+	if interactive && len(captureDevices) > 1 && !settings.IsSet("mic") && !settings.IsSet("mic-name") && len(settings.Mics) == 0 {
+		fmt.Fprint(os.Stderr, "\nSelect microphone by number (or press Enter for default): ")
+		fmt.Scanln(&micChoice)
+	}
+	micNames := make([]string, len(captureDevices))
+	for i := range captureDevices {
+		micNames[i] = captureDevices[i].Name()
+	}
+	micIndices, err := chooseMicrophones(micNames, settings, micChoice)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		waitForExit()
+		return
+	}
+
+	// Ask user how microphone and speaker should be mixed
+	mixMode := settings.MixMode
+	if len(settings.ChannelMap) > 0 && !settings.IsSet("mix") {
+		// A channel map lays out the sources side by side, which is the stereo split
+		mixMode = audio.MixStereoSplit
+	} else if interactive && !settings.IsSet("mix") {
+		fmt.Fprint(os.Stderr, "\nSelect mix mode (average, weighted, sumlimit, stereo, duck, multitrack; default average): ")
+		input = ""
+		fmt.Scanln(&input)
+		if input != "" {
+			mode, err := audio.ParseMixMode(input)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Invalid mix mode, using average.")
+			} else {
+				mixMode = mode
+			}
+		}
+	}
+
+	// Ask user whether to stop automatically after a long silence
+	silenceTimeout := settings.SilenceTimeout
+	if interactive && !settings.IsSet("silence-stop") {
+		fmt.Fprint(os.Stderr, "\nStop after seconds of silence (0 to never stop, default 0): ")
 		input = ""
 		fmt.Scanln(&input)
 		if input != "" {
-			fmt.Sscanf(input, "%d", &micDeviceIndex)
-			if micDeviceIndex < 0 || micDeviceIndex >= len(captureDevices) {
-				fmt.Println("Invalid selection, using default device.")
-				micDeviceIndex = 0
+			fmt.Sscanf(input, "%d", &silenceTimeout)
+			if silenceTimeout < 0 {
+				fmt.Fprintln(os.Stderr, "Invalid timeout, recording until stopped.")
+				silenceTimeout = 0
 			}
 		}
 	}
 
-	fmt.Println("\nContinuous recording settings:")
-	fmt.Printf("- Saving every %d seconds\n", chunkDuration)
-	fmt.Println("- Recordings will be saved to:", outputFolder)
-	fmt.Println("Press Ctrl+C to stop recording and save...")
+	// Ask user whether to keep a transcription-ready copy
+	transcriptionOutput := settings.TranscriptionOutput
+	if interactive && !settings.IsSet("transcription-copy") {
+		fmt.Fprint(os.Stderr, "\nAlso save a 16kHz mono copy for transcription? (y/N): ")
+		input = ""
+		fmt.Scanln(&input)
+		transcriptionOutput = strings.EqualFold(input, "y")
+	}
+
+	// Ask user whether to add Broadcast Wave timecode
+	broadcastWave := settings.BroadcastWave
+	if interactive && !settings.IsSet("bwf") {
+		fmt.Fprint(os.Stderr, "\nWrite Broadcast Wave (BWF) timecode? (y/N): ")
+		input = ""
+		fmt.Scanln(&input)
+		broadcastWave = strings.EqualFold(input, "y")
+	}
+
+	// With -rate native the recording keeps the first microphone's own rate for the
+	// best archive quality; only the transcription copy is resampled to 16 kHz
+	sampleRate := settings.SampleRate
+	if sampleRate == 0 {
+		micDeviceID := &captureDevices[micIndices[0]].ID
+		sampleRate, err = audio.NativeSampleRate(ctx.Context, malgo.Capture, micDeviceID)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not read the microphone's native sample rate:", err)
+			waitForExit()
+			return
+		}
+	}
 
 	// Audio settings
-	sampleRate := 16000
-	channels := 1
+	channels := settings.Channels
+
+	// Float files are always 32-bit, whatever the PCM default
+	bitsPerSample := settings.BitsPerSample
+	if settings.FloatWAV && !settings.IsSet("bits") {
+		bitsPerSample = 32
+	}
+
+	// A rolling archive needs parts to encode while recording continues
+	partSeconds := settings.PartSeconds
+	if settings.ArchiveFormat != "" && !settings.IsSet("part-seconds") {
+		partSeconds = 600
+	}
+
+	// Each microphone is mixed at full level
+	micGains := make([]float32, len(micIndices))
+	for i := range micGains {
+		micGains[i] = 1
+	}
 
 	// Create recorder configuration
 	config := audio.RecordingConfig{
 		ChunkDurationSeconds: chunkDuration,
 		OutputFolder:         outputFolder,
+		SessionFolders:       settings.SessionFolders,
 		RecordingName:        recordingName,
 		SampleRate:           sampleRate,
 		Channels:             channels,
+		BitsPerSample:        bitsPerSample,
+		FloatWAV:             settings.FloatWAV,
+		MicGains:             micGains,
+		HighPrecisionMix:     settings.PreciseMix,
+		TranscriptionOutput:  transcriptionOutput,
+		DownmixWeights:       settings.DownmixWeights,
+		ResampleQuality:      settings.ResampleQuality,
+		BroadcastWave:        broadcastWave,
+		StartTone:            settings.StartTone,
+		EventLog:             settings.EventLog,
+		SpeechEvents:         settings.SpeechEvents,
+		Tags:                 settings.Tags,
+		TagsInWAV:            settings.TagWAV,
+		Manifest:             settings.Manifest,
+		MixMode:              mixMode,
+		ChannelMap:           settings.ChannelMap,
+		FsyncInterval:        time.Duration(settings.FsyncSeconds) * time.Second,
+		HeaderUpdateInterval: time.Duration(settings.HeaderSeconds) * time.Second,
+		MinWriteBlock:        time.Duration(settings.WriteBlockSeconds) * time.Second,
+		PartialFiles:         settings.PartialFiles,
+		SeekIndexInterval:    time.Duration(settings.SeekIndexSeconds) * time.Second,
+		PartDurationSeconds:  partSeconds,
+		ResumePath:           settings.ResumePath,
+		MicWeight:            0.6,
+		SpeakerWeight:        0.4,
+		DuckThreshold:        0.02,
+		DuckLevel:            0.25,
+		MixTargetPeak:        settings.MixTargetPeak,
+		MixHeadroomDB:        settings.MixHeadroomDB,
+
+		StopAfterSilenceSeconds: silenceTimeout,
+		SilenceThreshold:        0.005,
 	}
 
-	// Create continuous recorder
-	recorder := audio.NewRecorder(config)
-
-	// Set up microphone recording with specific device
-	micConfig := malgo.DeviceConfig{
-		DeviceType: malgo.Capture,
-		SampleRate: uint32(sampleRate),
-		Capture: malgo.SubConfig{
-			Format:   malgo.FormatF32,
-			Channels: uint32(channels),
-		},
-	}
-
-	// Set specific device if user selected one
-	if len(captureDevices) > 0 {
-		selectedDevice := captureDevices[micDeviceIndex]
-		fmt.Printf("Using microphone: %s\n", selectedDevice.Name())
-		micConfig.Capture.DeviceID = selectedDevice.ID.Pointer()
-	}
-
-	// Variables for microphone level monitoring
-	var micLevel float32
-	var micMutex sync.Mutex
-
-	// Start recording microphone
-	micDevice, err := malgo.InitDevice(ctx.Context, micConfig, malgo.DeviceCallbacks{
-		Data: func(output, input []byte, frameCount uint32) {
-			// Get the current time for this chunk
-			chunkTime := time.Now()
-
-			// Calculate audio level from this batch
-			level := float32(0)
-
-			// Convert input bytes to float32 slice - simple, direct conversion
-			samplesF32 := make([]float32, frameCount*uint32(channels))
-			for i := 0; i < int(frameCount*uint32(channels)); i++ {
-				if i*4+3 < len(input) {
-					var value float32
-					binary.Read(bytes.NewReader(input[i*4:i*4+4]), binary.LittleEndian, &value)
-					samplesF32[i] = value
-
-					// Calculate level (absolute value)
-					absValue := float32(0)
-					if value < 0 {
-						absValue = -value
-					} else {
-						absValue = value
-					}
-					level += absValue
-				}
-			}
-
-			// Normalize level
-			if frameCount > 0 {
-				level = level / float32(frameCount*uint32(channels))
-			}
+	fmt.Fprintln(os.Stderr, "\nContinuous recording settings:")
+	fmt.Fprintf(os.Stderr, "- Saving every %d seconds\n", chunkDuration)
+	if settings.SampleRate == 0 {
+		fmt.Fprintf(os.Stderr, "- Recording at the microphone's native %d Hz\n", sampleRate)
+	}
+	fmt.Fprintf(os.Stderr, "- Mix mode: %s\n", mixMode)
+	if len(settings.ChannelMap) > 0 {
+		fmt.Fprintf(os.Stderr, "- Channel map: %v\n", settings.ChannelMap)
+	}
+	if settings.MixTargetPeak < 1 || settings.MixHeadroomDB > 0 {
+		fmt.Fprintf(os.Stderr, "- Mix peak: %.2f with %.1f dB headroom\n", settings.MixTargetPeak, settings.MixHeadroomDB)
+	}
+	if len(micIndices) > 1 {
+		fmt.Fprintf(os.Stderr, "- Mixing %d microphones\n", len(micIndices))
+	}
+	if silenceTimeout > 0 {
+		fmt.Fprintf(os.Stderr, "- Stopping after %d seconds of silence\n", silenceTimeout)
+	}
+	if settings.RecordSeconds > 0 {
+		fmt.Fprintf(os.Stderr, "- Recording for %d seconds, about %s\n", settings.RecordSeconds,
+			formatSize(config.EstimateSize(float64(settings.RecordSeconds))))
+	} else {
+		fmt.Fprintf(os.Stderr, "- About %s per hour of recording\n", formatSize(config.EstimateSize(3600)))
+	}
+	if settings.ArchiveFormat != "" {
+		fmt.Fprintf(os.Stderr, "- Compressing finished parts to %s\n", settings.ArchiveFormat)
+	}
+	if len(settings.Tags) > 0 {
+		fmt.Fprintf(os.Stderr, "- Tags: %s\n", strings.ReplaceAll(strings.TrimSpace(audio.FormatTags(settings.Tags)), "\n", ", "))
+	}
+	if settings.Manifest || len(settings.Tags) > 0 {
+		fmt.Fprintln(os.Stderr, "- Writing a .json manifest next to each recording")
+	}
+	if settings.EventLog {
+		fmt.Fprintln(os.Stderr, "- Logging recording events to a .log file next to each recording")
+	}
+	if settings.ResumePath != "" {
+		fmt.Fprintln(os.Stderr, "- Resuming the recording in:", settings.ResumePath)
+	} else {
+		fmt.Fprintln(os.Stderr, "- Recordings will be saved to:", outputFolder)
+	}
+	if interactive {
+		fmt.Fprintln(os.Stderr, "Press Ctrl+C to stop recording and save...")
+	}
 
-			// Update level safely
-			micMutex.Lock()
-			micLevel = level
-			micMutex.Unlock()
+	// Microphones to open; nil records from the default device
+	options := sessionOptions{
+		micDevices:     make([]*malgo.DeviceInfo, len(micIndices)),
+		archiveFormat:  settings.ArchiveFormat,
+		monitor:        settings.Monitor != "",
+		monitorLatency: time.Duration(settings.MonitorLatencyMs) * time.Millisecond,
+		printConfig:    settings.PrintConfig,
+	}
+	for i, index := range micIndices {
+		options.micDevices[i] = &captureDevices[index]
+	}
 
-			// Add audio chunk to recorder
-			recorder.AddMicSamples(samplesF32, chunkTime)
-		},
-	})
-	if err != nil {
-		fmt.Println("Failed to initialize microphone:", err)
-		fmt.Println("Press Enter to exit...")
-		fmt.Scanln()
-		return
+	// Find the monitor output by name. The speaker is recorded by looping back the
+	// default output, so monitoring there would record the mix a second time in the
+	// speaker track and feed back.
+	if options.monitor {
+		if strings.EqualFold(settings.Monitor, "default") {
+			fmt.Fprintln(os.Stderr, "Cannot monitor on the default output: the speaker recording captures it. Choose another output device.")
+			waitForExit()
+			return
+		}
+		device, err := audio.FindDevice(ctx.Context, malgo.Playback, settings.Monitor)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Monitor output not found:", err)
+			waitForExit()
+			return
+		}
+		if device.IsDefault != 0 {
+			fmt.Fprintf(os.Stderr, "Cannot monitor on %s: it is the default output, which the speaker recording captures. Choose another output device.\n", device.Name())
+			waitForExit()
+			return
+		}
+		options.monitorDevice = &device
 	}
 
-	if err = micDevice.Start(); err != nil {
-		fmt.Println("Failed to start microphone:", err)
-		micDevice.Uninit()
-		fmt.Println("Press Enter to exit...")
-		fmt.Scanln()
+	// As a service, recordings are started and stopped through the control API
+	if settings.Control != "" {
+		err := serveControl(settings.Control, func() (*session, error) {
+			return startSession(ctx.Context, config, options)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Control API failed:", err)
+			ctx.Free()
+			os.Exit(1)
+		}
 		return
 	}
-	defer micDevice.Uninit()
-
-	// Set up speaker recording (loopback)
-	speakerConfig := malgo.DeviceConfig{
-		DeviceType: malgo.Loopback,
-		SampleRate: uint32(sampleRate),
-		Capture: malgo.SubConfig{
-			Format:   malgo.FormatF32,
-			Channels: uint32(channels),
-		},
-	}
-
-	// Try to start recording speakers
-	var speakerActive bool
-	speakerDevice, err := malgo.InitDevice(ctx.Context, speakerConfig, malgo.DeviceCallbacks{
-		Data: func(output, input []byte, frameCount uint32) {
-			// Get the current time for this chunk
-			chunkTime := time.Now()
-
-			// Convert input bytes to float32 slice - simple, direct conversion
-			samplesF32 := make([]float32, frameCount*uint32(channels))
-			for i := 0; i < int(frameCount*uint32(channels)); i++ {
-				if i*4+3 < len(input) {
-					var value float32
-					binary.Read(bytes.NewReader(input[i*4:i*4+4]), binary.LittleEndian, &value)
-					samplesF32[i] = value
-				}
-			}
 
-			// Add audio chunk to recorder
-			recorder.AddSpeakerSamples(samplesF32, chunkTime)
-		},
-	})
+	// Open the devices and start recording
+	recording, err := startSession(ctx.Context, config, options)
 	if err != nil {
-		fmt.Println("Failed to initialize speaker:", err)
-		fmt.Println("Will continue with microphone only.")
-	} else {
-		if err = speakerDevice.Start(); err != nil {
-			fmt.Println("Failed to start speaker:", err)
-			speakerDevice.Uninit()
-			fmt.Println("Will continue with microphone only.")
-		} else {
-			defer speakerDevice.Uninit()
-			speakerActive = true
-		}
+		fmt.Fprintln(os.Stderr, "Could not start recording:", err)
+		waitForExit()
+		return
 	}
+	recorder := recording.recorder
 
-	// Start the continuous recording process
-	recorder.StartRecording()
-
-	// Print recording status with microphone level indicator
+	// Print recording status with per-source level indicators
 	stopDisplaying := make(chan bool)
 
 	go func() {
+		// Refresh on a ticker so the loop blocks between updates instead of polling
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
 		for {
 			select {
 			case <-stopDisplaying:
 				return
-			default:
-				elapsed := time.Since(recorder.GetStartTime())
-				nextSaveIn := time.Duration(config.ChunkDurationSeconds)*time.Second -
+			case <-ticker.C:
+				elapsed := recorder.GetRecordingDuration()
+				nextSaveIn := recorder.GetChunkDuration() -
 					time.Since(recorder.GetCurrentChunkStartTime())
 
-				// Get current mic level safely
-				micMutex.Lock()
-				currentLevel := micLevel
-				micMutex.Unlock()
-
-				// Create audio level meter
-				const meterWidth = 20
-				level := int(currentLevel * 100)
-				if level > 100 {
-					level = 100
-				}
-				bar := int(level * meterWidth / 100)
-
-				meter := "["
-				for i := 0; i < meterWidth; i++ {
-					if i < bar {
-						meter += "#"
-					} else {
-						meter += " "
+				// Create audio level meters for each source, per side for stereo
+				var meters string
+				if channels == 2 {
+					meters = "Mic: " + channelMeters(recorder.MicChannelLevels())
+					if recorder.IsSpeakerEnabled() {
+						meters += "  Spk: " + channelMeters(recorder.SpeakerChannelLevels())
+					}
+				} else {
+					meters = "Mic: " + levelMeter(recorder.MicLevel())
+					if recorder.IsSpeakerEnabled() {
+						meters += "  Spk: " + levelMeter(recorder.SpeakerLevel())
 					}
 				}
-				meter += "]"
 
 				// Show recording stats
-				fmt.Printf("\rRecording... %02d:%02d:%02d  Mic: %s %d%%  Next save: %02d:%02d  File: %s",
+				fmt.Fprintf(os.Stderr, "\rRecording... %02d:%02d:%02d  %s  Next save: %02d:%02d  File: %s",
 					int(elapsed.Hours()),
 					int(elapsed.Minutes())%60,
 					int(elapsed.Seconds())%60,
-					meter, level,
+					meters,
 					int(nextSaveIn.Minutes())%60,
 					int(nextSaveIn.Seconds())%60,
 					filepath.Base(recorder.GetOutputFilePath()))
+			}
+		}
+	}()
 
-				time.Sleep(100 * time.Millisecond)
+	// Start a new file on SIGHUP, e.g. from a cron job, without stopping the recording
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := recorder.Rotate(); err != nil {
+				fmt.Fprintln(os.Stderr, "\nCould not rotate recording:", err)
+				continue
 			}
+			fmt.Fprintln(os.Stderr, "\nRotated, now recording to:", recorder.GetOutputFilePath())
 		}
 	}()
 
-	// Wait for Ctrl+C to stop recording
+	// Stop after a fixed length when requested
+	var recordTimeout <-chan time.Time
+	if settings.RecordSeconds > 0 {
+		recordTimeout = time.After(time.Duration(settings.RecordSeconds) * time.Second)
+	}
+
+	// Wait for Ctrl+C, the fixed length, or for the recorder to stop itself after a long silence
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	terminated := false
+	select {
+	case sig := <-c:
+		terminated = sig == syscall.SIGTERM
+	case <-recordTimeout:
+	case <-recorder.Done():
+	}
 
 	// Stop status display
 	close(stopDisplaying)
-	fmt.Println("\nStopping recording...")
+	fmt.Fprintln(os.Stderr, "\nStopping recording...")
+
+	recording.stop()
+
+	if recorder.IsEmptyRecording() {
+		fmt.Fprintln(os.Stderr, "No audio was captured.")
+	} else if recording.archiver != nil {
+		fmt.Fprintf(os.Stderr, "Recording archived as %s parts in: %s\n", settings.ArchiveFormat, recorder.SessionFolder())
+	} else {
+		fmt.Fprintln(os.Stderr, "Recording saved successfully to:", recorder.GetOutputFilePath())
+		if transcriptionOutput {
+			fmt.Fprintln(os.Stderr, "Transcription copy saved to:", recorder.GetTranscriptionFilePath())
+		}
+	}
+
+	// The saved files are the one thing on stdout, one per line, for scripts to pick up
+	for _, path := range recording.files() {
+		fmt.Println(path)
+	}
+
+	// Fixed-length recordings are meant for scripts and SIGTERM comes from a service
+	// manager such as systemd or docker stop, so exit without waiting
+	if settings.RecordSeconds > 0 || terminated {
+		return
+	}
+	waitForExit()
+}
 
-	// Stop audio devices
-	micDevice.Stop()
-	if speakerActive {
-		speakerDevice.Stop()
+// chooseMicrophones returns the indices of the capture devices, given by name, to
+// record: the -mics list when given, else the device matching -mic-name, else the one
+// typed at the prompt (choice, empty when not asked or left empty), else -mic. A -mic
+// or typed number without a device falls back to the first one with a warning.
+func chooseMicrophones(devices []string, settings Settings, choice string) ([]int, error) {
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no capture devices found")
 	}
 
-	// Stop and finalize the recording
-	recorder.StopRecording()
+	if len(settings.Mics) > 0 {
+		for _, index := range settings.Mics {
+			if index >= len(devices) {
+				return nil, fmt.Errorf("invalid microphone number %d, only %d microphones found", index, len(devices))
+			}
+		}
+		return settings.Mics, nil
+	}
+	if settings.IsSet("mic-name") {
+		index, err := audio.MatchDeviceName(devices, settings.MicName)
+		if err != nil {
+			return nil, fmt.Errorf("microphone not found: %w", err)
+		}
+		return []int{index}, nil
+	}
 
-	fmt.Println("Recording saved successfully to:", recorder.GetOutputFilePath())
-	fmt.Println("Press Enter to exit...")
+	index := settings.MicIndex
+	if choice != "" {
+		if _, err := fmt.Sscanf(choice, "%d", &index); err != nil {
+			index = -1
+		}
+	}
+	if index < 0 || index >= len(devices) {
+		fmt.Fprintln(os.Stderr, "Invalid microphone number, using default device.")
+		index = 0
+	}
+	return []int{index}, nil
+}
+
+// waitForExit keeps the console open until Enter is pressed, so the messages stay
+// readable when the recorder runs in its own window. Without a terminal on stdin
+// nobody can press Enter, so it returns at once.
+func waitForExit() {
+	if !stdinIsTerminal() {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Press Enter to exit...")
 	fmt.Scanln()
 }
+
+// stdinIsTerminal returns whether stdin is a console or other character device
+// rather than a file or pipe
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// checkLoopback runs the loopback self-test and prints the result with troubleshooting hints
+func checkLoopback(ctx malgo.Context, sampleRate, channels int) bool {
+	fmt.Fprintln(os.Stderr, "\nPlaying a test tone and listening on the speaker loopback...")
+	level, err := audio.VerifyLoopback(ctx, sampleRate, channels, 3*time.Second)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "FAIL:", err)
+		fmt.Fprintln(os.Stderr, "Troubleshooting:")
+		fmt.Fprintln(os.Stderr, "- Make sure the default output device is the one you are listening on")
+		fmt.Fprintln(os.Stderr, "- Check that the output is not muted and the volume is up")
+		fmt.Fprintln(os.Stderr, "- On Windows, enable 'Stereo Mix' or select the correct output device in Sound settings")
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "PASS: loopback captured the test tone (level %s)\n", levelMeter(level))
+	return true
+}
+
+// listBackends prints the audio backends of this platform and whether each can be used
+func listBackends() {
+	fmt.Fprintln(os.Stderr, "Audio backends on this platform, in default order:")
+	for _, backend := range audio.PlatformBackends() {
+		if err := audio.BackendAvailable(backend); err != nil {
+			fmt.Fprintf(os.Stderr, "  %-10s  not available (%v)\n", audio.BackendName(backend), err)
+		} else {
+			fmt.Fprintf(os.Stderr, "  %-10s  available\n", audio.BackendName(backend))
+		}
+	}
+}
+
+// extractClip saves a segment of a recording as a WAV file next to it, named after
+// the segment's times, and reports the result
+func extractClip(path string, segment []float64) bool {
+	if len(segment) != 2 {
+		fmt.Fprintln(os.Stderr, "-extract needs a -segment start,end in seconds")
+		return false
+	}
+
+	clipPath := fmt.Sprintf("%s_%gs-%gs.wav", strings.TrimSuffix(path, filepath.Ext(path)), segment[0], segment[1])
+	if err := audio.ExtractSegmentToFile(path, clipPath, segment[0], segment[1]); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not extract segment:", err)
+		return false
+	}
+	fmt.Fprintln(os.Stderr, "Clip saved to:", clipPath)
+	fmt.Println(clipPath)
+	return true
+}
+
+// formatSize renders a byte count in megabytes, or gigabytes from 1 GB
+func formatSize(bytes int64) string {
+	megabytes := float64(bytes) / (1024 * 1024)
+	if megabytes >= 1024 {
+		return fmt.Sprintf("%.1f GB", megabytes/1024)
+	}
+	return fmt.Sprintf("%.1f MB", megabytes)
+}
+
+// levelMeter renders an audio level as a bar meter with a percentage
+func levelMeter(currentLevel float32) string {
+	level := meterPercent(currentLevel)
+	return fmt.Sprintf("%s %3d%%", meterBar(level, 20), level)
+}
+
+// channelMeters renders a compact left/right meter pair for stereo input
+func channelMeters(levels []float32) string {
+	if len(levels) < 2 {
+		return levelMeter(0)
+	}
+	return "L" + meterBar(meterPercent(levels[0]), 8) + " R" + meterBar(meterPercent(levels[1]), 8)
+}
+
+// meterPercent converts an audio level to a percentage capped at 100
+func meterPercent(currentLevel float32) int {
+	level := int(currentLevel * 100)
+	if level > 100 {
+		level = 100
+	}
+	return level
+}
+
+// meterBar draws a bar of the given width filled to level percent
+func meterBar(level, width int) string {
+	bar := level * width / 100
+
+	meter := "["
+	for i := 0; i < width; i++ {
+		if i < bar {
+			meter += "#"
+		} else {
+			meter += " "
+		}
+	}
+	meter += "]"
+
+	return meter
+}
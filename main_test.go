@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChooseMicrophones(t *testing.T) {
+	devices := []string{"Built-in Microphone", "USB Headset", "USB Audio Interface"}
+
+	tests := []struct {
+		name    string
+		devices []string
+		args    []string
+		choice  string
+		want    []int
+		wantErr string
+	}{
+		{"NoDevices", nil, nil, "", nil, "no capture devices"},
+		{"NoDevicesWithChoice", []string{}, []string{"-mic", "0"}, "1", nil, "no capture devices"},
+		{"Default", devices, nil, "", []int{0}, ""},
+		{"MicIndex", devices, []string{"-mic", "2"}, "", []int{2}, ""},
+		{"MicIndexOutOfRange", devices, []string{"-mic", "5"}, "", []int{0}, ""},
+		{"Choice", devices, nil, "1", []int{1}, ""},
+		{"ChoiceOverMicIndex", devices, []string{"-mic", "2"}, "1", []int{1}, ""},
+		{"ChoiceOutOfRange", devices, nil, "7", []int{0}, ""},
+		{"ChoiceNotANumber", devices, nil, "headset", []int{0}, ""},
+		{"Mics", devices, []string{"-mics", "2,0"}, "", []int{2, 0}, ""},
+		{"MicsOutOfRange", devices, []string{"-mics", "0,3"}, "", nil, "invalid microphone number 3"},
+		{"MicName", devices, []string{"-mic-name", "headset"}, "", []int{1}, ""},
+		{"MicNameExact", devices, []string{"-mic-name", "usb headset"}, "", []int{1}, ""},
+		{"MicNameAmbiguous", devices, []string{"-mic-name", "usb"}, "", nil, "matches several devices"},
+		{"MicNameMissing", devices, []string{"-mic-name", "webcam"}, "", nil, "microphone not found"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := resolveTestConfig(t, tc.args, "", nil)
+			if err != nil {
+				t.Fatalf("ResolveConfig(%v): %v", tc.args, err)
+			}
+
+			got, err := chooseMicrophones(tc.devices, settings, tc.choice)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("chooseMicrophones = %v, %v; want an error containing %q", got, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("chooseMicrophones: %v", err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("chooseMicrophones = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
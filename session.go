@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/galfthan/audiorecorder/audio"
+	"github.com/gen2brain/malgo"
+)
+
+// session is one recording together with the capture devices feeding it
+type session struct {
+	ctx      malgo.Context
+	config   audio.RecordingConfig
+	recorder *audio.Recorder
+	archiver *audio.Archiver
+	monitor  *audio.Monitor
+	stopOnce sync.Once
+}
+
+// sessionOptions selects the devices and companions of a session
+type sessionOptions struct {
+	micDevices     []*malgo.DeviceInfo // A nil entry records from the default microphone
+	archiveFormat  string              // Compress finished parts to this format; empty keeps WAV
+	monitor        bool                // Play the mix on an output device
+	monitorDevice  *malgo.DeviceInfo   // Monitor output; nil uses the default device
+	monitorLatency time.Duration
+	printConfig    bool // Print the resolved configuration and devices as JSON once started
+}
+
+// startSession creates a recorder, opens its devices and starts recording
+func startSession(ctx malgo.Context, config audio.RecordingConfig, options sessionOptions) (*session, error) {
+	// The monitor plays the mix, so mix often enough to keep its queue filled
+	if options.monitor {
+		config.MixInterval = options.monitorLatency / 4
+	}
+	recorder, err := audio.NewRecorderE(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recording configuration: %w", err)
+	}
+	s := &session{ctx: ctx, config: config, recorder: recorder}
+
+	// Compress each finished part in the background, keeping only the current one as WAV
+	if options.archiveFormat != "" {
+		s.archiver = audio.NewArchiver(audio.NewFFmpegEncoder(options.archiveFormat))
+		recorder.SetFileCompleteHandler(s.archiver.Submit)
+	}
+
+	// Start recording each microphone. Every device is opened at its own sample rate,
+	// which the recorder converts to the recording's, and the recording's channel count.
+	var described []deviceDump
+	for i, device := range options.micDevices {
+		micCapturer, err := s.openMicrophone(i, device)
+		if err != nil {
+			s.release()
+			return nil, err
+		}
+		described = append(described, describeDevice(fmt.Sprintf("microphone %d", i+1), micCapturer))
+	}
+
+	// Try to start recording speakers (loopback)
+	speakerCapturer, err := recorder.OpenSpeakerDevice(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "Will continue with microphone only.")
+		recorder.DisableSpeaker()
+	} else {
+		described = append(described, describeDevice("speaker", speakerCapturer))
+	}
+
+	// Play the mix on the monitor output. Its tap is taken once the speaker is settled,
+	// as disabling the speaker replaces the mixed output.
+	if options.monitor {
+		s.startMonitor(options)
+	}
+
+	// Start the continuous recording process
+	if err := recorder.StartRecording(); err != nil {
+		s.release()
+		return nil, fmt.Errorf("starting recording: %w", err)
+	}
+	if options.printConfig {
+		s.printConfig(described)
+	}
+
+	return s, nil
+}
+
+// openMicrophone opens and starts the capture device feeding microphone index.
+// A nil device selects the default microphone.
+func (s *session) openMicrophone(index int, device *malgo.DeviceInfo) (*audio.Capturer, error) {
+	if device != nil {
+		fmt.Fprintf(os.Stderr, "Using microphone: %s\n", device.Name())
+	}
+
+	micCapturer, err := s.recorder.OpenMicDevice(s.ctx, index, device)
+	if err != nil {
+		return nil, err
+	}
+
+	// Float devices are often mixer output rather than true high-resolution capture
+	bits := micCapturer.BitsPerSample()
+	if micCapturer.Format() != malgo.FormatF32 && bits > s.config.OutputBits() {
+		fmt.Fprintf(os.Stderr, "Microphone delivers %d-bit audio; use -bits %d to keep its full resolution\n",
+			bits, bits)
+	}
+
+	return micCapturer, nil
+}
+
+// startMonitor starts playing the recording's mix on the monitor output. The monitor
+// is never the default output, which the speaker recording captures, so the speaker
+// can be monitored too without feeding back. A monitor that fails to start is skipped.
+func (s *session) startMonitor(options sessionOptions) {
+	var monitorDeviceID *malgo.DeviceID
+	if options.monitorDevice != nil {
+		fmt.Fprintf(os.Stderr, "Monitoring on: %s\n", options.monitorDevice.Name())
+		monitorDeviceID = &options.monitorDevice.ID
+	}
+
+	tap := s.recorder.NewMixedTap()
+	monitor, err := audio.NewMonitor(s.ctx, monitorDeviceID, tap, options.monitorLatency)
+	if err != nil {
+		tap.Close()
+	} else if err = monitor.Start(); err != nil {
+		monitor.Uninit()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to start monitor:", err)
+		fmt.Fprintln(os.Stderr, "Will continue without monitoring.")
+		return
+	}
+	s.monitor = monitor
+}
+
+// switchMicrophone moves microphone index to the capture device whose name contains
+// name, without stopping the recording (see Recorder.SwitchMicDevice)
+func (s *session) switchMicrophone(index int, name string) error {
+	if !s.recorder.IsRecording() {
+		return fmt.Errorf("not recording")
+	}
+	if index < 0 || index >= s.config.MicCount() {
+		return fmt.Errorf("no microphone %d", index+1)
+	}
+	device, err := audio.FindDevice(s.ctx, malgo.Capture, name)
+	if err != nil {
+		return err
+	}
+	return s.recorder.SwitchMicDevice(s.ctx, index, device)
+}
+
+// configDump is the JSON printed by -print-config: the recording configuration as
+// resolved from flags, environment, config file and prompts, and what each device
+// actually negotiated
+type configDump struct {
+	Recording audio.RecordingConfig `json:"recording"`
+	File      string                `json:"file"`
+	Speaker   bool                  `json:"speaker"`
+	HourBytes int64                 `json:"estimated_bytes_per_hour"`
+	Devices   []deviceDump          `json:"devices"`
+}
+
+// deviceDump describes the format negotiated with one capture device
+type deviceDump struct {
+	Source     string `json:"source"`
+	SampleRate int    `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+	Format     string `json:"format"`
+}
+
+// printConfig prints the session's configuration and devices as JSON to stdout, for
+// tools that record how each recording was made
+func (s *session) printConfig(devices []deviceDump) {
+	dump := configDump{
+		Recording: s.config,
+		File:      s.recorder.GetOutputFilePath(),
+		Speaker:   s.recorder.IsSpeakerEnabled(),
+		HourBytes: s.config.EstimateSize(3600),
+		Devices:   devices,
+	}
+
+	encoded, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encoding configuration:", err)
+		return
+	}
+	fmt.Println(string(encoded))
+}
+
+// describeDevice returns the format a capture device negotiated, for printConfig
+func describeDevice(source string, capturer *audio.Capturer) deviceDump {
+	return deviceDump{
+		Source:     source,
+		SampleRate: capturer.SampleRate(),
+		Channels:   capturer.Channels(),
+		Format:     audio.FormatName(capturer.Format()),
+	}
+}
+
+// stop stops the devices and finalizes the recording. It is safe to call more than
+// once, and after the recorder has already stopped itself after a long silence.
+func (s *session) stop() {
+	s.stopOnce.Do(func() {
+		// Stop audio devices; Stop waits for in-flight callbacks so nothing is lost
+		s.recorder.StopDevices()
+
+		// Flush the drained buffers and finalize the recording
+		s.recorder.StopRecording()
+		if s.archiver != nil {
+			fmt.Fprintln(os.Stderr, "Compressing the last part...")
+		}
+		s.release()
+	})
+}
+
+// files returns the finished recording's files: the compressed copies when archiving,
+// else the WAV files, and the transcription copy
+func (s *session) files() []string {
+	files := s.recorder.OutputFiles()
+	if s.archiver == nil || len(files) == 0 {
+		return files
+	}
+	archived := s.archiver.Outputs()
+	if transcription := s.recorder.GetTranscriptionFilePath(); transcription != "" {
+		archived = append(archived, transcription)
+	}
+	return archived
+}
+
+// release closes the devices and the archiver
+func (s *session) release() {
+	s.recorder.CloseDevices()
+	if s.monitor != nil {
+		s.monitor.Uninit()
+	}
+	if s.archiver != nil {
+		s.archiver.Close()
+	}
+}